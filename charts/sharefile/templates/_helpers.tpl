@@ -56,3 +56,15 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+Fail on config which can not work with deployment settings
+*/}}
+{{- define "sharefile.validateValues" -}}
+{{- if and (eq (.Values.config.sessions.type | default "storage") "memory") (or (gt (int .Values.replicaCount) 1) .Values.autoscaling.enabled) }}
+{{- fail "config.sessions.type memory can not be used with several replicas, use storage or redis sessions" }}
+{{- end }}
+{{- if and .Values.ingress.enabled (empty .Values.config.trusted_proxies) }}
+{{- fail "config.trusted_proxies should contain cidrs of ingress controller, otherwise client ip of every request is ingress ip" }}
+{{- end }}
+{{- end }}
@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	ActionLogin           = "login"
+	ActionUpload          = "upload"
+	ActionDownload        = "download"
+	ActionDelete          = "delete"
+	ActionMove            = "move"
+	ActionLinkGenerated   = "link_generated"
+	ActionShareViewed     = "share_viewed"
+	ActionRssFetched      = "rss_fetched"
+	ActionSecretsRotated  = "secrets_rotated"
+	ActionUserStateChange = "user_state_changed"
+
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Event answers who did what with which object and when
+type Event struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	// Actor is authenticated user, empty for anonymous share and rss requests
+	Actor string `json:"actor,omitempty"`
+	// Owner is user whose files are accessed, it differs from actor for admins and share visitors
+	Owner string `json:"owner,omitempty"`
+	Path  string `json:"path,omitempty"`
+	// NewPath is destination of move
+	NewPath   string `json:"new_path,omitempty"`
+	Ip        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	RequestId string `json:"request_id,omitempty"`
+	// Interface is web, raw, webdav or s3
+	Interface string `json:"interface,omitempty"`
+	// Details describe change which has no path, e.g. new state of user
+	Details map[string]string `json:"details,omitempty"`
+	Result  string            `json:"result"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// Sink stores events separately from access log, Write must not block request for long
+type Sink interface {
+	Write(event *Event) error
+	// Close flushes buffered events
+	Close(ctx context.Context) error
+}
@@ -0,0 +1,42 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// fileSink appends events as json lines
+type fileSink struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+func NewFileSink(path string) (Sink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("cant open audit file: %w", err)
+	}
+	return &fileSink{file: file}, nil
+}
+
+func (s *fileSink) Write(event *Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("cant marshal audit event: %w", err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("cant write audit event: %w", err)
+	}
+	return nil
+}
+
+func (s *fileSink) Close(_ context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
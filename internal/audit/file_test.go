@@ -0,0 +1,72 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readEvents(t *testing.T, path string) []Event {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %q is not event: %s", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for _, action := range []string{ActionUpload, ActionUserStateChange} {
+		// sink is reopened, as on restart, so events must be appended
+		sink, err := NewFileSink(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		event := &Event{Time: time.Now(), Action: action, Owner: "user@example.com", Result: ResultSuccess}
+		if action == ActionUserStateChange {
+			event.Details = map[string]string{"disabled": "true"}
+		}
+		if err := sink.Write(event); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events := readEvents(t, path)
+	if len(events) != 2 || events[0].Action != ActionUpload || events[1].Action != ActionUserStateChange {
+		t.Fatalf("events %+v, want upload and user state change", events)
+	}
+	if events[1].Details["disabled"] != "true" || events[1].Path != "" {
+		t.Fatalf("user state change has path %q and details %v, want details only", events[1].Path, events[1].Details)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Fatalf("audit file mode %o, want 600", mode)
+	}
+}
+
+func TestFileSinkInvalidPath(t *testing.T) {
+	if _, err := NewFileSink(filepath.Join(t.TempDir(), "missing", "audit.log")); err == nil {
+		t.Fatal("sink is created in missing directory")
+	}
+}
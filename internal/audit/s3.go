@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/uuid"
+	"github.com/paragor/sharefile/internal/log"
+)
+
+// S3Prefix starts with underscore, so it never collides with user directories
+const S3Prefix = "_audit/"
+
+// s3Sink buffers events and uploads them as json lines objects under daily prefix: _audit/<yyyy-mm-dd>/<time>-<id>.jsonl,
+// objects are immutable, so replicas never overwrite each other
+type s3Sink struct {
+	client *s3.S3
+	bucket string
+
+	lock   sync.Mutex
+	buffer bytes.Buffer
+	day    string
+
+	// uploads of previous days are awaited on close
+	uploads sync.WaitGroup
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func NewS3Sink(client *s3.S3, bucket string, flushInterval time.Duration) Sink {
+	s := &s3Sink{
+		client:  client,
+		bucket:  bucket,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.flushLoop(flushInterval)
+	return s
+}
+
+func (s *s3Sink) Write(event *Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("cant marshal audit event: %w", err)
+	}
+	day := event.Time.UTC().Format(time.DateOnly)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.day != day && s.buffer.Len() > 0 {
+		// events of previous day are uploaded in background, so request is not blocked by s3
+		s.uploads.Add(1)
+		go s.upload(s.day, bytes.Clone(s.buffer.Bytes()))
+		s.buffer.Reset()
+	}
+	s.day = day
+	s.buffer.Write(append(line, '\n'))
+	return nil
+}
+
+func (s *s3Sink) flushLoop(interval time.Duration) {
+	defer close(s.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flush(context.Background())
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *s3Sink) flush(ctx context.Context) error {
+	s.lock.Lock()
+	day, data := s.day, bytes.Clone(s.buffer.Bytes())
+	s.buffer.Reset()
+	s.lock.Unlock()
+	if len(data) == 0 {
+		return nil
+	}
+	return s.uploadWithContext(ctx, day, data)
+}
+
+func (s *s3Sink) upload(day string, data []byte) {
+	defer s.uploads.Done()
+	_ = s.uploadWithContext(context.Background(), day, data)
+}
+
+func (s *s3Sink) uploadWithContext(ctx context.Context, day string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	key := S3Prefix + day + "/" + time.Now().UTC().Format("150405.000000000") + "-" + uuid.NewString() + ".jsonl"
+	_, err := s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		// audit events must not be lost silently, they are at least in server logs
+		log.FromContext(ctx).With(log.Error(err), slog.String("key", key), slog.String("events", string(data))).Error("cant upload audit events")
+		return fmt.Errorf("cant upload audit events: %w", err)
+	}
+	return nil
+}
+
+func (s *s3Sink) Close(ctx context.Context) error {
+	close(s.stop)
+	<-s.stopped
+	s.uploads.Wait()
+	return s.flush(ctx)
+}
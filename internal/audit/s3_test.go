@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const fakeS3Bucket = "bucket"
+
+// fakeS3 stores uploaded objects, it implements only put
+type fakeS3 struct {
+	lock sync.Mutex
+	objs map[string][]byte
+	fail bool
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *s3.S3) {
+	fake := &fakeS3{objs: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	cfg := aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("access", "secret", "")).
+		WithEndpoint(server.URL).
+		WithRegion("us-east-1").
+		WithS3ForcePathStyle(true).
+		WithMaxRetries(0)
+	return fake, s3.New(session.Must(session.NewSession(cfg)))
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if r.Method != http.MethodPut || f.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	data, _ := io.ReadAll(r.Body)
+	f.objs[strings.TrimPrefix(r.URL.Path, "/"+fakeS3Bucket+"/")] = data
+}
+
+// events returns uploaded events by object key
+func (f *fakeS3) events(t *testing.T) map[string][]Event {
+	t.Helper()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	result := map[string][]Event{}
+	for key, data := range f.objs {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var event Event
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				t.Fatalf("object %s has invalid line %q: %s", key, scanner.Text(), err)
+			}
+			result[key] = append(result[key], event)
+		}
+	}
+	return result
+}
+
+func TestS3Sink(t *testing.T) {
+	fake, client := newFakeS3(t)
+	sink := NewS3Sink(client, fakeS3Bucket, time.Hour)
+	yesterday := time.Date(2026, 10, 17, 23, 59, 0, 0, time.UTC)
+	today := yesterday.Add(2 * time.Minute)
+	for _, event := range []*Event{
+		{Time: yesterday, Action: ActionLogin, Result: ResultSuccess},
+		{Time: yesterday, Action: ActionUpload, Result: ResultSuccess},
+		{Time: today, Action: ActionDelete, Result: ResultSuccess},
+	} {
+		if err := sink.Write(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	uploaded := fake.events(t)
+	var keys []string
+	for key := range uploaded {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || !strings.HasPrefix(keys[0], S3Prefix+"2026-10-17/") || !strings.HasPrefix(keys[1], S3Prefix+"2026-10-18/") {
+		t.Fatalf("uploaded %v, want one object per day", keys)
+	}
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".jsonl") {
+			t.Fatalf("object %s is not jsonl", key)
+		}
+	}
+	if events := uploaded[keys[0]]; len(events) != 2 || events[0].Action != ActionLogin || events[1].Action != ActionUpload {
+		t.Fatalf("events of previous day %+v, want login and upload", events)
+	}
+	if events := uploaded[keys[1]]; len(events) != 1 || events[0].Action != ActionDelete {
+		t.Fatalf("events of today %+v, want delete", events)
+	}
+}
+
+func TestS3SinkFlushInterval(t *testing.T) {
+	fake, client := newFakeS3(t)
+	sink := NewS3Sink(client, fakeS3Bucket, 10*time.Millisecond)
+	defer sink.Close(context.Background())
+	if err := sink.Write(&Event{Time: time.Now(), Action: ActionUpload, Result: ResultSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fake.events(t)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("events are not uploaded by flush loop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestS3SinkUploadFailure(t *testing.T) {
+	fake, client := newFakeS3(t)
+	fake.fail = true
+	sink := NewS3Sink(client, fakeS3Bucket, time.Hour)
+	if err := sink.Write(&Event{Time: time.Now(), Action: ActionUpload, Result: ResultSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(context.Background()); err == nil {
+		t.Fatal("close does not report failed upload")
+	}
+}
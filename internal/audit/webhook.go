@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/paragor/sharefile/internal/log"
+)
+
+// webhookQueueSize bounds memory if receiver is down, overflowed events are written to server logs
+const webhookQueueSize = 1024
+
+// webhookSink posts every event as json to url from background worker
+type webhookSink struct {
+	url    string
+	client *http.Client
+	queue  chan []byte
+	done   chan struct{}
+}
+
+func NewWebhookSink(url string, timeout time.Duration) Sink {
+	s := &webhookSink{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan []byte, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+	go s.worker()
+	return s
+}
+
+func (s *webhookSink) Write(event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("cant marshal audit event: %w", err)
+	}
+	select {
+	case s.queue <- body:
+		return nil
+	default:
+		return fmt.Errorf("audit webhook queue is full, event is dropped: %s", body)
+	}
+}
+
+func (s *webhookSink) worker() {
+	defer close(s.done)
+	for body := range s.queue {
+		if err := s.post(body); err != nil {
+			log.FromContext(context.Background()).With(log.Error(err), slog.String("event", string(body))).Error("cant send audit event")
+		}
+	}
+}
+
+func (s *webhookSink) post(body []byte) error {
+	response, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cant post audit event: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("audit webhook responded with %d", response.StatusCode)
+	}
+	return nil
+}
+
+// Close waits until queued events are sent
+func (s *webhookSink) Close(ctx context.Context) error {
+	close(s.queue)
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("audit events are not sent: %w", ctx.Err())
+	}
+}
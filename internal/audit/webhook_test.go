@@ -0,0 +1,107 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeReceiver struct {
+	lock    sync.Mutex
+	actions []string
+	// release blocks requests until it is closed, if set, blocked requests are reported to started
+	release chan struct{}
+	started chan struct{}
+}
+
+func (f *fakeReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.release != nil {
+		select {
+		case f.started <- struct{}{}:
+		default:
+		}
+		<-f.release
+	}
+	body, _ := io.ReadAll(r.Body)
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil || r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.lock.Lock()
+	f.actions = append(f.actions, event.Action)
+	f.lock.Unlock()
+}
+
+func TestWebhookSink(t *testing.T) {
+	receiver := &fakeReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	sink := NewWebhookSink(server.URL, time.Second)
+	for _, action := range []string{ActionLogin, ActionUpload, ActionDelete} {
+		if err := sink.Write(&Event{Time: time.Now(), Action: action, Result: ResultSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	receiver.lock.Lock()
+	defer receiver.lock.Unlock()
+	if len(receiver.actions) != 3 || receiver.actions[0] != ActionLogin || receiver.actions[2] != ActionDelete {
+		t.Fatalf("received %v, want all events in order", receiver.actions)
+	}
+}
+
+func TestWebhookSinkQueueFull(t *testing.T) {
+	receiver := &fakeReceiver{release: make(chan struct{}), started: make(chan struct{}, 1)}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	sink := NewWebhookSink(server.URL, time.Minute)
+
+	// worker holds first event in blocked request, the rest wait in queue
+	if err := sink.Write(&Event{Time: time.Now(), Action: ActionUpload, Result: ResultSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	<-receiver.started
+	var err error
+	for range webhookQueueSize + 1 {
+		if err = sink.Write(&Event{Time: time.Now(), Action: ActionUpload, Result: ResultSuccess}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		t.Fatal("event is accepted by full queue")
+	}
+	close(receiver.release)
+	if err := sink.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	receiver.lock.Lock()
+	defer receiver.lock.Unlock()
+	if len(receiver.actions) != webhookQueueSize+1 {
+		t.Fatalf("received %d events, want %d accepted ones", len(receiver.actions), webhookQueueSize+1)
+	}
+}
+
+func TestWebhookSinkCloseTimeout(t *testing.T) {
+	receiver := &fakeReceiver{release: make(chan struct{}), started: make(chan struct{}, 1)}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	defer close(receiver.release)
+	sink := NewWebhookSink(server.URL, time.Minute)
+	if err := sink.Write(&Event{Time: time.Now(), Action: ActionUpload, Result: ResultSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := sink.Close(ctx); err == nil {
+		t.Fatal("close does not report events which are not sent")
+	}
+}
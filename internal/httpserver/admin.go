@@ -0,0 +1,281 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/paragor/sharefile/internal/audit"
+	"github.com/paragor/sharefile/internal/log"
+	"github.com/paragor/sharefile/internal/sessions"
+	"github.com/paragor/sharefile/internal/storage"
+)
+
+// AuthAdminConfig grants admin role to members of any of groups and to listed emails
+type AuthAdminConfig struct {
+	Groups []string
+	Emails []string
+}
+
+func (c *AuthConfig) isAdmin(email string, groups []string) bool {
+	if c.Admin == nil {
+		return false
+	}
+	if slices.Contains(c.Admin.Emails, email) {
+		return true
+	}
+	return slices.ContainsFunc(groups, func(group string) bool {
+		return slices.Contains(c.Admin.Groups, group)
+	})
+}
+
+// AdminMiddleware should be used after AuthMiddleware
+func (s *httpServer) AdminMiddleware() mux.MiddlewareFunc {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			auth, err := s.extractAuthContext(request)
+			if err != nil {
+				httpError(request.Context(), writer, "error on getting auth context", err, http.StatusInternalServerError)
+				return
+			}
+			if !auth.IsAdmin {
+				httpError(request.Context(), writer, "access denied", fmt.Errorf("user '%s' is not admin", auth.Email), http.StatusForbidden)
+				return
+			}
+			handler.ServeHTTP(writer, request)
+		})
+	}
+}
+
+type adminUsersContext struct {
+	Users []adminUsersContextUser
+	// LogLevel is current level of server logs, admin may change it without restart
+	LogLevel  string
+	LogLevels []string
+}
+type adminUsersContextUser struct {
+	Email    string
+	Disabled bool
+	// Loaded is false until stats are requested by row, so page does not list files of every user
+	Loaded     bool
+	Files      int
+	UsageHuman string
+}
+
+func (s *httpServer) htmxPageAdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := s.storage.ListUsers(r.Context())
+	if err != nil {
+		httpError(r.Context(), w, "unable to list users", err, http.StatusInternalServerError)
+		return
+	}
+
+	renderUsers := make([]adminUsersContextUser, 0, len(users))
+	for _, meta := range users {
+		renderUsers = append(renderUsers, adminUsersContextUser{
+			Email:    meta.Email,
+			Disabled: meta.Disabled,
+		})
+	}
+
+	usersHtmx, err := renderHtmx("component/admin_users", adminUsersContext{
+		Users:     renderUsers,
+		LogLevel:  log.Level(),
+		LogLevels: []string{"DEBUG", "INFO", "WARN", "ERROR"},
+	})
+	if err != nil {
+		httpError(r.Context(), w, "error on render admin users component", err, http.StatusInternalServerError)
+		return
+	}
+	renderContext := s.htmxPrepareMainContext(r)
+	renderContext.ChildComponent = template.HTML(usersHtmx.String())
+
+	writeHtmx(w, r, "page/index", renderContext, http.StatusOK)
+}
+
+// htmxAdminUsersRow renders row of users table with stats, rows are loaded when they are revealed
+func (s *httpServer) htmxAdminUsersRow(w http.ResponseWriter, r *http.Request) {
+	userStorage, ok := s.adminOpenStorage(w, r, r.URL.Query().Get("email"))
+	if !ok {
+		return
+	}
+	meta, err := userStorage.GetMetadata(r.Context())
+	if err != nil {
+		httpError(r.Context(), w, "unable to fetch metadata", err, http.StatusInternalServerError)
+		return
+	}
+	listing, err := userStorage.ListFiles(r.Context())
+	if err != nil {
+		httpError(r.Context(), w, "unable to get files listing", err, http.StatusInternalServerError)
+		return
+	}
+	usage := 0
+	for _, file := range listing {
+		usage += file.Size
+	}
+	writeHtmx(w, r, "component/admin_users_row", adminUsersContextUser{
+		Email:      meta.Email,
+		Disabled:   meta.Disabled,
+		Loaded:     true,
+		Files:      len(listing),
+		UsageHuman: bytesConvert(usage),
+	}, http.StatusOK)
+}
+
+type adminUserContext struct {
+	Email    string
+	Disabled bool
+	Files    []listContextFile
+}
+
+func (s *httpServer) htmxPageAdminUser(w http.ResponseWriter, r *http.Request) {
+	userStorage, ok := s.adminOpenStorage(w, r, mux.Vars(r)["email"])
+	if !ok {
+		return
+	}
+	meta, err := userStorage.GetMetadata(r.Context())
+	if err != nil {
+		httpError(r.Context(), w, "unable to fetch metadata", err, http.StatusInternalServerError)
+		return
+	}
+	listing, err := userStorage.ListFiles(r.Context())
+	if err != nil {
+		httpError(r.Context(), w, "unable to get files listing", err, http.StatusInternalServerError)
+		return
+	}
+
+	renderContext := adminUserContext{
+		Email:    meta.Email,
+		Disabled: meta.Disabled,
+		Files:    make([]listContextFile, 0, len(listing)),
+	}
+	for _, file := range listing {
+		renderContext.Files = append(renderContext.Files, listContextFile{
+			Id:             uuid.New().String(),
+			Path:           file.Path,
+			LastModifiedAt: file.LastModifiedAt,
+			SizeHuman:      bytesConvert(file.Size),
+		})
+	}
+	userHtmx, err := renderHtmx("component/admin_user", renderContext)
+	if err != nil {
+		httpError(r.Context(), w, "error on render admin user component", err, http.StatusInternalServerError)
+		return
+	}
+	mainContext := s.htmxPrepareMainContext(r)
+	mainContext.ChildComponent = template.HTML(userHtmx.String())
+
+	writeHtmx(w, r, "page/index", mainContext, http.StatusOK)
+}
+
+func (s *httpServer) apiAdminDeleteFile(w http.ResponseWriter, r *http.Request) {
+	filePath := r.URL.Query().Get("path")
+	if filePath == "" {
+		httpError(r.Context(), w, "query param 'path' is empty", fmt.Errorf("no path in query"), http.StatusBadRequest)
+		return
+	}
+	userStorage, ok := s.adminOpenStorage(w, r, r.URL.Query().Get("email"))
+	if !ok {
+		return
+	}
+	err := userStorage.Delete(r.Context(), filePath)
+	s.notifyWebhooks(r.Context(), s.audit(r, audit.Event{Action: audit.ActionDelete, Owner: r.URL.Query().Get("email"), Path: filePath, Interface: "admin"}, err))
+	if err != nil {
+		httpError(r.Context(), w, "unable to delete file", err, http.StatusInternalServerError)
+		return
+	}
+	log.FromContext(r.Context()).With(slog.String("target_user", r.URL.Query().Get("email")), slog.String("path", filePath)).Info("admin deleted file")
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(""))
+}
+
+func (s *httpServer) apiAdminRotateSecrets(w http.ResponseWriter, r *http.Request) {
+	userStorage, ok := s.adminOpenStorage(w, r, r.URL.Query().Get("email"))
+	if !ok {
+		return
+	}
+	_, err := userStorage.RotateSecrets(r.Context())
+	s.audit(r, audit.Event{Action: audit.ActionSecretsRotated, Owner: r.URL.Query().Get("email"), Interface: "admin"}, err)
+	if err != nil {
+		httpError(r.Context(), w, "unable to rotate secrets", err, http.StatusInternalServerError)
+		return
+	}
+	log.FromContext(r.Context()).With(slog.String("target_user", r.URL.Query().Get("email"))).Info("admin rotated user secrets")
+
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *httpServer) apiAdminSetDisabled(w http.ResponseWriter, r *http.Request) {
+	disabled, err := strconv.ParseBool(r.URL.Query().Get("disabled"))
+	if err != nil {
+		httpError(r.Context(), w, "query param 'disabled' should be bool", err, http.StatusBadRequest)
+		return
+	}
+	userStorage, ok := s.adminOpenStorage(w, r, r.URL.Query().Get("email"))
+	if !ok {
+		return
+	}
+	err = userStorage.SetDisabled(r.Context(), disabled)
+	s.audit(r, audit.Event{Action: audit.ActionUserStateChange, Owner: r.URL.Query().Get("email"), Details: map[string]string{"disabled": strconv.FormatBool(disabled)}, Interface: "admin"}, err)
+	if err != nil {
+		httpError(r.Context(), w, "unable to change user state", err, http.StatusInternalServerError)
+		return
+	}
+	s.userStates.invalidate(r.URL.Query().Get("email"))
+	if s.webhooks != nil {
+		s.webhooks.Invalidate(r.URL.Query().Get("email"))
+	}
+	if disabled {
+		if err := sessions.DeleteByIndex(r.Context(), s.sessions, sessions.IndexEmail(r.URL.Query().Get("email"))); err != nil {
+			httpError(r.Context(), w, "unable to delete user sessions", err, http.StatusInternalServerError)
+			return
+		}
+	}
+	log.FromContext(r.Context()).With(slog.String("target_user", r.URL.Query().Get("email")), slog.Bool("disabled", disabled)).Info("admin changed user state")
+
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *httpServer) apiAdminGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(log.Level()))
+}
+
+func (s *httpServer) apiAdminSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	previous := log.Level()
+	if err := log.SetLevel(r.URL.Query().Get("level")); err != nil {
+		httpError(r.Context(), w, "query param 'level' should be one of debug, info, warn or error", err, http.StatusBadRequest)
+		return
+	}
+	log.FromContext(r.Context()).With(slog.String("previous_level", previous), slog.String("level", log.Level())).Warn("admin changed log level")
+
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+// adminOpenStorage opens storage of any existing user, it writes http error on failure
+func (s *httpServer) adminOpenStorage(w http.ResponseWriter, r *http.Request, email string) (storage.UserScopedStorage, bool) {
+	if email == "" {
+		httpError(r.Context(), w, "user email is empty", fmt.Errorf("no email in request"), http.StatusBadRequest)
+		return nil, false
+	}
+	userStorage, err := s.storage.OpenStorageAsAdmin(r.Context(), email)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			httpError(r.Context(), w, "user not found", err, http.StatusNotFound)
+			return nil, false
+		}
+		httpError(r.Context(), w, "unable to open user scoped storage", err, http.StatusInternalServerError)
+		return nil, false
+	}
+	return userStorage, true
+}
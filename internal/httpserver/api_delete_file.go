@@ -3,6 +3,8 @@ package httpserver
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/paragor/sharefile/internal/audit"
 )
 
 func (s *httpServer) apiDelteFile(w http.ResponseWriter, r *http.Request) {
@@ -23,6 +25,7 @@ func (s *httpServer) apiDelteFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = userStorage.Delete(r.Context(), filePath)
+	s.notifyWebhooks(r.Context(), s.audit(r, audit.Event{Action: audit.ActionDelete, Owner: email, Path: filePath, Interface: "web"}, err))
 	if err != nil {
 		httpError(r.Context(), w, "unable to delete file", err, http.StatusInternalServerError)
 		return
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/paragor/sharefile/internal/audit"
 )
 
 func (s *httpServer) apiGenerateDownloadFileLink(w http.ResponseWriter, r *http.Request) {
@@ -24,10 +26,12 @@ func (s *httpServer) apiGenerateDownloadFileLink(w http.ResponseWriter, r *http.
 	}
 
 	link, err := userStorage.GenerateDownloadLink(r.Context(), filePath, 15*time.Minute)
+	s.audit(r, audit.Event{Action: audit.ActionLinkGenerated, Owner: email, Path: filePath, Interface: "web"}, err)
 	if err != nil {
 		httpError(r.Context(), w, "unable to generate download link", err, http.StatusInternalServerError)
 		return
 	}
+	downloadLinksTotal.Inc()
 
 	w.Header().Set("HX-Redirect", link)
 	w.Header().Set("Content-Type", "text/uri-list")
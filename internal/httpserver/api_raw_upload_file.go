@@ -0,0 +1,74 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/paragor/sharefile/internal/audit"
+)
+
+// apiRawUploadFile streams request body into storage, so files can be uploaded by `curl -T`
+func (s *httpServer) apiRawUploadFile(w http.ResponseWriter, r *http.Request) {
+	auth, err := s.extractAuthContext(r)
+	if err != nil {
+		httpError(r.Context(), w, "cant read auth from request", err, http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+
+	filePath := mux.Vars(r)["name"]
+	if err := validateFilePath(filePath); err != nil {
+		httpError(r.Context(), w, "invalid file name", err, http.StatusBadRequest)
+		return
+	}
+
+	fileContentType := r.Header.Get("Content-Type")
+	if fileContentType == "" {
+		fileContentType = "application/octet-stream"
+	}
+
+	userStorage, err := s.storage.OpenStorage(r.Context(), auth.Email, false)
+	if err != nil {
+		httpError(r.Context(), w, "unable to open user scoped storage", err, http.StatusInternalServerError)
+		return
+	}
+	limit, err := uploadLimit(r.Context(), auth.Policy, userStorage)
+	if err != nil {
+		httpError(r.Context(), w, "unable to check upload limits", err, http.StatusInternalServerError)
+		return
+	}
+	if err := checkUploadSize(limit, r.ContentLength); err != nil {
+		httpError(r.Context(), w, "file exceeds max upload size or quota", err, http.StatusRequestEntityTooLarge)
+		return
+	}
+	// chunked body has no content length, so size is enforced while reading
+	limitedBody := newUploadLimitReader(r.Body, limit)
+	err = userStorage.Upload(r.Context(), filePath, fileContentType, limitedBody)
+	s.notifyWebhooks(r.Context(), s.audit(r, audit.Event{Action: audit.ActionUpload, Owner: auth.Email, Path: filePath, Interface: "raw"}, err))
+	if err != nil {
+		if limitedBody.Exceeded() {
+			httpError(r.Context(), w, "file exceeds max upload size or quota", err, http.StatusRequestEntityTooLarge)
+			return
+		}
+		httpError(r.Context(), w, "error on upload file", err, http.StatusInternalServerError)
+		return
+	}
+
+	meta, err := userStorage.GetMetadata(r.Context())
+	if err != nil {
+		httpError(r.Context(), w, "unable to fetch metadata", err, http.StatusInternalServerError)
+		return
+	}
+	link, err := userStorage.GenerateDownloadLink(r.Context(), filePath, s.rssExpirationLink)
+	if err != nil {
+		httpError(r.Context(), w, "unable to generate download link", err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if auth.Policy.PublicShares {
+		w.Header().Set("X-Share-Url", s.getShareLink(meta))
+	}
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte(link + "\n"))
+}
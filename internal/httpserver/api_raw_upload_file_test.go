@@ -0,0 +1,70 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestApiRawUploadFile(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		policy        *AuthPolicy
+		wantCode      int
+		wantContent   string
+	}{
+		{name: "content length", body: "hello world", contentLength: 11, wantCode: http.StatusCreated, wantContent: "hello world"},
+		{name: "chunked", body: "hello world", contentLength: -1, wantCode: http.StatusCreated, wantContent: "hello world"},
+		{name: "chunked over limit", body: "hello world", contentLength: -1, policy: &AuthPolicy{MaxUploadSizeBytes: 5}, wantCode: http.StatusRequestEntityTooLarge},
+		{name: "content length over limit", body: "hello world", contentLength: 11, policy: &AuthPolicy{MaxUploadSizeBytes: 5}, wantCode: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeStorage := newFakeStorage()
+			fakeStorage.addUser("user@example.com", nil)
+			s := newTestServer(t, fakeStorage, &AuthConfig{DefaultPolicy: tt.policy})
+			request := newTokenRequest(http.MethodPut, "/u/file.txt", "", "user@example.com", "token")
+			// reader without length makes request chunked
+			request.Body = io.NopCloser(strings.NewReader(tt.body))
+			request.ContentLength = tt.contentLength
+
+			response, body := serve(s, request)
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, tt.wantCode, body)
+			}
+			content, ok := fakeStorage.file("user@example.com", "file.txt")
+			if tt.wantContent == "" {
+				if ok && content != "" {
+					t.Fatalf("file is uploaded over limit: %q", content)
+				}
+				return
+			}
+			if content != tt.wantContent {
+				t.Fatalf("content %q, want %q", content, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestTokenAuthThrottle(t *testing.T) {
+	fakeStorage := newFakeStorage()
+	fakeStorage.addUser("user@example.com", nil)
+	s := newTestServer(t, fakeStorage, nil)
+
+	for i := 0; i <= 10; i++ {
+		response, _ := serve(s, newTokenRequest(http.MethodPut, "/u/file.txt", "x", "user@example.com", "wrong"))
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d, want 401", i, response.StatusCode)
+		}
+	}
+	response, _ := serve(s, newTokenRequest(http.MethodPut, "/u/file.txt", "x", "user@example.com", "wrong"))
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status %d after repeated failures, want 429", response.StatusCode)
+	}
+	if response.Header.Get("Retry-After") == "" {
+		t.Fatalf("no Retry-After header")
+	}
+}
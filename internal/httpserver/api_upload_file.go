@@ -5,12 +5,14 @@ import (
 	"io"
 	"net/http"
 	"strings"
+
+	"github.com/paragor/sharefile/internal/audit"
 )
 
 func (s *httpServer) apiUploadFile(w http.ResponseWriter, r *http.Request) {
-	email, err := s.extractEmail(r)
+	auth, err := s.extractAuthContext(r)
 	if err != nil {
-		httpError(r.Context(), w, "cant read email from request", err, http.StatusInternalServerError)
+		httpError(r.Context(), w, "cant read auth from request", err, http.StatusInternalServerError)
 		return
 	}
 	defer r.Body.Close()
@@ -22,12 +24,14 @@ func (s *httpServer) apiUploadFile(w http.ResponseWriter, r *http.Request) {
 	fileContentType := "application/octet-stream"
 	var body io.ReadCloser
 	var filePath string
+	var fileSize int64
 	for _, fh := range r.MultipartForm.File["file"] {
 		ct := fh.Header.Get("Content-Type")
 		if ct != "" {
 			fileContentType = ct
 		}
 		filePath = fh.Filename
+		fileSize = fh.Size
 		f, err := fh.Open()
 		if err != nil {
 			httpError(r.Context(), w, "Cant open file from multipart form", err, http.StatusInternalServerError)
@@ -42,20 +46,28 @@ func (s *httpServer) apiUploadFile(w http.ResponseWriter, r *http.Request) {
 	}
 	defer body.Close()
 
-	if strings.Contains(filePath, "/") {
-		httpError(r.Context(), w, "file path should not contain '/' character", fmt.Errorf(
-			"file path '%s' contain bad characters",
-			filePath,
-		), http.StatusBadRequest)
+	if err := validateFilePath(filePath); err != nil {
+		httpError(r.Context(), w, "file path should not contain '/' character", err, http.StatusBadRequest)
 		return
 	}
 
-	userStorage, err := s.storage.OpenStorage(r.Context(), email, true)
+	userStorage, err := s.storage.OpenStorage(r.Context(), auth.Email, true)
 	if err != nil {
 		httpError(r.Context(), w, "unable to open user scoped storage", err, http.StatusInternalServerError)
 		return
 	}
-	if err := userStorage.Upload(r.Context(), filePath, fileContentType, body); err != nil {
+	limit, err := uploadLimit(r.Context(), auth.Policy, userStorage)
+	if err != nil {
+		httpError(r.Context(), w, "unable to check upload limits", err, http.StatusInternalServerError)
+		return
+	}
+	if err := checkUploadSize(limit, fileSize); err != nil {
+		httpError(r.Context(), w, "file exceeds max upload size or quota", err, http.StatusRequestEntityTooLarge)
+		return
+	}
+	err = userStorage.Upload(r.Context(), filePath, fileContentType, body)
+	s.notifyWebhooks(r.Context(), s.audit(r, audit.Event{Action: audit.ActionUpload, Owner: auth.Email, Path: filePath, Interface: "web"}, err))
+	if err != nil {
 		httpError(r.Context(), w, "error on upload file", err, http.StatusInternalServerError)
 		return
 	}
@@ -64,3 +76,13 @@ func (s *httpServer) apiUploadFile(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("ok"))
 }
+
+func validateFilePath(filePath string) error {
+	if filePath == "" {
+		return fmt.Errorf("file path is empty")
+	}
+	if strings.Contains(filePath, "/") {
+		return fmt.Errorf("file path '%s' contain bad characters", filePath)
+	}
+	return nil
+}
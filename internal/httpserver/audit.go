@@ -0,0 +1,37 @@
+package httpserver
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/paragor/sharefile/internal/audit"
+	"github.com/paragor/sharefile/internal/log"
+)
+
+// audit completes event by request and writes it to audit sink, completed event is returned for notifyWebhooks,
+// actor is taken from auth context and owner is actor if they are empty
+func (s *httpServer) audit(r *http.Request, event audit.Event, err error) audit.Event {
+	event.Time = time.Now()
+	if event.Actor == "" {
+		if auth, authErr := s.extractAuthContext(r); authErr == nil {
+			event.Actor = auth.Email
+		}
+	}
+	if event.Owner == "" {
+		event.Owner = event.Actor
+	}
+	event.Ip = s.clientIp(r)
+	event.UserAgent = r.UserAgent()
+	event.RequestId = requestId(r.Context())
+	event.Result = audit.ResultSuccess
+	if err != nil {
+		event.Result = audit.ResultFailure
+		event.Error = err.Error()
+	}
+	if s.auditSink != nil {
+		if err := s.auditSink.Write(&event); err != nil {
+			log.FromContext(r.Context()).With(log.Error(err)).Error("cant write audit event")
+		}
+	}
+	return event
+}
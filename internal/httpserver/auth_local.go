@@ -0,0 +1,114 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/paragor/sharefile/internal/audit"
+	"github.com/paragor/sharefile/internal/log"
+	"github.com/paragor/sharefile/internal/sessions"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AuthLocalConfig enables login by users defined in config, for deployments without identity provider
+type AuthLocalConfig struct {
+	Users []AuthLocalUser
+}
+
+type AuthLocalUser struct {
+	Email string
+	// PasswordHash is bcrypt hash of password
+	PasswordHash string
+	Groups       []string
+}
+
+func (c *AuthLocalConfig) Validate() error {
+	emails := map[string]bool{}
+	for _, user := range c.Users {
+		if user.Email == "" {
+			return fmt.Errorf("user email should not be empty")
+		}
+		if emails[user.Email] {
+			return fmt.Errorf("user '%s' is not unique", user.Email)
+		}
+		emails[user.Email] = true
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			return fmt.Errorf("user '%s' has invalid bcrypt password hash: %w", user.Email, err)
+		}
+	}
+	return nil
+}
+
+func (c *AuthLocalConfig) findUser(email string) *AuthLocalUser {
+	for i := range c.Users {
+		if c.Users[i].Email == email {
+			return &c.Users[i]
+		}
+	}
+	return nil
+}
+
+// dummyPasswordHash is compared for unknown users, so response time does not reveal existing emails
+var dummyPasswordHash = must(bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost))
+
+// checkAuthorizationByLocal returns nil if user is removed from config after login
+func (s *httpServer) checkAuthorizationByLocal(session *sessions.Session) *authContext {
+	cfg := s.authConfig.Local
+	if cfg == nil {
+		return nil
+	}
+	user := cfg.findUser(session.Email)
+	if user == nil {
+		return nil
+	}
+
+	return &authContext{
+		Email:  user.Email,
+		Groups: user.Groups,
+		Policy: s.authConfig.policyFor(user.Groups),
+	}
+}
+
+func (s *httpServer) apiLocalLogin(w http.ResponseWriter, r *http.Request) {
+	if err := s.checkSameOrigin(r); err != nil {
+		httpError(r.Context(), w, "cross-site login is not allowed", err, http.StatusForbidden)
+		return
+	}
+	cfg := s.authConfig.Local
+	email := r.PostFormValue("email")
+	password := r.PostFormValue("password")
+	clientIp, target := s.clientIp(r), "local:"+email
+	if wait := s.throttle.retryAfter(clientIp, target); wait > 0 {
+		writeThrottled(r, w, &throttledError{retryAfter: wait})
+		return
+	}
+
+	user := cfg.findUser(email)
+	passwordHash := dummyPasswordHash
+	if user != nil {
+		passwordHash = []byte(user.PasswordHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(passwordHash, []byte(password)); err != nil || user == nil {
+		log.FromContext(r.Context()).With(log.Error(err)).Info("local login failed")
+		s.audit(r, audit.Event{Action: audit.ActionLogin, Actor: email, Interface: "local"}, fmt.Errorf("invalid email or password"))
+		s.throttle.fail(clientIp, target)
+		s.htmxRenderLogin(w, r, "Invalid email or password")
+		return
+	}
+
+	// disabled user is rejected before session is started, checkUserState writes error
+	if !s.checkUserState(w, r, &authContext{Email: user.Email, Groups: user.Groups}) {
+		s.audit(r, audit.Event{Action: audit.ActionLogin, Actor: user.Email, Interface: "local"}, fmt.Errorf("user is disabled or its state is unavailable"))
+		return
+	}
+
+	session := newSession(r, sessions.MethodLocal, user.Email, user.Groups, s.authConfig.SessionTTL)
+	err := startSession(w, r, s.sessions, s.cookieHandler, session)
+	s.audit(r, audit.Event{Action: audit.ActionLogin, Actor: user.Email, Interface: "local"}, err)
+	if err != nil {
+		httpError(r.Context(), w, "cant start session", err, http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, s.returnTo.returnPath(r.PostFormValue(returnToParam), "/"), http.StatusFound)
+}
@@ -0,0 +1,101 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newLocalTestServer(t *testing.T, fakeStorage *fakeStorage) *httpServer {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestServer(t, fakeStorage, &AuthConfig{
+		Local: &AuthLocalConfig{Users: []AuthLocalUser{{Email: "user@example.com", PasswordHash: string(hash)}}},
+	})
+}
+
+func newLoginRequest(email string, password string, origin string) *http.Request {
+	form := url.Values{"email": {email}, "password": {password}}
+	request := httptest.NewRequest(http.MethodPost, "/local/login", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if origin != "" {
+		request.Header.Set("Origin", origin)
+	}
+	return request
+}
+
+func TestLocalLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		origin   string
+		referer  string
+		disabled bool
+		wantCode int
+		// wantBody is part of login page with error
+		wantBody string
+	}{
+		{name: "correct password", email: "user@example.com", password: "password", origin: "http://sharefile.local", wantCode: http.StatusFound},
+		{name: "client without origin", email: "user@example.com", password: "password", wantCode: http.StatusFound},
+		{name: "wrong password", email: "user@example.com", password: "wrong", origin: "http://sharefile.local", wantCode: http.StatusUnauthorized, wantBody: "Invalid email or password"},
+		{name: "unknown user", email: "other@example.com", password: "password", wantCode: http.StatusUnauthorized, wantBody: "Invalid email or password"},
+		{name: "disabled user", email: "user@example.com", password: "password", disabled: true, wantCode: http.StatusForbidden},
+		{name: "cross-site origin", email: "user@example.com", password: "password", origin: "https://evil.example.com", wantCode: http.StatusForbidden},
+		{name: "opaque origin", email: "user@example.com", password: "password", origin: "null", wantCode: http.StatusForbidden},
+		{name: "other scheme", email: "user@example.com", password: "password", origin: "https://sharefile.local", wantCode: http.StatusForbidden},
+		{name: "cross-site referer", email: "user@example.com", password: "password", referer: "https://evil.example.com/login", wantCode: http.StatusForbidden},
+		{name: "same site referer", email: "user@example.com", password: "password", referer: "http://sharefile.local/", wantCode: http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeStorage := newFakeStorage()
+			user := fakeStorage.addUser("user@example.com", nil)
+			user.meta.Disabled = tt.disabled
+			s := newLocalTestServer(t, fakeStorage)
+
+			request := newLoginRequest(tt.email, tt.password, tt.origin)
+			if tt.referer != "" {
+				request.Header.Set("Referer", tt.referer)
+			}
+			response, body := serve(s, request)
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, tt.wantCode, body)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Fatalf("body does not contain %q: %s", tt.wantBody, body)
+			}
+			count, err := s.sessions.Count(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loggedIn := tt.wantCode == http.StatusFound; (count == 1) != loggedIn || (len(response.Cookies()) > 0) != loggedIn {
+				t.Fatalf("%d sessions and cookies %v, want session only on success", count, response.Cookies())
+			}
+		})
+	}
+}
+
+func TestLocalLoginThrottle(t *testing.T) {
+	s := newLocalTestServer(t, newFakeStorage())
+	for range 10 {
+		if response, _ := serve(s, newLoginRequest("user@example.com", "wrong", "")); response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("status %d of free failure, want login page", response.StatusCode)
+		}
+	}
+	serve(s, newLoginRequest("user@example.com", "wrong", ""))
+
+	// correct password is not even checked while guesses are throttled
+	response, _ := serve(s, newLoginRequest("user@example.com", "password", ""))
+	if response.StatusCode != http.StatusTooManyRequests || response.Header.Get("Retry-After") == "" {
+		t.Fatalf("status %d, want 429 with Retry-After", response.StatusCode)
+	}
+}
@@ -2,152 +2,435 @@ package httpserver
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/paragor/sharefile/internal/audit"
 	"github.com/paragor/sharefile/internal/log"
+	"github.com/paragor/sharefile/internal/sessions"
 	"github.com/zitadel/oidc/v3/pkg/client/rp"
 	httphelper "github.com/zitadel/oidc/v3/pkg/http"
 	"github.com/zitadel/oidc/v3/pkg/oidc"
 	"golang.org/x/oauth2"
 )
 
+type AuthConfig struct {
+	CookieKey string
+	Oidc      []*AuthOidcConfig
+	// Proxy is nil if proxy auth is disabled
+	Proxy *AuthProxyConfig
+	// Local is nil if local users are disabled
+	Local *AuthLocalConfig
+	// Admin is nil if nobody is admin
+	Admin *AuthAdminConfig
+	// SessionTTL limits lifetime of oidc and local sessions
+	SessionTTL time.Duration
+
+	// DefaultPolicy applies to users without group policy and to api token clients of users who never logged in by browser
+	DefaultPolicy *AuthPolicy
+	GroupPolicies map[string]*AuthPolicy
+}
+
+func (c *AuthConfig) Validate() error {
+	if c.CookieKey == "" {
+		return fmt.Errorf("cookie key shoud not be empty")
+	}
+	if c.SessionTTL <= 0 {
+		return fmt.Errorf("session ttl should be > 0")
+	}
+	if len(c.Oidc) == 0 && c.Proxy == nil && c.Local == nil {
+		return fmt.Errorf("at least one oidc provider, proxy auth or local users should be configured")
+	}
+	if c.Proxy != nil {
+		if err := c.Proxy.Validate(); err != nil {
+			return fmt.Errorf("invalid proxy auth: %w", err)
+		}
+	}
+	if c.Local != nil {
+		if err := c.Local.Validate(); err != nil {
+			return fmt.Errorf("invalid local auth: %w", err)
+		}
+	}
+	if c.DefaultPolicy == nil {
+		return fmt.Errorf("default policy should not be empty")
+	}
+	if err := c.DefaultPolicy.Validate(); err != nil {
+		return fmt.Errorf("invalid default policy: %w", err)
+	}
+	for group, policy := range c.GroupPolicies {
+		if err := policy.Validate(); err != nil {
+			return fmt.Errorf("invalid policy of group '%s': %w", group, err)
+		}
+	}
+	names := map[string]bool{}
+	for _, provider := range c.Oidc {
+		if len(c.Oidc) > 1 && provider.identityIsEmail() {
+			if len(provider.AllowedEmailDomains) == 0 {
+				return fmt.Errorf("oidc provider '%s' should have allowed email domains, because several providers are configured", provider.Name)
+			}
+			if provider.AllowUnverifiedEmail {
+				return fmt.Errorf("oidc provider '%s' should not allow unverified email, because several providers are configured", provider.Name)
+			}
+		}
+		if names[provider.Name] {
+			return fmt.Errorf("oidc provider name '%s' is not unique", provider.Name)
+		}
+		names[provider.Name] = true
+		if err := provider.Validate(); err != nil {
+			return fmt.Errorf("invalid oidc provider '%s': %w", provider.Name, err)
+		}
+	}
+	return nil
+}
+
 type AuthOidcConfig struct {
+	// Name is used in provider urls and cookies, empty name keeps legacy /oidc/login and /oidc/callback urls
+	Name         string
+	DisplayName  string
 	ClientId     string
 	ClientSecret string
 	IssuerUrl    string
-	CookieKey    string
 	Scopes       []string
-	AllowedGroup string
+	// AllowedGroups restricts access to members of any of groups, empty list allows everyone
+	AllowedGroups []string
+
+	// IdentityClaim is one of email, preferred_username or sub, email by default.
+	// preferred_username and sub are unique only within provider, so they are prefixed by provider name, e.g. "corp:alice"
+	IdentityClaim        string
+	AllowUnverifiedEmail bool
+	// AllowedEmailDomains rejects email identities of other domains, so provider cant assert users of another provider.
+	// It is required if several oidc providers are configured
+	AllowedEmailDomains []string
+	// GroupsClaim is claim name or dot separated path to nested claim, groups by default
+	GroupsClaim        string
+	GroupsFromUserinfo bool
 }
 
+const (
+	IdentityClaimEmail             = "email"
+	IdentityClaimPreferredUsername = "preferred_username"
+	IdentityClaimSub               = "sub"
+)
+
 func (c *AuthOidcConfig) Validate() error {
+	if strings.ContainsAny(c.Name, "/?#%") {
+		return fmt.Errorf("name should not contain url special characters")
+	}
 	if c.ClientId == "" {
 		return fmt.Errorf("client id shoud not be empty")
 	}
+	switch c.IdentityClaim {
+	case "", IdentityClaimEmail, IdentityClaimPreferredUsername, IdentityClaimSub:
+	default:
+		return fmt.Errorf("identity claim should be one of email, preferred_username or sub")
+	}
 	if c.ClientSecret == "" {
 		return fmt.Errorf("client secret shoud not be empty")
 	}
 	if c.IssuerUrl == "" {
 		return fmt.Errorf("issuer url shoud not be empty")
 	}
-	if c.CookieKey == "" {
-		return fmt.Errorf("cookie key shoud not be empty")
-	}
 	if len(c.Scopes) == 0 {
 		return fmt.Errorf("scopes shoud not be empty")
 	}
 	return nil
 }
 
+func (c *AuthOidcConfig) identityIsEmail() bool {
+	return c.IdentityClaim == "" || c.IdentityClaim == IdentityClaimEmail
+}
+
+// namespacedIdentity prefixes identity by provider name, legacy unnamed provider uses "oidc"
+func (c *AuthOidcConfig) namespacedIdentity(identity string) string {
+	if identity == "" {
+		return ""
+	}
+	name := c.Name
+	if name == "" {
+		name = "oidc"
+	}
+	return name + ":" + identity
+}
+
+func (c *AuthOidcConfig) emailDomainAllowed(email string) bool {
+	if len(c.AllowedEmailDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	return slices.ContainsFunc(c.AllowedEmailDomains, func(allowed string) bool {
+		return strings.EqualFold(allowed, domain)
+	})
+}
+
+func (c *AuthOidcConfig) pathPrefix() string {
+	if c.Name == "" {
+		return "/oidc"
+	}
+	return "/oidc/" + c.Name
+}
+
 type authOidcContext struct {
-	cfg                    *AuthOidcConfig
-	provider               rp.RelyingParty
-	successRedirectPath    string
-	idTokenCookieName      string
-	refreshTokenCookieName string
+	cfg                   *AuthOidcConfig
+	provider              rp.RelyingParty
+	sessions              sessions.Store
+	sessionTTL            time.Duration
+	successRedirectPath   string
+	returnTo              *returnToSigner
+	loginPath             string
+	callbackPath          string
+	backChannelLogoutPath string
+	refreshLocks          *keyedLocks
+	// audit is set by server
+	audit func(r *http.Request, event audit.Event, err error) audit.Event
 }
 
-func newOidcContext(cfg *AuthOidcConfig, callbackUrl string, successRedirectPath string) (*authOidcContext, error) {
-	cookieHandler := httphelper.NewCookieHandler([]byte(cfg.CookieKey), []byte(cfg.CookieKey))
+func newOidcContext(
+	cfg *AuthOidcConfig,
+	cookieHandler *httphelper.CookieHandler,
+	sessionStore sessions.Store,
+	sessionTTL time.Duration,
+	serverPublicUrl string,
+	successRedirectPath string,
+	returnTo *returnToSigner,
+) (*authOidcContext, error) {
 	options := []rp.Option{
 		rp.WithCookieHandler(cookieHandler),
-		rp.WithVerifierOpts(rp.WithIssuedAtOffset(5 * time.Second)),
+		rp.WithPKCE(cookieHandler),
+		rp.WithVerifierOpts(
+			rp.WithIssuedAtOffset(5*time.Second),
+			rp.WithNonce(nonceFromContext),
+		),
 	}
+	callbackPath := cfg.pathPrefix() + "/callback"
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
-	provider, err := rp.NewRelyingPartyOIDC(ctx, cfg.IssuerUrl, cfg.ClientId, cfg.ClientSecret, callbackUrl, cfg.Scopes, options...)
+	provider, err := rp.NewRelyingPartyOIDC(ctx, cfg.IssuerUrl, cfg.ClientId, cfg.ClientSecret, serverPublicUrl+callbackPath, cfg.Scopes, options...)
 	if err != nil {
 		return nil, fmt.Errorf("error creating provider %v", err)
 	}
 	return &authOidcContext{
-		cfg:                    cfg,
-		provider:               provider,
-		idTokenCookieName:      "oidc_id_token",
-		refreshTokenCookieName: "oidc_refresh_token",
-		successRedirectPath:    successRedirectPath,
+		cfg:                   cfg,
+		provider:              provider,
+		sessions:              sessionStore,
+		sessionTTL:            sessionTTL,
+		successRedirectPath:   successRedirectPath,
+		returnTo:              returnTo,
+		loginPath:             cfg.pathPrefix() + "/login",
+		callbackPath:          callbackPath,
+		backChannelLogoutPath: cfg.pathPrefix() + "/backchannel-logout",
+		refreshLocks:          newKeyedLocks(),
 	}, nil
 }
 
-func (oc *authOidcContext) checkAuthorizationByOidc(writer http.ResponseWriter, request *http.Request) (bool, *oidc.IDTokenClaims) {
-	idToken, err := oc.getIdToken(request)
+// oidcUser is identity and groups mapped from id token or userinfo claims according to provider config
+type oidcUser struct {
+	Identity string
+	Groups   []string
+	Claims   *oidc.IDTokenClaims
+
+	// groupsErr is reported only if groups are required to allow access
+	groupsErr error
+}
+
+func (oc *authOidcContext) newOidcUser(claims *oidc.IDTokenClaims, groups []string, groupsErr error) *oidcUser {
+	user := &oidcUser{Claims: claims, Groups: groups, groupsErr: groupsErr}
+	switch oc.cfg.IdentityClaim {
+	case "", IdentityClaimEmail:
+		if (bool(claims.EmailVerified) || oc.cfg.AllowUnverifiedEmail) && oc.cfg.emailDomainAllowed(claims.Email) {
+			user.Identity = claims.Email
+		}
+	case IdentityClaimPreferredUsername:
+		user.Identity = oc.cfg.namespacedIdentity(claims.PreferredUsername)
+	case IdentityClaimSub:
+		user.Identity = oc.cfg.namespacedIdentity(claims.Subject)
+	}
+	return user
+}
+
+// errAuthUnavailable means session may be valid, but it can not be checked now, e.g. idp is down.
+// Other failures of session check delete session, so user is sent to login
+var errAuthUnavailable = errors.New("authentication is temporarily unavailable")
+
+// oidcTokensFallbackTTL extends kept id token if refresh response has no expiration of access token
+const oidcTokensFallbackTTL = 5 * time.Minute
+
+// isIdpUnavailable reports network failures, timeouts and 5xx responses of idp
+func isIdpUnavailable(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return retrieveErr.Response != nil && retrieveErr.Response.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// checkAuthorizationByOidc returns errAuthUnavailable if session should be kept
+func (oc *authOidcContext) checkAuthorizationByOidc(ctx context.Context, session *sessions.Session) (*oidcUser, error) {
+	user, err := oc.getUser(ctx, session)
 	if err != nil {
-		idToken, err = oc.refreshTokensAndGetIdToken(writer, request)
+		user, err = oc.refreshTokensAndGetUser(ctx, session)
 		if err != nil {
-			return false, nil
+			return nil, fmt.Errorf("cant refresh oidc session: %w", err)
 		}
-		log.FromContext(request.Context()).Info("refresh token was success")
+		log.FromContext(ctx).Info("refresh token was success")
 	}
-
-	return oc.isAccessAllowed(idToken), idToken
+	if !oc.isAccessAllowed(user) {
+		return nil, fmt.Errorf("access is not allowed for '%s'", user.Identity)
+	}
+	return user, nil
 }
-func (oc *authOidcContext) getIdToken(request *http.Request) (*oidc.IDTokenClaims, error) {
-	idToken, err := oc.provider.CookieHandler().CheckCookie(request, oc.idTokenCookieName)
-	if err != nil || idToken == "" {
-		return nil, fmt.Errorf("no id token in request")
+
+func (oc *authOidcContext) getUser(ctx context.Context, session *sessions.Session) (*oidcUser, error) {
+	if session.IdToken == "" {
+		return nil, fmt.Errorf("no id token in session")
 	}
-	claims, err := rp.VerifyIDToken[*oidc.IDTokenClaims](request.Context(), idToken, oc.provider.IDTokenVerifier())
+	claims, err := oc.verifySessionIdToken(ctx, session.IdToken, session.OidcNonce, session.IdTokenExtendedUntil)
 	if err != nil {
-		return nil, fmt.Errorf("error on extracting oidc token: %s", err)
+		return nil, err
 	}
-	return claims, nil
+	if !oc.cfg.GroupsFromUserinfo {
+		groups, groupsErr := oc.extractGroups(claims.Claims)
+		return oc.newOidcUser(claims, groups, groupsErr), nil
+	}
+	return oc.newOidcUser(claims, session.Groups, nil), nil
 }
 
-func (oc *authOidcContext) refreshTokensAndGetIdToken(w http.ResponseWriter, r *http.Request) (*oidc.IDTokenClaims, error) {
-	refreshToken, err := oc.provider.CookieHandler().CheckCookie(r, oc.refreshTokenCookieName)
-	if err != nil || refreshToken == "" {
-		return nil, fmt.Errorf("no refresh token in request")
+// refreshTokensAndGetUser updates tokens and groups of session
+func (oc *authOidcContext) refreshTokensAndGetUser(ctx context.Context, session *sessions.Session) (*oidcUser, error) {
+	// parallel requests of one session would reuse refresh token, which is rejected by idp if it rotates refresh tokens
+	unlock := oc.refreshLocks.lock(session.Id)
+	defer unlock()
+	stored, err := oc.sessions.Get(ctx, session.Id)
+	if errors.Is(err, sessions.ErrNotFound) {
+		return nil, fmt.Errorf("session is deleted")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: cant reload session: %w", errAuthUnavailable, err)
+	}
+	if stored.IdToken != session.IdToken || !stored.IdTokenExtendedUntil.Equal(session.IdTokenExtendedUntil) {
+		// session was refreshed by another request while waiting for lock
+		*session = *stored
+		if user, err := oc.getUser(ctx, session); err == nil {
+			return user, nil
+		}
+	}
+
+	if session.RefreshToken == "" {
+		return nil, fmt.Errorf("no refresh token in session")
 	}
 
-	tokens, err := oc.provider.OAuthConfig().TokenSource(r.Context(), &oauth2.Token{RefreshToken: refreshToken}).Token()
+	tokens, err := oc.provider.OAuthConfig().TokenSource(ctx, &oauth2.Token{RefreshToken: session.RefreshToken}).Token()
 	if err != nil {
-		return nil, fmt.Errorf("error on refresh tokens: %s", err)
+		if isIdpUnavailable(err) {
+			return nil, fmt.Errorf("%w: error on refresh tokens: %w", errAuthUnavailable, err)
+		}
+		return nil, fmt.Errorf("refresh token is rejected: %w", err)
 	}
 
-	idToken := tokens.Extra("id_token").(string)
+	// idp may not reissue id token on refresh, then old one is kept while access token is valid
+	extendedUntil := time.Time{}
+	idToken, _ := tokens.Extra("id_token").(string)
 	if idToken == "" {
-		return nil, fmt.Errorf("empty id token after refresh")
+		idToken = session.IdToken
+		extendedUntil = tokens.Expiry
+		if extendedUntil.IsZero() {
+			extendedUntil = time.Now().Add(oidcTokensFallbackTTL)
+		}
 	}
 
-	claims, err := rp.VerifyIDToken[*oidc.IDTokenClaims](r.Context(), idToken, oc.provider.IDTokenVerifier())
+	claims, err := oc.verifySessionIdToken(ctx, idToken, session.OidcNonce, extendedUntil)
 	if err != nil {
-		return nil, fmt.Errorf("error on extracting oidc token: %s", err)
+		return nil, err
+	}
+
+	groupClaims := claims.Claims
+	if oc.cfg.GroupsFromUserinfo {
+		info, err := oc.userinfo(ctx, tokens, claims.Subject)
+		if err != nil {
+			return nil, err
+		}
+		groupClaims = info.Claims
 	}
+	groups, groupsErr := oc.extractGroups(groupClaims)
 
-	if err := oc.provider.CookieHandler().SetCookie(w, oc.idTokenCookieName, idToken); err != nil {
-		return nil, fmt.Errorf("cant set id token cookie: %w", err)
+	session.IdToken = idToken
+	session.IdTokenExtendedUntil = extendedUntil
+	// provider may not rotate refresh token
+	if tokens.RefreshToken != "" {
+		session.RefreshToken = tokens.RefreshToken
 	}
-	if err := oc.provider.CookieHandler().SetCookie(w, oc.refreshTokenCookieName, tokens.RefreshToken); err != nil {
-		return nil, fmt.Errorf("cant set refresh token cookie: %w", err)
+	session.Groups = groups
+	if err := oc.sessions.Save(ctx, session); err != nil {
+		return nil, fmt.Errorf("%w: cant save refreshed session: %w", errAuthUnavailable, err)
 	}
 
-	return claims, nil
+	return oc.newOidcUser(claims, groups, groupsErr), nil
 }
 
-func (oc *authOidcContext) isAccessAllowed(token *oidc.IDTokenClaims) bool {
-	if token.Email == "" || !token.EmailVerified {
+// userinfo fetches claims of user by access token, failures of idp are reported as errAuthUnavailable
+func (oc *authOidcContext) userinfo(ctx context.Context, tokens *oauth2.Token, subject string) (*oidc.UserInfo, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, oc.provider.UserinfoEndpoint(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("cant create userinfo request: %w", err)
+	}
+	request.Header.Set("Authorization", tokens.Type()+" "+tokens.AccessToken)
+	response, err := oc.provider.HttpClient().Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cant fetch userinfo: %w", errAuthUnavailable, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("%w: userinfo responded with %d", errAuthUnavailable, response.StatusCode)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo responded with %d", response.StatusCode)
+	}
+	info := &oidc.UserInfo{}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1024*1024)).Decode(info); err != nil {
+		return nil, fmt.Errorf("cant decode userinfo: %w", err)
+	}
+	if info.Subject != subject {
+		return nil, rp.ErrUserInfoSubNotMatching
+	}
+	return info, nil
+}
+
+func (oc *authOidcContext) isAccessAllowed(user *oidcUser) bool {
+	if user.Identity == "" {
 		return false
 	}
-	if oc.cfg.AllowedGroup == "" {
+	if len(oc.cfg.AllowedGroups) == 0 {
 		return true
 	}
 
-	groups, err := oc.extractGroups(token)
-	if err != nil {
-		rawToken, _ := token.MarshalJSON()
+	if user.groupsErr != nil {
+		rawToken, _ := user.Claims.MarshalJSON()
 		log.FromContext(context.Background()).
 			With(slog.String("id_token", string(rawToken))).
-			With(log.Error(err)).
+			With(log.Error(user.groupsErr)).
 			Error("cant check allowance access")
 		return false
 	}
 
-	for _, group := range groups {
-		if group == oc.cfg.AllowedGroup {
+	for _, group := range user.Groups {
+		if slices.Contains(oc.cfg.AllowedGroups, group) {
 			return true
 		}
 	}
@@ -155,16 +438,36 @@ func (oc *authOidcContext) isAccessAllowed(token *oidc.IDTokenClaims) bool {
 	return false
 }
 
-func (oc *authOidcContext) extractGroups(token *oidc.IDTokenClaims) ([]string, error) {
-	groups := token.Claims["groups"]
+// extractGroups reads groups claim by its name or by dot separated path to nested claim, e.g. realm_access.roles
+func (oc *authOidcContext) extractGroups(claims map[string]any) ([]string, error) {
+	groupsClaim := oc.cfg.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	groups, ok := claims[groupsClaim]
+	if !ok {
+		var current any = claims
+		for _, key := range strings.Split(groupsClaim, ".") {
+			object, ok := current.(map[string]any)
+			if !ok {
+				current = nil
+				break
+			}
+			current = object[key]
+		}
+		groups = current
+	}
 	if groups == nil {
-		return nil, fmt.Errorf("groups claim is empty in oath id token")
+		return nil, fmt.Errorf("groups claim '%s' is empty in oidc claims", groupsClaim)
 	}
 
+	if group, ok := groups.(string); ok {
+		return []string{group}, nil
+	}
 	groupsInterfaces, ok := groups.([]interface{})
 	if !ok {
 		return nil, fmt.Errorf(
-			"groups claim is not list of strings in oath id token, actual type: %T `%v`",
+			"groups claim is not list of strings in oidc claims, actual type: %T `%v`",
 			groups, groups,
 		)
 	}
@@ -173,7 +476,7 @@ func (oc *authOidcContext) extractGroups(token *oidc.IDTokenClaims) ([]string, e
 		group, ok := group.(string)
 		if !ok {
 			return nil, fmt.Errorf(
-				"group claim is not strings in oath id token, actual type: %T `%v`",
+				"group claim is not strings in oidc claims, actual type: %T `%v`",
 				group, group,
 			)
 		}
@@ -187,74 +490,164 @@ func (oc *authOidcContext) userInfoCallback(
 	w http.ResponseWriter,
 	r *http.Request,
 	tokens *oidc.Tokens[*oidc.IDTokenClaims],
-	_ string,
+	state string,
 	_ rp.RelyingParty,
 	info *oidc.UserInfo,
 ) {
 	claim, err := rp.VerifyIDToken[*oidc.IDTokenClaims](r.Context(), tokens.IDToken, oc.provider.IDTokenVerifier())
 	if err != nil {
+		oc.audit(r, audit.Event{Action: audit.ActionLogin, Interface: "oidc:" + oc.cfg.Name}, err)
 		httpError(r.Context(), w, "cant verify id token", err, http.StatusUnauthorized)
 		return
 	}
 
-	if !oc.isAccessAllowed(claim) {
-		httpError(r.Context(), w, "email is blocked", fmt.Errorf(
-			"email '%s' is blocked",
+	groupClaims := claim.Claims
+	if oc.cfg.GroupsFromUserinfo {
+		groupClaims = info.Claims
+	}
+	groups, groupsErr := oc.extractGroups(groupClaims)
+	user := oc.newOidcUser(claim, groups, groupsErr)
+	if !oc.isAccessAllowed(user) {
+		err := fmt.Errorf(
+			"user '%s' with email '%s' is blocked",
+			user.Identity,
 			info.Email,
-		), http.StatusUnauthorized)
+		)
+		oc.audit(r, audit.Event{Action: audit.ActionLogin, Actor: user.Identity, Interface: "oidc:" + oc.cfg.Name}, err)
+		httpError(r.Context(), w, "user is blocked", err, http.StatusUnauthorized)
 		return
 	}
-	if err := oc.provider.CookieHandler().SetCookie(w, oc.idTokenCookieName, tokens.IDToken); err != nil {
-		httpError(r.Context(), w, "cant set id token cookie", err, http.StatusInternalServerError)
+	session := newSession(r, sessions.MethodOidc, user.Identity, groups, oc.sessionTTL)
+	session.OidcProvider = oc.cfg.Name
+	session.IdToken = tokens.IDToken
+	session.RefreshToken = tokens.RefreshToken
+	session.OidcSubject = claim.Subject
+	session.OidcSid, _ = claim.Claims["sid"].(string)
+	session.OidcNonce = claim.Nonce
+	err = startSession(w, r, oc.sessions, oc.provider.CookieHandler(), session)
+	oc.audit(r, audit.Event{Action: audit.ActionLogin, Actor: user.Identity, Interface: "oidc:" + oc.cfg.Name}, err)
+	if err != nil {
+		httpError(r.Context(), w, "cant start session", err, http.StatusInternalServerError)
 		return
 	}
-	if err := oc.provider.CookieHandler().SetCookie(w, oc.refreshTokenCookieName, tokens.RefreshToken); err != nil {
-		httpError(r.Context(), w, "cant set refresh token cookie", err, http.StatusInternalServerError)
-		return
+
+	http.Redirect(w, r, oc.returnPathFromState(state), http.StatusFound)
+}
+
+// oidcNonceCookieName binds nonce to provider and state, so logins started in parallel do not overwrite nonce of each other
+func oidcNonceCookieName(provider string, state string) string {
+	sum := sha256.Sum256([]byte(provider + "\x00" + state))
+	return "nonce-" + hex.EncodeToString(sum[:8])
+}
+
+type oidcNonceContextKey struct{}
+
+var oidcNonceContextKeyValue = oidcNonceContextKey{}
+
+// nonceFromContext returns nonce expected in id token of code exchange, it is put into context by AuthCallbackHandler
+func nonceFromContext(ctx context.Context) string {
+	nonce, _ := ctx.Value(oidcNonceContextKeyValue).(string)
+	return nonce
+}
+
+// verifySessionIdToken verifies id token stored in session or received on refresh.
+// Refreshed id token may omit nonce, but must not carry a different one.
+// Expiration is not checked until extendedUntil, see sessions.Session.IdTokenExtendedUntil.
+func (oc *authOidcContext) verifySessionIdToken(ctx context.Context, idToken string, nonce string, extendedUntil time.Time) (*oidc.IDTokenClaims, error) {
+	verifier := *oc.provider.IDTokenVerifier()
+	verifier.Nonce = nil
+	if time.Now().Before(extendedUntil) {
+		unverified := &oidc.IDTokenClaims{}
+		if _, err := oidc.ParseToken(idToken, unverified); err != nil {
+			return nil, fmt.Errorf("cant parse id token: %w", err)
+		}
+		// time checks are moved to issue time of token, signature and other claims are checked as usual
+		verifier.Offset = time.Until(unverified.GetIssuedAt())
+	}
+	claims, err := rp.VerifyIDToken[*oidc.IDTokenClaims](ctx, idToken, &verifier)
+	if err != nil {
+		return nil, fmt.Errorf("error on extracting oidc token: %s", err)
+	}
+	if claims.Nonce != "" && claims.Nonce != nonce {
+		return nil, fmt.Errorf("unexpected nonce in id token")
 	}
+	return claims, nil
+}
 
-	http.Redirect(w, r, oc.successRedirectPath, http.StatusFound)
+// returnPathFromState extracts path to return after login, state is already checked against signed state cookie
+func (oc *authOidcContext) returnPathFromState(state string) string {
+	_, encodedPath, ok := strings.Cut(state, ".")
+	if !ok {
+		return oc.successRedirectPath
+	}
+	path, err := base64.RawURLEncoding.DecodeString(encodedPath)
+	if err != nil || !isLocalPath(string(path)) {
+		return oc.successRedirectPath
+	}
+	return string(path)
 }
 
 func (oc *authOidcContext) AuthCallbackHandler() http.Handler {
-	return rp.CodeExchangeHandler(rp.UserinfoCallback(oc.userInfoCallback), oc.provider)
+	exchange := rp.CodeExchangeHandler(rp.UserinfoCallback(oc.userInfoCallback), oc.provider)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonceCookieName := oidcNonceCookieName(oc.cfg.Name, r.FormValue("state"))
+		nonce, err := oc.provider.CookieHandler().CheckCookie(r, nonceCookieName)
+		if err == nil && nonce == "" {
+			err = fmt.Errorf("nonce cookie is empty")
+		}
+		if err != nil {
+			httpError(r.Context(), w, "login is expired, try again", err, http.StatusUnauthorized)
+			return
+		}
+		oc.provider.CookieHandler().DeleteCookie(w, nonceCookieName)
+		exchange(w, r.WithContext(context.WithValue(r.Context(), oidcNonceContextKeyValue, nonce)))
+	})
 }
 
+// AuthLoginHandler redirects to provider with PKCE challenge, nonce and state carrying signed return-to path
 func (oc *authOidcContext) AuthLoginHandler() http.Handler {
-	return rp.AuthURLHandler(func() string { return uuid.New().String() }, oc.provider)
-}
-
-func (s *httpServer) apiLogout(w http.ResponseWriter, r *http.Request) {
-	for _, cookie := range []string{
-		s.oidc.idTokenCookieName,
-		s.oidc.refreshTokenCookieName,
-	} {
-		http.SetCookie(w, &http.Cookie{
-			Name:     cookie,
-			Path:     "/",
-			MaxAge:   -1,
-			Secure:   true,
-			HttpOnly: true,
-		})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		returnPath := oc.returnTo.returnPath(r.URL.Query().Get(returnToParam), oc.successRedirectPath)
+		state := uuid.New().String() + "." + base64.RawURLEncoding.EncodeToString([]byte(returnPath))
+		nonce := uuid.New().String()
+		if err := oc.provider.CookieHandler().SetCookie(w, oidcNonceCookieName(oc.cfg.Name, state), nonce); err != nil {
+			httpError(r.Context(), w, "cant set nonce cookie", err, http.StatusInternalServerError)
+			return
+		}
+		rp.AuthURLHandler(func() string { return state }, oc.provider, rp.WithURLParam("nonce", nonce))(w, r)
+	})
+}
+
+// oidcByName returns nil if provider was removed from config after login
+func (s *httpServer) oidcByName(name string) *authOidcContext {
+	for _, oc := range s.oidc {
+		if oc.cfg.Name == name {
+			return oc
+		}
 	}
-	w.Header().Set("HX-Redirect", "/login")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte("ok"))
+	return nil
 }
 
 func (s *httpServer) AuthMiddleware() mux.MiddlewareFunc {
 	return func(handler http.Handler) http.Handler {
 		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-			authPass, claim := s.oidc.checkAuthorizationByOidc(writer, request)
-			if !authPass {
+			auth, err := s.authenticate(request)
+			if errors.Is(err, errAuthUnavailable) {
+				httpError(request.Context(), writer, "authentication is temporarily unavailable, try again later", err, http.StatusServiceUnavailable)
+				return
+			}
+			if err != nil {
+				httpError(request.Context(), writer, "access denied", err, http.StatusForbidden)
+				return
+			}
+			if auth == nil {
 				s.htmxPageLogin(writer, request)
 				return
 			}
-			auth := &authContext{
-				Email:    claim.Email,
-				RawToken: claim,
-				ExpireAt: claim.GetExpiration(),
+			if !s.checkUserState(writer, request, auth) {
+				return
 			}
+			auth.IsAdmin = s.authConfig.isAdmin(auth.Email, auth.Groups)
 
 			ctx := request.Context()
 			ctx = context.WithValue(ctx, authContextKeyValue, auth)
@@ -268,9 +661,73 @@ func (s *httpServer) AuthMiddleware() mux.MiddlewareFunc {
 	}
 }
 
+// authenticate returns nil auth context if request is not authenticated
+func (s *httpServer) authenticate(request *http.Request) (*authContext, error) {
+	if auth, ok, err := s.checkAuthorizationByProxy(request); ok {
+		return auth, err
+	}
+
+	session := s.sessionFromRequest(request)
+	if session == nil {
+		return nil, nil
+	}
+	var auth *authContext
+	var err error
+	switch session.Method {
+	case sessions.MethodLocal:
+		auth = s.checkAuthorizationByLocal(session)
+		if auth == nil {
+			err = fmt.Errorf("local user '%s' is removed from config", session.Email)
+		}
+	case sessions.MethodOidc:
+		auth, err = s.checkAuthorizationByOidcSession(request.Context(), session)
+	default:
+		err = fmt.Errorf("unknown session method '%s'", session.Method)
+	}
+	if errors.Is(err, errAuthUnavailable) {
+		// session is kept, so temporary idp or network failure does not log user out
+		return nil, err
+	}
+	if err != nil {
+		log.FromContext(request.Context()).With(log.Error(err)).Info("session is deleted")
+		if err := s.sessions.Delete(request.Context(), session.Id); err != nil {
+			log.FromContext(request.Context()).With(log.Error(err)).Error("cant delete invalid session")
+		}
+		return nil, nil
+	}
+	auth.SessionId = session.Id
+	auth.ExpireAt = session.ExpireAt
+	return auth, nil
+}
+
+func (s *httpServer) checkAuthorizationByOidcSession(ctx context.Context, session *sessions.Session) (*authContext, error) {
+	oc := s.oidcByName(session.OidcProvider)
+	if oc == nil {
+		return nil, fmt.Errorf("oidc provider '%s' is removed from config", session.OidcProvider)
+	}
+	user, err := oc.checkAuthorizationByOidc(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	if user.Identity != session.Email {
+		return nil, fmt.Errorf("identity '%s' does not match session of '%s'", user.Identity, session.Email)
+	}
+	return &authContext{
+		Email:    user.Identity,
+		Groups:   user.Groups,
+		Policy:   s.authConfig.policyFor(user.Groups),
+		RawToken: user.Claims,
+	}, nil
+}
+
 type authContext struct {
 	Email    string
+	Groups   []string
+	Policy   *AuthPolicy
+	IsAdmin  bool
 	ExpireAt time.Time
+	// SessionId is empty if request is authenticated without session, e.g. by proxy
+	SessionId string
 
 	RawToken any
 }
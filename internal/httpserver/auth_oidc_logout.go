@@ -0,0 +1,118 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/paragor/sharefile/internal/log"
+	"github.com/paragor/sharefile/internal/sessions"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+)
+
+const backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// endSessionUrl returns url of provider end_session_endpoint for rp-initiated logout, empty if provider does not support it
+func (oc *authOidcContext) endSessionUrl(session *sessions.Session, postLogoutRedirectUrl string) string {
+	endpoint := oc.provider.GetEndSessionEndpoint()
+	if endpoint == "" {
+		return ""
+	}
+	endSessionUrl, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	query := endSessionUrl.Query()
+	query.Set("client_id", oc.cfg.ClientId)
+	query.Set("post_logout_redirect_uri", postLogoutRedirectUrl)
+	if session.IdToken != "" {
+		query.Set("id_token_hint", session.IdToken)
+	}
+	endSessionUrl.RawQuery = query.Encode()
+	return endSessionUrl.String()
+}
+
+type logoutTokenClaims struct {
+	oidc.LogoutTokenClaims
+	signatureAlgorithm jose.SignatureAlgorithm
+}
+
+func (c *logoutTokenClaims) SetSignatureAlgorithm(algorithm jose.SignatureAlgorithm) {
+	c.signatureAlgorithm = algorithm
+}
+
+// verifyLogoutToken validates logout token as described in openid back-channel logout spec, section 2.6
+func (oc *authOidcContext) verifyLogoutToken(ctx context.Context, token string) (*oidc.LogoutTokenClaims, error) {
+	verifier := oc.provider.IDTokenVerifier()
+	claims := &logoutTokenClaims{}
+	payload, err := oidc.ParseToken(token, claims)
+	if err != nil {
+		return nil, fmt.Errorf("cant parse logout token: %w", err)
+	}
+	if err := oidc.CheckSignature(ctx, token, payload, claims, verifier.SupportedSignAlgs, verifier.KeySet); err != nil {
+		return nil, fmt.Errorf("invalid logout token signature: %w", err)
+	}
+	if claims.Issuer != verifier.Issuer {
+		return nil, fmt.Errorf("unexpected logout token issuer '%s'", claims.Issuer)
+	}
+	if !slices.Contains(claims.Audience, verifier.ClientID) {
+		return nil, fmt.Errorf("logout token audience does not contain client id")
+	}
+	now := time.Now()
+	if claims.IssuedAt.AsTime().After(now.Add(verifier.Offset)) {
+		return nil, fmt.Errorf("logout token is issued in future")
+	}
+	if !claims.Expiration.AsTime().IsZero() && claims.Expiration.AsTime().Before(now.Add(-verifier.Offset)) {
+		return nil, fmt.Errorf("logout token is expired")
+	}
+	if _, ok := claims.Events[backChannelLogoutEvent]; !ok {
+		return nil, fmt.Errorf("logout token has no back-channel logout event")
+	}
+	if _, ok := claims.Claims["nonce"]; ok {
+		return nil, fmt.Errorf("logout token should not contain nonce")
+	}
+	if claims.Subject == "" && claims.SessionID == "" {
+		return nil, fmt.Errorf("logout token contains neither sub nor sid")
+	}
+	return &claims.LogoutTokenClaims, nil
+}
+
+// BackChannelLogoutHandler receives logout notifications from provider and deletes matching sessions
+func (oc *authOidcContext) BackChannelLogoutHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		claims, err := oc.verifyLogoutToken(r.Context(), r.PostFormValue("logout_token"))
+		if err != nil {
+			httpError(r.Context(), w, "invalid logout token", err, http.StatusBadRequest)
+			return
+		}
+
+		index := sessions.IndexOidcSubject(oc.cfg.Name, claims.Subject)
+		if claims.SessionID != "" {
+			bySid, err := oc.sessions.List(r.Context(), sessions.IndexOidcSid(oc.cfg.Name, claims.SessionID))
+			if err != nil {
+				httpError(r.Context(), w, "cant list sessions", err, http.StatusInternalServerError)
+				return
+			}
+			// id token may miss sid claim, then all sessions of subject are logged out
+			if len(bySid) > 0 || claims.Subject == "" {
+				index = sessions.IndexOidcSid(oc.cfg.Name, claims.SessionID)
+			}
+		}
+		if err := sessions.DeleteByIndex(r.Context(), oc.sessions, index); err != nil {
+			httpError(r.Context(), w, "cant delete sessions", err, http.StatusInternalServerError)
+			return
+		}
+		log.FromContext(r.Context()).With(
+			slog.String("provider", oc.cfg.Name),
+			slog.String("sub", claims.Subject),
+			slog.String("sid", claims.SessionID),
+		).Info("back-channel logout")
+		w.WriteHeader(http.StatusOK)
+	})
+}
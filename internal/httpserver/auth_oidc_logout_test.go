@@ -0,0 +1,88 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paragor/sharefile/internal/sessions"
+)
+
+func TestBackChannelLogout(t *testing.T) {
+	now := time.Now()
+	event := map[string]any{backChannelLogoutEvent: map[string]any{}}
+	tests := []struct {
+		name string
+		// claims override valid logout token of subject, nil value removes claim
+		claims map[string]any
+		// foreignKey signs token by key of another idp
+		foreignKey  bool
+		wantCode    int
+		wantDeleted []string
+	}{
+		{name: "subject", wantCode: http.StatusOK, wantDeleted: []string{"first", "second"}},
+		{name: "sid", claims: map[string]any{"sid": "sid-1"}, wantCode: http.StatusOK, wantDeleted: []string{"first"}},
+		{name: "sid without subject", claims: map[string]any{"sub": nil, "sid": "sid-2"}, wantCode: http.StatusOK, wantDeleted: []string{"second"}},
+		{name: "unknown sid falls back to subject", claims: map[string]any{"sid": "unknown"}, wantCode: http.StatusOK, wantDeleted: []string{"first", "second"}},
+		{name: "another issuer", claims: map[string]any{"iss": "https://idp.example.com"}, wantCode: http.StatusBadRequest},
+		{name: "another audience", claims: map[string]any{"aud": []string{"another"}}, wantCode: http.StatusBadRequest},
+		{name: "issued in future", claims: map[string]any{"iat": now.Add(time.Hour).Unix()}, wantCode: http.StatusBadRequest},
+		{name: "expired", claims: map[string]any{"iat": now.Add(-2 * time.Hour).Unix(), "exp": now.Add(-time.Hour).Unix()}, wantCode: http.StatusBadRequest},
+		{name: "no logout event", claims: map[string]any{"events": nil}, wantCode: http.StatusBadRequest},
+		{name: "nonce", claims: map[string]any{"nonce": "nonce"}, wantCode: http.StatusBadRequest},
+		{name: "neither sub nor sid", claims: map[string]any{"sub": nil}, wantCode: http.StatusBadRequest},
+		{name: "foreign signing key", foreignKey: true, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIdp(t)
+			s := newTestServer(t, newFakeStorage(), &AuthConfig{Oidc: []*AuthOidcConfig{idp.providerConfig("corp")}})
+			ids := map[string]string{}
+			for name, identity := range map[string][2]string{
+				"first":  {"subject", "sid-1"},
+				"second": {"subject", "sid-2"},
+				"other":  {"other", "sid-3"},
+			} {
+				session := newOidcSession("corp", "")
+				session.OidcSubject, session.OidcSid = identity[0], identity[1]
+				if err := s.sessions.Save(context.Background(), session); err != nil {
+					t.Fatal(err)
+				}
+				ids[name] = session.Id
+			}
+
+			claims := map[string]any{"email": nil, "email_verified": nil, "exp": nil, "jti": "jti", "events": event}
+			for name, value := range tt.claims {
+				claims[name] = value
+			}
+			signer := idp
+			if tt.foreignKey {
+				signer = newFakeIdp(t)
+				claims["iss"] = idp.issuer()
+			}
+			form := url.Values{"logout_token": {signer.sign(t, claims)}}
+			request := httptest.NewRequest(http.MethodPost, s.oidc[0].backChannelLogoutPath, strings.NewReader(form.Encode()))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			response, body := serve(s, request)
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, tt.wantCode, body)
+			}
+			for name, id := range ids {
+				_, err := s.sessions.Get(context.Background(), id)
+				if err != nil && !errors.Is(err, sessions.ErrNotFound) {
+					t.Fatal(err)
+				}
+				if deleted := err != nil; deleted != slices.Contains(tt.wantDeleted, name) {
+					t.Fatalf("session %s deleted %v, want deleted %v", name, deleted, tt.wantDeleted)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+)
+
+func TestNewOidcUserIdentity(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    AuthOidcConfig
+		claims oidc.IDTokenClaims
+		want   string
+	}{
+		{
+			name:   "verified email",
+			claims: oidc.IDTokenClaims{UserInfoEmail: oidc.UserInfoEmail{Email: "user@example.com", EmailVerified: true}},
+			want:   "user@example.com",
+		},
+		{
+			name:   "unverified email",
+			claims: oidc.IDTokenClaims{UserInfoEmail: oidc.UserInfoEmail{Email: "user@example.com"}},
+			want:   "",
+		},
+		{
+			name:   "unverified email is allowed",
+			cfg:    AuthOidcConfig{AllowUnverifiedEmail: true},
+			claims: oidc.IDTokenClaims{UserInfoEmail: oidc.UserInfoEmail{Email: "user@example.com"}},
+			want:   "user@example.com",
+		},
+		{
+			name:   "allowed domain",
+			cfg:    AuthOidcConfig{AllowedEmailDomains: []string{"example.com"}},
+			claims: oidc.IDTokenClaims{UserInfoEmail: oidc.UserInfoEmail{Email: "user@Example.COM", EmailVerified: true}},
+			want:   "user@Example.COM",
+		},
+		{
+			name:   "foreign domain",
+			cfg:    AuthOidcConfig{AllowedEmailDomains: []string{"contractors.example.com"}},
+			claims: oidc.IDTokenClaims{UserInfoEmail: oidc.UserInfoEmail{Email: "ceo@example.com", EmailVerified: true}},
+			want:   "",
+		},
+		{
+			name:   "domain suffix is not allowed domain",
+			cfg:    AuthOidcConfig{AllowedEmailDomains: []string{"example.com"}},
+			claims: oidc.IDTokenClaims{UserInfoEmail: oidc.UserInfoEmail{Email: "user@evil-example.com", EmailVerified: true}},
+			want:   "",
+		},
+		{
+			name:   "preferred username of named provider",
+			cfg:    AuthOidcConfig{Name: "contractors", IdentityClaim: IdentityClaimPreferredUsername},
+			claims: oidc.IDTokenClaims{UserInfoProfile: oidc.UserInfoProfile{PreferredUsername: "alice"}},
+			want:   "contractors:alice",
+		},
+		{
+			name:   "preferred username of legacy provider",
+			cfg:    AuthOidcConfig{IdentityClaim: IdentityClaimPreferredUsername},
+			claims: oidc.IDTokenClaims{UserInfoProfile: oidc.UserInfoProfile{PreferredUsername: "alice"}},
+			want:   "oidc:alice",
+		},
+		{
+			name:   "sub",
+			cfg:    AuthOidcConfig{Name: "corp", IdentityClaim: IdentityClaimSub},
+			claims: oidc.IDTokenClaims{TokenClaims: oidc.TokenClaims{Subject: "248289761001"}},
+			want:   "corp:248289761001",
+		},
+		{
+			name: "empty preferred username",
+			cfg:  AuthOidcConfig{Name: "corp", IdentityClaim: IdentityClaimPreferredUsername},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oc := &authOidcContext{cfg: &tt.cfg}
+			user := oc.newOidcUser(&tt.claims, nil, nil)
+			if user.Identity != tt.want {
+				t.Fatalf("identity %q, want %q", user.Identity, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthConfigValidateSeveralProviders(t *testing.T) {
+	provider := func(name string, domains []string, allowUnverified bool) *AuthOidcConfig {
+		return &AuthOidcConfig{
+			Name:                 name,
+			ClientId:             "client",
+			ClientSecret:         "secret",
+			IssuerUrl:            "https://" + name + ".example.com",
+			Scopes:               []string{"openid"},
+			AllowedEmailDomains:  domains,
+			AllowUnverifiedEmail: allowUnverified,
+		}
+	}
+	tests := []struct {
+		name      string
+		providers []*AuthOidcConfig
+		wantErr   bool
+	}{
+		{name: "single provider without domains", providers: []*AuthOidcConfig{provider("corp", nil, false)}},
+		{name: "several providers with domains", providers: []*AuthOidcConfig{provider("corp", []string{"example.com"}, false), provider("partners", []string{"partner.com"}, false)}},
+		{name: "several providers without domains", providers: []*AuthOidcConfig{provider("corp", []string{"example.com"}, false), provider("social", nil, false)}, wantErr: true},
+		{name: "several providers with unverified email", providers: []*AuthOidcConfig{provider("corp", []string{"example.com"}, false), provider("social", []string{"gmail.com"}, true)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &AuthConfig{CookieKey: testCookieKey, SessionTTL: 1, DefaultPolicy: &AuthPolicy{}, Oidc: tt.providers}
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOidcLoginNonce(t *testing.T) {
+	tests := []struct {
+		name string
+		// callbackProvider is provider receiving callback of login started at "corp"
+		callbackProvider string
+		// state replaces state of started login in callback
+		state string
+		// tokenNonce replaces nonce of started login in id token
+		tokenNonce string
+		wantCode   int
+	}{
+		{name: "started login", callbackProvider: "corp", wantCode: http.StatusFound},
+		{name: "unknown state", callbackProvider: "corp", state: "unknown", wantCode: http.StatusUnauthorized},
+		{name: "nonce cookie of another provider", callbackProvider: "other", wantCode: http.StatusUnauthorized},
+		{name: "id token with another nonce", callbackProvider: "corp", tokenNonce: "another", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providers := map[string]*fakeIdp{"corp": newFakeIdp(t), "other": newFakeIdp(t)}
+			var configs []*AuthOidcConfig
+			var tokenNonce string
+			for _, name := range []string{"corp", "other"} {
+				idp := providers[name]
+				idp.setToken(func(w http.ResponseWriter, _ *http.Request) {
+					writeJson(w, map[string]any{"access_token": "access", "token_type": "Bearer", "expires_in": 3600, "id_token": idp.sign(t, map[string]any{"nonce": tokenNonce})})
+				})
+				config := idp.providerConfig(name)
+				config.AllowedEmailDomains = []string{"example.com"}
+				configs = append(configs, config)
+			}
+			fakeStorage := newFakeStorage()
+			fakeStorage.addUser("user@example.com", nil)
+			s := newTestServer(t, fakeStorage, &AuthConfig{Oidc: configs})
+
+			login := httptest.NewRecorder()
+			s.mux.ServeHTTP(login, httptest.NewRequest(http.MethodGet, s.oidcByName("corp").loginPath, nil))
+			if login.Code != http.StatusFound {
+				t.Fatalf("login responded %d", login.Code)
+			}
+			location, err := url.Parse(login.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			state, nonce := location.Query().Get("state"), location.Query().Get("nonce")
+			if state == "" || nonce == "" {
+				t.Fatalf("login redirected to %s without state or nonce", location)
+			}
+			tokenNonce = nonce
+			if tt.tokenNonce != "" {
+				tokenNonce = tt.tokenNonce
+			}
+			if tt.state != "" {
+				state = tt.state
+			}
+
+			callbackUrl := s.oidcByName(tt.callbackProvider).callbackPath + "?" + url.Values{"code": {"code"}, "state": {state}}.Encode()
+			callback := httptest.NewRequest(http.MethodGet, callbackUrl, nil)
+			for _, cookie := range login.Result().Cookies() {
+				callback.AddCookie(cookie)
+			}
+			response, body := serve(s, callback)
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("callback responded %d, want %d: %s", response.StatusCode, tt.wantCode, body)
+			}
+			if tt.wantCode == http.StatusFound {
+				found := false
+				for _, cookie := range response.Cookies() {
+					found = found || cookie.Name == sessionCookieName && cookie.Value != ""
+				}
+				if !found {
+					t.Fatalf("callback did not start session")
+				}
+			}
+		})
+	}
+}
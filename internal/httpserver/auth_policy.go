@@ -0,0 +1,139 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/paragor/sharefile/internal/storage"
+)
+
+// AuthPolicy describes capabilities of user, zero limits mean unlimited
+type AuthPolicy struct {
+	MaxUploadSizeBytes int64
+	QuotaBytes         int64
+	PublicShares       bool
+}
+
+func (p *AuthPolicy) Validate() error {
+	if p.MaxUploadSizeBytes < 0 {
+		return fmt.Errorf("max upload size should not be negative")
+	}
+	if p.QuotaBytes < 0 {
+		return fmt.Errorf("quota should not be negative")
+	}
+	return nil
+}
+
+// policyFor merges policies of all user groups into the most permissive one
+func (c *AuthConfig) policyFor(groups []string) *AuthPolicy {
+	var result *AuthPolicy
+	for _, group := range groups {
+		policy, ok := c.GroupPolicies[group]
+		if !ok {
+			continue
+		}
+		if result == nil {
+			result = &AuthPolicy{}
+			*result = *policy
+			continue
+		}
+		result.MaxUploadSizeBytes = mergeLimit(result.MaxUploadSizeBytes, policy.MaxUploadSizeBytes)
+		result.QuotaBytes = mergeLimit(result.QuotaBytes, policy.QuotaBytes)
+		result.PublicShares = result.PublicShares || policy.PublicShares
+	}
+	if result == nil {
+		return c.DefaultPolicy
+	}
+	return result
+}
+
+// rememberGroups saves groups of browser user, so api token clients and share links get policy of the same groups
+func rememberGroups(ctx context.Context, userStorage storage.UserScopedStorage, groups []string) error {
+	meta, err := userStorage.GetMetadata(ctx)
+	if err != nil {
+		return fmt.Errorf("cant fetch metadata: %w", err)
+	}
+	groups = slices.Sorted(slices.Values(groups))
+	if slices.Equal(meta.Groups, groups) {
+		return nil
+	}
+	return userStorage.SetGroups(ctx, groups)
+}
+
+func mergeLimit(a, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return max(a, b)
+}
+
+var errUploadLimitExceeded = errors.New("upload exceeds max upload size or quota")
+
+// uploadLimit returns how many bytes user is allowed to upload, -1 means unlimited
+func uploadLimit(ctx context.Context, policy *AuthPolicy, userStorage storage.UserScopedStorage) (int64, error) {
+	limit := int64(-1)
+	if policy.MaxUploadSizeBytes > 0 {
+		limit = policy.MaxUploadSizeBytes
+	}
+	if policy.QuotaBytes > 0 {
+		listing, err := userStorage.ListFiles(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("cant calculate quota usage: %w", err)
+		}
+		used := int64(0)
+		for _, file := range listing {
+			used += int64(file.Size)
+		}
+		left := max(policy.QuotaBytes-used, 0)
+		if limit < 0 || left < limit {
+			limit = left
+		}
+	}
+	return limit, nil
+}
+
+// checkUploadSize returns errUploadLimitExceeded if known upload size is over the limit
+func checkUploadSize(limit int64, size int64) error {
+	if limit >= 0 && size > limit {
+		return fmt.Errorf("%w: %d bytes, allowed %d bytes", errUploadLimitExceeded, size, limit)
+	}
+	return nil
+}
+
+// uploadLimitReader fails reading with errUploadLimitExceeded when upload of unknown size goes over the limit.
+// Storage may not wrap reader errors, so callers check Exceeded after failed upload.
+type uploadLimitReader struct {
+	reader io.Reader
+	left   int64
+}
+
+func newUploadLimitReader(reader io.Reader, limit int64) *uploadLimitReader {
+	if limit < 0 {
+		limit = math.MaxInt64 - 1
+	}
+	return &uploadLimitReader{reader: reader, left: limit}
+}
+
+func (r *uploadLimitReader) Exceeded() bool {
+	return r.left < 0
+}
+
+func (r *uploadLimitReader) Read(p []byte) (int, error) {
+	if r.Exceeded() {
+		return 0, errUploadLimitExceeded
+	}
+	// read one byte more than allowed to detect overflow
+	if int64(len(p)) > r.left+1 {
+		p = p[:r.left+1]
+	}
+	n, err := r.reader.Read(p)
+	r.left -= int64(n)
+	if r.left < 0 {
+		return n, errUploadLimitExceeded
+	}
+	return n, err
+}
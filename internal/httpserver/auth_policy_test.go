@@ -0,0 +1,96 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestTokenClientPolicyFollowsGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		groups   []string
+		wantCode int
+	}{
+		{name: "never logged in by browser", wantCode: http.StatusRequestEntityTooLarge},
+		{name: "group without policy", groups: []string{"staff"}, wantCode: http.StatusRequestEntityTooLarge},
+		{name: "group with policy", groups: []string{"staff", "uploaders"}, wantCode: http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeStorage := newFakeStorage()
+			fakeStorage.addUser("user@example.com", nil).meta.Groups = tt.groups
+			s := newTestServer(t, fakeStorage, &AuthConfig{
+				DefaultPolicy: &AuthPolicy{MaxUploadSizeBytes: 5},
+				GroupPolicies: map[string]*AuthPolicy{"uploaders": {MaxUploadSizeBytes: 100}},
+			})
+
+			response, body := serve(s, newTokenRequest(http.MethodPut, "/u/file.txt", "hello world", "user@example.com", "token"))
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, tt.wantCode, body)
+			}
+		})
+	}
+}
+
+func TestShareRespectsPublicShares(t *testing.T) {
+	tests := []struct {
+		name     string
+		groups   []string
+		path     string
+		wantCode int
+	}{
+		{name: "share of default policy", path: "/share/", wantCode: http.StatusOK},
+		{name: "rss of default policy", path: "/rss/", wantCode: http.StatusOK},
+		{name: "share of group without public shares", groups: []string{"contractors"}, path: "/share/", wantCode: http.StatusNotFound},
+		{name: "rss of group without public shares", groups: []string{"contractors"}, path: "/rss/", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeStorage := newFakeStorage()
+			user := fakeStorage.addUser("user@example.com", map[string]string{"file.txt": "data"})
+			user.meta.Groups = tt.groups
+			s := newTestServer(t, fakeStorage, &AuthConfig{
+				GroupPolicies: map[string]*AuthPolicy{"contractors": {PublicShares: false}},
+			})
+
+			response, body := serve(s, httptest.NewRequest(http.MethodGet, tt.path+user.meta.ShareId, nil))
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, tt.wantCode, body)
+			}
+		})
+	}
+}
+
+func TestRememberGroups(t *testing.T) {
+	tests := []struct {
+		name       string
+		stored     []string
+		groups     []string
+		wantStored []string
+	}{
+		{name: "first login", groups: []string{"b", "a"}, wantStored: []string{"a", "b"}},
+		{name: "same groups in another order", stored: []string{"a", "b"}, groups: []string{"b", "a"}, wantStored: []string{"a", "b"}},
+		{name: "removed from group", stored: []string{"a", "b"}, groups: []string{"a"}, wantStored: []string{"a"}},
+		{name: "removed from all groups", stored: []string{"a"}, groups: nil, wantStored: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeStorage := newFakeStorage()
+			fakeStorage.addUser("user@example.com", nil).meta.Groups = tt.stored
+			userStorage, err := fakeStorage.OpenStorage(context.Background(), "user@example.com", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := rememberGroups(context.Background(), userStorage, tt.groups); err != nil {
+				t.Fatal(err)
+			}
+			meta, _ := userStorage.GetMetadata(context.Background())
+			if !slices.Equal(meta.Groups, tt.wantStored) {
+				t.Fatalf("stored groups %v, want %v", meta.Groups, tt.wantStored)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package httpserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// AuthProxyConfig enables authentication by headers of trusted reverse proxy, e.g. oauth2-proxy or authelia
+type AuthProxyConfig struct {
+	EmailHeader  string
+	GroupsHeader string
+	// AllowedGroups restricts access to members of any of groups, empty list allows everyone
+	AllowedGroups []string
+}
+
+func (c *AuthProxyConfig) Validate() error {
+	if c.EmailHeader == "" {
+		return fmt.Errorf("email header should not be empty")
+	}
+	return nil
+}
+
+func (s *httpServer) isTrustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIp returns address of client, X-Forwarded-For is honoured only behind trusted proxy,
+// rightmost untrusted hop is taken because leftmost ones are set by client
+func (s *httpServer) clientIp(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	if !s.isTrustedProxy(request.RemoteAddr) {
+		return host
+	}
+	hops := strings.Split(strings.Join(request.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		host = hop
+		if !s.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// checkAuthorizationByProxy returns ok=false if request is not authenticated by trusted proxy,
+// so other auth methods should be tried
+func (s *httpServer) checkAuthorizationByProxy(request *http.Request) (auth *authContext, ok bool, err error) {
+	cfg := s.authConfig.Proxy
+	if cfg == nil || !s.isTrustedProxy(request.RemoteAddr) {
+		return nil, false, nil
+	}
+	email := strings.TrimSpace(request.Header.Get(cfg.EmailHeader))
+	if email == "" {
+		return nil, false, nil
+	}
+
+	var groups []string
+	if cfg.GroupsHeader != "" {
+		for _, group := range strings.Split(request.Header.Get(cfg.GroupsHeader), ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
+	if len(cfg.AllowedGroups) > 0 && !slices.ContainsFunc(groups, func(group string) bool {
+		return slices.Contains(cfg.AllowedGroups, group)
+	}) {
+		return nil, true, fmt.Errorf("user '%s' is not member of allowed groups", email)
+	}
+
+	return &authContext{
+		Email:  email,
+		Groups: groups,
+		Policy: s.authConfig.policyFor(groups),
+	}, true, nil
+}
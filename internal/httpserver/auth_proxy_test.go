@@ -0,0 +1,135 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheckAuthorizationByProxy(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		wantOk     bool
+		wantErr    bool
+		wantEmail  string
+		wantGroups []string
+		// wantMaxUpload tells group policy from default one
+		wantMaxUpload int64
+	}{
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Email": "user@example.com", "X-Groups": "staff"},
+			wantOk:     true, wantEmail: "user@example.com", wantGroups: []string{"staff"}, wantMaxUpload: 100,
+		},
+		{
+			name:       "spoofed headers from untrusted address",
+			remoteAddr: "203.0.113.1:1234",
+			headers:    map[string]string{"X-Email": "admin@example.com", "X-Groups": "staff"},
+		},
+		{
+			name:       "spoofed headers in x-forwarded-for of untrusted address",
+			remoteAddr: "203.0.113.1:1234",
+			headers:    map[string]string{"X-Email": "admin@example.com", "X-Forwarded-For": "10.0.0.1"},
+		},
+		{
+			name:       "missing email header",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Groups": "staff"},
+		},
+		{
+			name:       "blank email header",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Email": "  ", "X-Groups": "staff"},
+		},
+		{
+			name:       "group mapping",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Email": "user@example.com", "X-Groups": " contractors , ,staff"},
+			wantOk:     true, wantEmail: "user@example.com", wantGroups: []string{"contractors", "staff"}, wantMaxUpload: 100,
+		},
+		{
+			name:       "unmapped group gets default policy",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Email": "user@example.com", "X-Groups": "contractors"},
+			wantOk:     true, wantEmail: "user@example.com", wantGroups: []string{"contractors"}, wantMaxUpload: 10,
+		},
+		{
+			name:       "no allowed group",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Email": "user@example.com", "X-Groups": "guests"},
+			wantOk:     true, wantErr: true,
+		},
+		{
+			name:       "no groups header",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Email": "user@example.com"},
+			wantOk:     true, wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, newFakeStorage(), &AuthConfig{
+				Proxy: &AuthProxyConfig{
+					EmailHeader:   "X-Email",
+					GroupsHeader:  "X-Groups",
+					AllowedGroups: []string{"staff", "contractors"},
+				},
+				DefaultPolicy: &AuthPolicy{MaxUploadSizeBytes: 10},
+				GroupPolicies: map[string]*AuthPolicy{"staff": {MaxUploadSizeBytes: 100}},
+			})
+			s.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				request.Header.Set(name, value)
+			}
+
+			auth, ok, err := s.checkAuthorizationByProxy(request)
+			if ok != tt.wantOk || (err != nil) != tt.wantErr {
+				t.Fatalf("ok %t, error %v, want ok %t and error %t", ok, err, tt.wantOk, tt.wantErr)
+			}
+			if !tt.wantOk || tt.wantErr {
+				if auth != nil {
+					t.Fatalf("auth context %+v, want none", auth)
+				}
+				return
+			}
+			if auth.Email != tt.wantEmail || !slices.Equal(auth.Groups, tt.wantGroups) {
+				t.Fatalf("email %s and groups %q, want %s and %q", auth.Email, auth.Groups, tt.wantEmail, tt.wantGroups)
+			}
+			if auth.Policy.MaxUploadSizeBytes != tt.wantMaxUpload {
+				t.Fatalf("max upload %d, want %d", auth.Policy.MaxUploadSizeBytes, tt.wantMaxUpload)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareByProxy(t *testing.T) {
+	fakeStorage := newFakeStorage()
+	fakeStorage.addUser("user@example.com", nil)
+	fakeStorage.addUser("admin@example.com", nil)
+	s := newTestServer(t, fakeStorage, &AuthConfig{Proxy: &AuthProxyConfig{EmailHeader: "X-Email"}})
+	s.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	request := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	request.Header.Set("X-Email", "user@example.com")
+	response, body := serve(s, request)
+	if response.StatusCode != http.StatusOK || !strings.Contains(body, "user@example.com") {
+		t.Fatalf("status %d, want whoami page of user: %s", response.StatusCode, body)
+	}
+
+	spoofed := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+	spoofed.RemoteAddr = "203.0.113.1:1234"
+	spoofed.Header.Set("X-Email", "admin@example.com")
+	_, body = serve(s, spoofed)
+	if strings.Contains(body, "admin@example.com") {
+		t.Fatalf("spoofed header from untrusted address is accepted: %s", body)
+	}
+}
@@ -0,0 +1,89 @@
+package httpserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const returnToParam = "return_to"
+
+// returnToSigner protects return-to paths passed through login links and forms,
+// so crafted links can not bounce user after login to arbitrary url
+type returnToSigner struct {
+	key []byte
+}
+
+func newReturnToSigner(cookieKey string) *returnToSigner {
+	return &returnToSigner{key: []byte(cookieKey)}
+}
+
+func (s *returnToSigner) mac(path string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte("return_to:" + path))
+	return mac.Sum(nil)
+}
+
+func (s *returnToSigner) sign(path string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(path)) + "." + base64.RawURLEncoding.EncodeToString(s.mac(path))
+}
+
+// verify returns path if value is signed and points to this server
+func (s *returnToSigner) verify(value string) (string, bool) {
+	encodedPath, encodedMac, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", false
+	}
+	path, err := base64.RawURLEncoding.DecodeString(encodedPath)
+	if err != nil {
+		return "", false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMac)
+	if err != nil {
+		return "", false
+	}
+	if !hmac.Equal(mac, s.mac(string(path))) || !isLocalPath(string(path)) {
+		return "", false
+	}
+	return string(path), true
+}
+
+// returnPath returns verified path of signed return-to value or fallback
+func (s *returnToSigner) returnPath(value string, fallback string) string {
+	if path, ok := s.verify(value); ok {
+		return path
+	}
+	return fallback
+}
+
+// isLocalPath rejects absolute and protocol relative urls
+func isLocalPath(path string) bool {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return false
+	}
+	parsed, err := url.Parse(path)
+	return err == nil && parsed.Scheme == "" && parsed.Host == ""
+}
+
+// loginReturnTo returns signed return-to value for login page, empty if user should land on main page
+func (s *httpServer) loginReturnTo(r *http.Request) string {
+	value := r.URL.Query().Get(returnToParam)
+	if value == "" {
+		// form of local login, body is never parsed here to not read uploads of unauthenticated requests
+		value = r.PostForm.Get(returnToParam)
+	}
+	if value != "" {
+		if _, ok := s.returnTo.verify(value); ok {
+			return value
+		}
+		return ""
+	}
+	// htmx requests and api calls can not be repeated by browser navigation
+	if r.Method != http.MethodGet || r.Header.Get("HX-Request") != "" || r.URL.Path == "/login" || r.URL.Path == "/" {
+		return ""
+	}
+	return s.returnTo.sign(r.URL.RequestURI())
+}
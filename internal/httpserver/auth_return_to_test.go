@@ -0,0 +1,84 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReturnToSignerVerify(t *testing.T) {
+	signer := newReturnToSigner(testCookieKey)
+	signed := signer.sign("/files?path=a.txt")
+	_, mac, _ := strings.Cut(signed, ".")
+	tests := []struct {
+		name     string
+		value    string
+		wantPath string
+		wantOk   bool
+	}{
+		{name: "signed path", value: signed, wantPath: "/files?path=a.txt", wantOk: true},
+		{name: "signed by another key", value: newReturnToSigner("another").sign("/files")},
+		{name: "mac of another path", value: strings.Split(signer.sign("/admin"), ".")[0] + "." + mac},
+		{name: "tampered mac", value: signed + "A"},
+		{name: "no mac", value: strings.Split(signed, ".")[0]},
+		{name: "invalid base64", value: "!!!." + mac},
+		{name: "empty", value: ""},
+		{name: "absolute url", value: signer.sign("https://evil.example.com/")},
+		{name: "protocol relative url", value: signer.sign("//evil.example.com/")},
+		{name: "backslash url", value: signer.sign("/\\evil.example.com/")},
+		{name: "relative path", value: signer.sign("files")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, ok := signer.verify(tt.value)
+			if ok != tt.wantOk || path != tt.wantPath {
+				t.Fatalf("verify is %q %v, want %q %v", path, ok, tt.wantPath, tt.wantOk)
+			}
+			if !tt.wantOk {
+				if got := signer.returnPath(tt.value, "/fallback"); got != "/fallback" {
+					t.Fatalf("return path %q, want fallback", got)
+				}
+			}
+		})
+	}
+}
+
+func TestLoginReturnTo(t *testing.T) {
+	s := newTestServer(t, newFakeStorage(), nil)
+	signed := s.returnTo.sign("/admin")
+	tests := []struct {
+		name   string
+		method string
+		target string
+		htmx   bool
+		// want is path of signed value, empty if no return-to is expected
+		want string
+	}{
+		{name: "page", method: http.MethodGet, target: "/admin/user/user@example.com?x=1", want: "/admin/user/user@example.com?x=1"},
+		{name: "main page", method: http.MethodGet, target: "/"},
+		{name: "login page", method: http.MethodGet, target: "/login"},
+		{name: "htmx request", method: http.MethodGet, target: "/admin", htmx: true},
+		{name: "api call", method: http.MethodPost, target: "/api/upload"},
+		{name: "signed param is kept", method: http.MethodGet, target: "/login?return_to=" + signed, want: "/admin"},
+		{name: "unsigned param is dropped", method: http.MethodGet, target: "/login?return_to=/admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.htmx {
+				request.Header.Set("HX-Request", "true")
+			}
+			value := s.loginReturnTo(request)
+			if tt.want == "" {
+				if value != "" {
+					t.Fatalf("return-to %q, want empty", value)
+				}
+				return
+			}
+			if path, ok := s.returnTo.verify(value); !ok || path != tt.want {
+				t.Fatalf("return-to %q is verified as %q %v, want %q", value, path, ok, tt.want)
+			}
+		})
+	}
+}
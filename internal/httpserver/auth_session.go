@@ -0,0 +1,165 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/paragor/sharefile/internal/log"
+	"github.com/paragor/sharefile/internal/sessions"
+	httphelper "github.com/zitadel/oidc/v3/pkg/http"
+)
+
+const sessionCookieName = "session_id"
+
+func newSession(r *http.Request, method string, email string, groups []string, ttl time.Duration) *sessions.Session {
+	now := time.Now()
+	return &sessions.Session{
+		Id:         sessions.NewId(),
+		Email:      email,
+		Groups:     groups,
+		Method:     method,
+		UserAgent:  r.UserAgent(),
+		RemoteAddr: r.RemoteAddr,
+		CreatedAt:  now,
+		ExpireAt:   now.Add(ttl),
+	}
+}
+
+// startSession saves session and sets its id into signed cookie
+func startSession(w http.ResponseWriter, r *http.Request, store sessions.Store, cookieHandler *httphelper.CookieHandler, session *sessions.Session) error {
+	if err := store.Save(r.Context(), session); err != nil {
+		return fmt.Errorf("cant save session: %w", err)
+	}
+	if err := cookieHandler.SetCookie(w, sessionCookieName, session.Id); err != nil {
+		return fmt.Errorf("cant set session cookie: %w", err)
+	}
+	log.FromContext(r.Context()).With(slog.String("user", session.Email), slog.String("method", session.Method)).Info("session started")
+	return nil
+}
+
+// sessionFromRequest returns nil if request has no active session
+func (s *httpServer) sessionFromRequest(r *http.Request) *sessions.Session {
+	id, err := s.cookieHandler.CheckCookie(r, sessionCookieName)
+	if err != nil || id == "" {
+		return nil
+	}
+	session, err := s.sessions.Get(r.Context(), id)
+	if err != nil {
+		if !errors.Is(err, sessions.ErrNotFound) {
+			log.FromContext(r.Context()).With(log.Error(err)).Error("cant get session")
+		}
+		return nil
+	}
+	return session
+}
+
+func deleteSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+	})
+}
+
+func (s *httpServer) apiLogout(w http.ResponseWriter, r *http.Request) {
+	redirect := "/login"
+	if session := s.sessionFromRequest(r); session != nil {
+		if err := s.sessions.Delete(r.Context(), session.Id); err != nil {
+			httpError(r.Context(), w, "cant delete session", err, http.StatusInternalServerError)
+			return
+		}
+		// log out at identity provider too, otherwise user is silently logged in again
+		if oc := s.oidcByName(session.OidcProvider); session.Method == sessions.MethodOidc && oc != nil {
+			if endSessionUrl := oc.endSessionUrl(session, s.serverPublicUrl+"/login"); endSessionUrl != "" {
+				redirect = endSessionUrl
+			}
+		}
+	}
+	deleteSessionCookie(w)
+	w.Header().Set("HX-Redirect", redirect)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+func (s *httpServer) apiLogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	email, err := s.extractEmail(r)
+	if err != nil {
+		httpError(r.Context(), w, "cant read email from request", err, http.StatusInternalServerError)
+		return
+	}
+	if err := sessions.DeleteByIndex(r.Context(), s.sessions, sessions.IndexEmail(email)); err != nil {
+		httpError(r.Context(), w, "cant delete sessions", err, http.StatusInternalServerError)
+		return
+	}
+	deleteSessionCookie(w)
+	w.Header().Set("HX-Redirect", "/login")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+func (s *httpServer) apiRevokeSession(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		httpError(r.Context(), w, "query param 'id' is empty", fmt.Errorf("no id in query"), http.StatusBadRequest)
+		return
+	}
+	email, err := s.extractEmail(r)
+	if err != nil {
+		httpError(r.Context(), w, "cant read email from request", err, http.StatusInternalServerError)
+		return
+	}
+	session, err := s.sessions.Get(r.Context(), id)
+	if err != nil || session.Email != email {
+		httpError(r.Context(), w, "session not found", fmt.Errorf("session of '%s' is not found: %v", email, err), http.StatusNotFound)
+		return
+	}
+	if err := s.sessions.Delete(r.Context(), id); err != nil {
+		httpError(r.Context(), w, "cant delete session", err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(""))
+}
+
+// keyedLocks serializes work by key within one replica, locks are removed when nobody holds them
+type keyedLocks struct {
+	mutex sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	holders int
+}
+
+func newKeyedLocks() *keyedLocks {
+	return &keyedLocks{locks: map[string]*keyedLock{}}
+}
+
+func (l *keyedLocks) lock(key string) (unlock func()) {
+	l.mutex.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		l.locks[key] = lock
+	}
+	lock.holders++
+	l.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mutex.Lock()
+		lock.holders--
+		if lock.holders == 0 {
+			delete(l.locks, key)
+		}
+		l.mutex.Unlock()
+	}
+}
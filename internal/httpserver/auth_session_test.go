@@ -0,0 +1,224 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paragor/sharefile/internal/sessions"
+)
+
+// newSessionRequest returns request with signed cookie of saved session
+func newSessionRequest(t *testing.T, s *httpServer, session *sessions.Session, method string, target string) *http.Request {
+	t.Helper()
+	if err := s.sessions.Save(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	if err := s.cookieHandler.SetCookie(recorder, sessionCookieName, session.Id); err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(method, target, nil)
+	for _, cookie := range recorder.Result().Cookies() {
+		request.AddCookie(cookie)
+	}
+	return request
+}
+
+func newOidcSession(provider string, idToken string) *sessions.Session {
+	return &sessions.Session{
+		Id:           sessions.NewId(),
+		Email:        "user@example.com",
+		Method:       sessions.MethodOidc,
+		OidcProvider: provider,
+		IdToken:      idToken,
+		RefreshToken: "refresh",
+		CreatedAt:    time.Now(),
+		ExpireAt:     time.Now().Add(time.Hour),
+	}
+}
+
+func TestAuthenticateOidcSession(t *testing.T) {
+	expired := map[string]any{"iat": time.Now().Add(-2 * time.Hour).Unix(), "exp": time.Now().Add(-time.Hour).Unix()}
+	respond := func(status int, body string) func(*fakeIdp) http.HandlerFunc {
+		return func(*fakeIdp) http.HandlerFunc {
+			return func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(body))
+			}
+		}
+	}
+	refreshed := func(claims map[string]any) func(*fakeIdp) http.HandlerFunc {
+		return func(idp *fakeIdp) http.HandlerFunc {
+			return func(w http.ResponseWriter, _ *http.Request) {
+				writeJson(w, map[string]any{"access_token": "access", "token_type": "Bearer", "expires_in": 3600, "id_token": idp.sign(t, claims)})
+			}
+		}
+	}
+	disconnect := func(*fakeIdp) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				_ = conn.Close()
+			}
+		}
+	}
+	tests := []struct {
+		name          string
+		idToken       map[string]any
+		provider      string
+		allowedGroups []string
+		// groupsFromUserinfo makes refresh fetch userinfo, userinfo handler is default if nil
+		groupsFromUserinfo bool
+		userinfo           func(*fakeIdp) http.HandlerFunc
+		// token is nil when idp token endpoint is down
+		token           func(idp *fakeIdp) http.HandlerFunc
+		wantCode        int
+		wantSessionKept bool
+	}{
+		{name: "valid id token", wantCode: http.StatusOK, wantSessionKept: true},
+		{name: "refreshed", idToken: expired, token: refreshed(nil), wantCode: http.StatusOK, wantSessionKept: true},
+		{name: "idp responds 5xx", idToken: expired, wantCode: http.StatusServiceUnavailable, wantSessionKept: true},
+		{name: "idp drops connection", idToken: expired, token: disconnect, wantCode: http.StatusServiceUnavailable, wantSessionKept: true},
+		{name: "refresh token is revoked", idToken: expired, token: respond(http.StatusBadRequest, `{"error":"invalid_grant"}`), wantCode: http.StatusUnauthorized},
+		{name: "client is rejected", idToken: expired, token: respond(http.StatusUnauthorized, `{"error":"invalid_client"}`), wantCode: http.StatusUnauthorized},
+		{name: "refreshed id token is invalid", idToken: expired, token: refreshed(map[string]any{"aud": []string{"another"}}), wantCode: http.StatusUnauthorized},
+		{name: "refreshed id token has another nonce", idToken: expired, token: refreshed(map[string]any{"nonce": "another"}), wantCode: http.StatusUnauthorized},
+		{name: "userinfo", idToken: expired, token: refreshed(nil), groupsFromUserinfo: true, allowedGroups: []string{"staff"}, wantCode: http.StatusOK, wantSessionKept: true},
+		{name: "userinfo responds 5xx", idToken: expired, token: refreshed(nil), groupsFromUserinfo: true, userinfo: respond(http.StatusBadGateway, ""), wantCode: http.StatusServiceUnavailable, wantSessionKept: true},
+		{name: "userinfo rejects access token", idToken: expired, token: refreshed(nil), groupsFromUserinfo: true, userinfo: respond(http.StatusUnauthorized, ""), wantCode: http.StatusUnauthorized},
+		{name: "userinfo of another subject", idToken: expired, token: refreshed(nil), groupsFromUserinfo: true, userinfo: respond(http.StatusOK, `{"sub":"another"}`), wantCode: http.StatusUnauthorized},
+		{name: "identity mismatch", idToken: map[string]any{"email": "other@example.com"}, wantCode: http.StatusUnauthorized},
+		{name: "removed from allowed groups", allowedGroups: []string{"staff"}, wantCode: http.StatusUnauthorized},
+		{name: "provider is removed from config", provider: "removed", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIdp(t)
+			if tt.token != nil {
+				idp.setToken(tt.token(idp))
+			}
+			if tt.userinfo != nil {
+				idp.setUserinfo(tt.userinfo(idp))
+			}
+			provider := idp.providerConfig("corp")
+			provider.AllowedGroups = tt.allowedGroups
+			provider.GroupsFromUserinfo = tt.groupsFromUserinfo
+			fakeStorage := newFakeStorage()
+			fakeStorage.addUser("user@example.com", nil)
+			s := newTestServer(t, fakeStorage, &AuthConfig{Oidc: []*AuthOidcConfig{provider}})
+			sessionProvider := "corp"
+			if tt.provider != "" {
+				sessionProvider = tt.provider
+			}
+			session := newOidcSession(sessionProvider, idp.sign(t, tt.idToken))
+
+			response, body := serve(s, newSessionRequest(t, s, session, http.MethodGet, "/"))
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, tt.wantCode, body)
+			}
+			_, err := s.sessions.Get(context.Background(), session.Id)
+			if kept := err == nil; kept != tt.wantSessionKept {
+				t.Fatalf("session kept %v, want %v: %v", kept, tt.wantSessionKept, err)
+			}
+			if err != nil && !errors.Is(err, sessions.ErrNotFound) {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestOidcRefreshWithoutIdToken(t *testing.T) {
+	idp := newFakeIdp(t)
+	idp.setToken(func(w http.ResponseWriter, _ *http.Request) {
+		writeJson(w, map[string]any{"access_token": "access", "token_type": "Bearer", "expires_in": 3600, "refresh_token": "rotated"})
+	})
+	fakeStorage := newFakeStorage()
+	fakeStorage.addUser("user@example.com", nil)
+	s := newTestServer(t, fakeStorage, &AuthConfig{Oidc: []*AuthOidcConfig{idp.providerConfig("corp")}})
+	idToken := idp.sign(t, map[string]any{"iat": time.Now().Add(-2 * time.Hour).Unix(), "exp": time.Now().Add(-time.Hour).Unix()})
+	session := newOidcSession("corp", idToken)
+	request := newSessionRequest(t, s, session, http.MethodGet, "/")
+
+	for i := range 2 {
+		response, body := serve(s, request.Clone(context.Background()))
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status %d: %s", i, response.StatusCode, body)
+		}
+	}
+	// kept id token is trusted until refreshed access token expires
+	if requests := idp.tokenRequestsCount(); requests != 1 {
+		t.Fatalf("%d refresh requests, want 1", requests)
+	}
+	stored, err := s.sessions.Get(context.Background(), session.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.IdToken != idToken || stored.RefreshToken != "rotated" {
+		t.Fatalf("session has id token changed %v, refresh token %q", stored.IdToken != idToken, stored.RefreshToken)
+	}
+	if until := time.Until(stored.IdTokenExtendedUntil); until < 50*time.Minute || until > time.Hour {
+		t.Fatalf("id token is extended for %s, want expiration of access token", until)
+	}
+
+	// once access token expires, tokens are refreshed again
+	stored.IdTokenExtendedUntil = time.Now().Add(-time.Second)
+	if err := s.sessions.Save(context.Background(), stored); err != nil {
+		t.Fatal(err)
+	}
+	if response, body := serve(s, request.Clone(context.Background())); response.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", response.StatusCode, body)
+	}
+	if requests := idp.tokenRequestsCount(); requests != 2 {
+		t.Fatalf("%d refresh requests, want 2", requests)
+	}
+}
+
+func TestOidcRefreshIsSerializedPerSession(t *testing.T) {
+	idp := newFakeIdp(t)
+	lock := sync.Mutex{}
+	refreshToken := "refresh"
+	// idp rotates refresh token and rejects reused one
+	idp.setToken(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.PostFormValue("refresh_token") != refreshToken {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		refreshToken = "rotated"
+		writeJson(w, map[string]any{"access_token": "access", "token_type": "Bearer", "refresh_token": refreshToken, "id_token": idp.sign(t, nil)})
+	})
+	fakeStorage := newFakeStorage()
+	fakeStorage.addUser("user@example.com", nil)
+	s := newTestServer(t, fakeStorage, &AuthConfig{Oidc: []*AuthOidcConfig{idp.providerConfig("corp")}})
+	session := newOidcSession("corp", idp.sign(t, map[string]any{"iat": time.Now().Add(-2 * time.Hour).Unix(), "exp": time.Now().Add(-time.Hour).Unix()}))
+	request := newSessionRequest(t, s, session, http.MethodGet, "/")
+
+	wg := sync.WaitGroup{}
+	codes := make([]int, 5)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, _ := serve(s, request.Clone(context.Background()))
+			codes[i] = response.StatusCode
+		}()
+	}
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i, code)
+		}
+	}
+	if requests := idp.tokenRequestsCount(); requests != 1 {
+		t.Fatalf("%d refresh requests, want 1", requests)
+	}
+}
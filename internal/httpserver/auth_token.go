@@ -0,0 +1,73 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/paragor/sharefile/internal/log"
+	"github.com/paragor/sharefile/internal/storage"
+)
+
+// TokenAuthMiddleware authenticates non-browser clients by basic auth, where username is email and password is api token
+func (s *httpServer) TokenAuthMiddleware() mux.MiddlewareFunc {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			email, token, ok := request.BasicAuth()
+			if !ok {
+				writer.Header().Set("WWW-Authenticate", `Basic realm="sharefile", charset="UTF-8"`)
+				httpError(request.Context(), writer, "authorization required", fmt.Errorf("no basic auth in request"), http.StatusUnauthorized)
+				return
+			}
+			// throttle is checked before storage, so guesses do not cost s3 reads
+			clientIp, target := s.clientIp(request), "token:"+email
+			if wait := s.throttle.retryAfter(clientIp, target); wait > 0 {
+				writeThrottled(request, writer, &throttledError{retryAfter: wait})
+				return
+			}
+			meta, err := s.checkApiToken(request.Context(), email, token)
+			if err != nil {
+				s.throttle.fail(clientIp, target)
+				writer.Header().Set("WWW-Authenticate", `Basic realm="sharefile", charset="UTF-8"`)
+				httpError(request.Context(), writer, "invalid credentials", err, http.StatusUnauthorized)
+				return
+			}
+			// token clients have no identity provider session, groups are known since last browser login
+			auth := &authContext{
+				Email:  email,
+				Groups: meta.Groups,
+				Policy: s.authConfig.policyFor(meta.Groups),
+			}
+
+			ctx := request.Context()
+			ctx = context.WithValue(ctx, authContextKeyValue, auth)
+			logger := log.FromContext(ctx).With(
+				slog.String("user", auth.Email),
+			)
+			logger.Debug("token auth middleware is successfully passed")
+			ctx = log.PutIntoContext(ctx, logger)
+			handler.ServeHTTP(writer, request.WithContext(ctx))
+		})
+	}
+}
+
+func (s *httpServer) checkApiToken(ctx context.Context, email string, token string) (*storage.Metadata, error) {
+	if email == "" || token == "" {
+		return nil, fmt.Errorf("empty email or token")
+	}
+	userStorage, err := s.storage.OpenStorage(ctx, email, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open user scoped storage: %w", err)
+	}
+	meta, err := userStorage.GetMetadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch metadata: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(meta.ApiToken)) != 1 {
+		return nil, fmt.Errorf("api token mismatch for '%s'", email)
+	}
+	return meta, nil
+}
@@ -0,0 +1,95 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/paragor/sharefile/internal/storage"
+)
+
+// userStatesTTL bounds how long other replicas keep serving user disabled by admin,
+// sessions of disabled user are deleted at once and handlers opening storage reject it anyway
+const userStatesTTL = 10 * time.Second
+
+// userStates caches whether user is disabled and which groups are saved in its metadata
+type userStates struct {
+	lock     sync.Mutex
+	states   map[string]userState
+	purgedAt time.Time
+}
+
+type userState struct {
+	disabled bool
+	// groups are sorted
+	groups   []string
+	expireAt time.Time
+}
+
+func newUserStates() *userStates {
+	return &userStates{states: map[string]userState{}, purgedAt: time.Now()}
+}
+
+func (c *userStates) get(email string) (userState, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	state, ok := c.states[email]
+	if !ok || time.Now().After(state.expireAt) {
+		return userState{}, false
+	}
+	return state, true
+}
+
+func (c *userStates) set(email string, state userState) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if now.Sub(c.purgedAt) > userStatesTTL {
+		c.purgedAt = now
+		for key, cached := range c.states {
+			if now.After(cached.expireAt) {
+				delete(c.states, key)
+			}
+		}
+	}
+	state.expireAt = now.Add(userStatesTTL)
+	c.states[email] = state
+}
+
+// invalidate should be called after user is disabled or enabled
+func (c *userStates) invalidate(email string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.states, email)
+}
+
+// checkUserState rejects disabled user and saves its groups, it writes http error on failure
+func (s *httpServer) checkUserState(w http.ResponseWriter, r *http.Request, auth *authContext) bool {
+	groups := slices.Sorted(slices.Values(auth.Groups))
+	state, ok := s.userStates.get(auth.Email)
+	if ok && state.disabled {
+		httpError(r.Context(), w, "user is disabled", storage.ErrUserDisabled, http.StatusForbidden)
+		return false
+	}
+	if ok && slices.Equal(state.groups, groups) {
+		return true
+	}
+	userStorage, err := s.storage.OpenStorage(r.Context(), auth.Email, true)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserDisabled) {
+			s.userStates.set(auth.Email, userState{disabled: true})
+			httpError(r.Context(), w, "user is disabled", err, http.StatusForbidden)
+			return false
+		}
+		httpError(r.Context(), w, "unable to open user scoped storage", err, http.StatusInternalServerError)
+		return false
+	}
+	if err := rememberGroups(r.Context(), userStorage, groups); err != nil {
+		httpError(r.Context(), w, "unable to save user groups", err, http.StatusInternalServerError)
+		return false
+	}
+	s.userStates.set(auth.Email, userState{groups: groups})
+	return true
+}
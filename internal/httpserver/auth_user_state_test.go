@@ -0,0 +1,123 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCheckUserState(t *testing.T) {
+	type step struct {
+		groups []string
+		// disable changes state of user by admin api before request
+		disable   *bool
+		wantOk    bool
+		wantOpens int
+	}
+	enable, disable := false, true
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "state is cached",
+			steps: []step{
+				{groups: []string{"b", "a"}, wantOk: true, wantOpens: 1},
+				{groups: []string{"a", "b"}, wantOk: true, wantOpens: 1},
+			},
+		},
+		{
+			name: "changed groups are saved",
+			steps: []step{
+				{groups: []string{"a"}, wantOk: true, wantOpens: 1},
+				{groups: []string{"b"}, wantOk: true, wantOpens: 2},
+			},
+		},
+		{
+			name: "disabling invalidates cache",
+			steps: []step{
+				{wantOk: true, wantOpens: 1},
+				{disable: &disable, wantOk: false, wantOpens: 2},
+				{wantOk: false, wantOpens: 2},
+				{disable: &enable, wantOk: true, wantOpens: 3},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeStorage := newFakeStorage()
+			fakeStorage.addUser("user@example.com", nil)
+			s := newTestServer(t, fakeStorage, nil)
+			for i, step := range tt.steps {
+				if step.disable != nil {
+					target := "/admin/api/disable?email=user@example.com&disabled=" + strconv.FormatBool(*step.disable)
+					recorder := httptest.NewRecorder()
+					s.apiAdminSetDisabled(recorder, httptest.NewRequest(http.MethodPost, target, nil))
+					if recorder.Code != http.StatusOK {
+						t.Fatalf("step %d: admin api responded %d", i, recorder.Code)
+					}
+				}
+				recorder := httptest.NewRecorder()
+				auth := &authContext{Email: "user@example.com", Groups: step.groups}
+				ok := s.checkUserState(recorder, httptest.NewRequest(http.MethodGet, "/", nil), auth)
+				if ok != step.wantOk {
+					t.Fatalf("step %d: check is %v, want %v, response %d", i, ok, step.wantOk, recorder.Code)
+				}
+				if !ok && recorder.Code != http.StatusForbidden {
+					t.Fatalf("step %d: responded %d, want 403", i, recorder.Code)
+				}
+				if opens := fakeStorage.opensCount(); opens != step.wantOpens {
+					t.Fatalf("step %d: storage is opened %d times, want %d", i, opens, step.wantOpens)
+				}
+				if ok {
+					meta := fakeStorage.users["user@example.com"].meta
+					if want := slices.Sorted(slices.Values(step.groups)); !slices.Equal(meta.Groups, want) {
+						t.Fatalf("step %d: saved groups %v, want %v", i, meta.Groups, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestHtmxPageAdminUsersIsLazy(t *testing.T) {
+	fakeStorage := newFakeStorage()
+	fakeStorage.addUser("first@example.com", map[string]string{"a.txt": "12345"})
+	fakeStorage.addUser("second@example.com", map[string]string{"b.txt": "1", "c.txt": "2"})
+	s := newTestServer(t, fakeStorage, nil)
+
+	recorder := httptest.NewRecorder()
+	s.htmxPageAdminUsers(recorder, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("page responded %d", recorder.Code)
+	}
+	for _, email := range []string{"first@example.com", "second@example.com"} {
+		if !strings.Contains(recorder.Body.String(), "/admin/api/user-row?email="+url.QueryEscape(email)) {
+			t.Fatalf("page has no lazy row of %s", email)
+		}
+	}
+
+	tests := []struct {
+		email     string
+		wantCode  int
+		wantFiles string
+	}{
+		{email: "first@example.com", wantCode: http.StatusOK, wantFiles: "<td>1</td>"},
+		{email: "second@example.com", wantCode: http.StatusOK, wantFiles: "<td>2</td>"},
+		{email: "unknown@example.com", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		s.htmxAdminUsersRow(recorder, httptest.NewRequest(http.MethodGet, "/admin/api/user-row?email="+tt.email, nil))
+		if recorder.Code != tt.wantCode {
+			t.Fatalf("row of %s responded %d, want %d", tt.email, recorder.Code, tt.wantCode)
+		}
+		if tt.wantFiles != "" && !strings.Contains(recorder.Body.String(), tt.wantFiles) {
+			t.Fatalf("row of %s has no %s: %s", tt.email, tt.wantFiles, recorder.Body.String())
+		}
+	}
+}
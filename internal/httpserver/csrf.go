@@ -0,0 +1,71 @@
+package httpserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+)
+
+const csrfHeaderName = "X-CSRF-Token"
+
+// csrfToken is bound to session, or to user if request is authenticated by proxy,
+// so it does not need its own cookie and is rotated with session
+func (s *httpServer) csrfToken(auth *authContext) string {
+	mac := hmac.New(sha256.New, []byte(s.authConfig.CookieKey))
+	if auth.SessionId != "" {
+		mac.Write([]byte("csrf-session:" + auth.SessionId))
+	} else {
+		mac.Write([]byte("csrf-user:" + auth.Email))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CsrfMiddleware requires token from page/index in header of state-changing requests, must be used after AuthMiddleware
+func (s *httpServer) CsrfMiddleware() mux.MiddlewareFunc {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			switch request.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				handler.ServeHTTP(writer, request)
+				return
+			}
+			auth, err := s.extractAuthContext(request)
+			if err != nil {
+				httpError(request.Context(), writer, "cant read auth from request", err, http.StatusInternalServerError)
+				return
+			}
+			token := request.Header.Get(csrfHeaderName)
+			if token == "" || !hmac.Equal([]byte(token), []byte(s.csrfToken(auth))) {
+				httpError(request.Context(), writer, "invalid csrf token, reload page", fmt.Errorf("csrf token mismatch for '%s'", auth.Email), http.StatusForbidden)
+				return
+			}
+			handler.ServeHTTP(writer, request)
+		})
+	}
+}
+
+// checkSameOrigin rejects cross-site form posts, which have no session yet and so no csrf token, e.g. login csrf
+// signing victim in account of attacker. Browsers send Origin or Referer with posts, requests without both are not from forms
+func (s *httpServer) checkSameOrigin(request *http.Request) error {
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		origin = request.Referer()
+	}
+	if origin == "" {
+		return nil
+	}
+	public, err := url.Parse(s.serverPublicUrl)
+	if err != nil {
+		return fmt.Errorf("cant parse server public url: %w", err)
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Scheme != public.Scheme || parsed.Host != public.Host {
+		return fmt.Errorf("origin '%s' is not '%s://%s'", origin, public.Scheme, public.Host)
+	}
+	return nil
+}
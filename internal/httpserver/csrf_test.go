@@ -0,0 +1,121 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paragor/sharefile/internal/sessions"
+)
+
+func newLocalSession(email string) *sessions.Session {
+	return &sessions.Session{
+		Id:        sessions.NewId(),
+		Email:     email,
+		Method:    sessions.MethodLocal,
+		CreatedAt: time.Now(),
+		ExpireAt:  time.Now().Add(time.Hour),
+	}
+}
+
+func TestCsrfMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		// safe sends GET instead of DELETE
+		safe bool
+		// token is auth context the csrf header is computed for, nil sends no header
+		token      func(session *sessions.Session) *authContext
+		wantCode   int
+		wantExists bool
+	}{
+		{
+			name:     "token of session",
+			token:    func(session *sessions.Session) *authContext { return &authContext{SessionId: session.Id} },
+			wantCode: http.StatusOK,
+		},
+		{
+			name:       "no token",
+			wantCode:   http.StatusForbidden,
+			wantExists: true,
+		},
+		{
+			name:       "token of another session",
+			token:      func(*sessions.Session) *authContext { return &authContext{SessionId: sessions.NewId()} },
+			wantCode:   http.StatusForbidden,
+			wantExists: true,
+		},
+		{
+			name:       "token of user instead of session",
+			token:      func(*sessions.Session) *authContext { return &authContext{Email: "user@example.com"} },
+			wantCode:   http.StatusForbidden,
+			wantExists: true,
+		},
+		{
+			name:       "safe method without token",
+			safe:       true,
+			wantCode:   http.StatusOK,
+			wantExists: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeStorage := newFakeStorage()
+			fakeStorage.addUser("user@example.com", map[string]string{"a.txt": "data"})
+			s := newTestServer(t, fakeStorage, &AuthConfig{Local: &AuthLocalConfig{Users: []AuthLocalUser{{Email: "user@example.com"}}}})
+			session := newLocalSession("user@example.com")
+			method, target := http.MethodDelete, "/api/delete?path=a.txt"
+			if tt.safe {
+				method, target = http.MethodGet, "/api/link?path=a.txt"
+			}
+			request := newSessionRequest(t, s, session, method, target)
+			if tt.token != nil {
+				request.Header.Set(csrfHeaderName, s.csrfToken(tt.token(session)))
+			}
+
+			response, body := serve(s, request)
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, tt.wantCode, body)
+			}
+			if _, exists := fakeStorage.file("user@example.com", "a.txt"); exists != tt.wantExists {
+				t.Fatalf("file exists %v, want %v", exists, tt.wantExists)
+			}
+		})
+	}
+}
+
+func TestCsrfTokenIsRenderedInPage(t *testing.T) {
+	fakeStorage := newFakeStorage()
+	fakeStorage.addUser("user@example.com", nil)
+	s := newTestServer(t, fakeStorage, &AuthConfig{
+		Local: &AuthLocalConfig{Users: []AuthLocalUser{{Email: "user@example.com"}}},
+		Proxy: &AuthProxyConfig{EmailHeader: "X-Email"},
+	})
+	s.trustedProxies = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	session := newLocalSession("user@example.com")
+	proxied := httptest.NewRequest(http.MethodGet, "/", nil)
+	proxied.RemoteAddr = "192.0.2.1:1234"
+	proxied.Header.Set("X-Email", "user@example.com")
+
+	tests := []struct {
+		name      string
+		request   *http.Request
+		wantToken string
+	}{
+		{name: "session", request: newSessionRequest(t, s, session, http.MethodGet, "/"), wantToken: s.csrfToken(&authContext{SessionId: session.Id})},
+		{name: "proxy", request: proxied, wantToken: s.csrfToken(&authContext{Email: "user@example.com"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, body := serve(s, tt.request)
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("status %d: %s", response.StatusCode, body)
+			}
+			if !strings.Contains(body, tt.wantToken) {
+				t.Fatalf("page has no csrf token %s", tt.wantToken)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"html/template"
 	"math"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -26,8 +27,16 @@ type listContextFile struct {
 type mainContext struct {
 	AuthCompleted bool
 	Email         string
+	IsAdmin       bool
 	RssLink       string
 	ShareLink     string
+	ApiToken      string
+	UploadUrl     string
+	// WebhooksEnabled shows link to webhooks page
+	WebhooksEnabled bool
+	// CsrfToken is sent by htmx in header of every request from page
+	CsrfToken string
+	CspNonce  string
 
 	ChildComponent any
 }
@@ -38,12 +47,17 @@ func (s *httpServer) htmxPrepareMainContext(r *http.Request) *mainContext {
 		return &mainContext{
 			AuthCompleted: false,
 			Email:         "",
+			CspNonce:      cspNonce(r.Context()),
 		}
 	}
 	return &mainContext{
-		AuthCompleted:  true,
-		Email:          auth.Email,
-		ChildComponent: nil,
+		AuthCompleted:   true,
+		Email:           auth.Email,
+		IsAdmin:         auth.IsAdmin,
+		WebhooksEnabled: s.webhooks != nil,
+		CsrfToken:       s.csrfToken(auth),
+		CspNonce:        cspNonce(r.Context()),
+		ChildComponent:  nil,
 	}
 }
 func (s *httpServer) htmxPageMain(w http.ResponseWriter, r *http.Request) {
@@ -80,14 +94,53 @@ func (s *httpServer) htmxPageMain(w http.ResponseWriter, r *http.Request) {
 	renderContext := s.htmxPrepareMainContext(r)
 	renderContext.ChildComponent = template.HTML(uploadForm.String()) + listFilesHtml
 
-	renderContext.RssLink = s.getRssLink(meta)
-	renderContext.ShareLink = s.getShareLink(meta)
+	if auth, err := s.extractAuthContext(r); err == nil && auth.Policy.PublicShares {
+		renderContext.RssLink = s.getRssLink(meta)
+		renderContext.ShareLink = s.getShareLink(meta)
+	}
+	renderContext.ApiToken = meta.ApiToken
+	renderContext.UploadUrl = s.serverPublicUrl + "/u/"
 
 	writeHtmx(w, r, "page/index", renderContext, 200)
 }
 
+type loginContext struct {
+	Providers    []loginContextProvider
+	LocalEnabled bool
+	LocalError   string
+	// ReturnTo is signed path to open after login
+	ReturnTo string
+}
+type loginContextProvider struct {
+	DisplayName string
+	LoginPath   string
+}
+
 func (s *httpServer) htmxPageLogin(w http.ResponseWriter, r *http.Request) {
-	oidcHtmx, err := renderHtmx("component/auth_oidc_challenge", "")
+	s.htmxRenderLogin(w, r, "")
+}
+
+func (s *httpServer) htmxRenderLogin(w http.ResponseWriter, r *http.Request, localError string) {
+	login := loginContext{
+		LocalEnabled: s.authConfig.Local != nil,
+		LocalError:   localError,
+		ReturnTo:     s.loginReturnTo(r),
+	}
+	for _, oidc := range s.oidc {
+		displayName := oidc.cfg.DisplayName
+		if displayName == "" {
+			displayName = "OIDC"
+		}
+		loginPath := oidc.loginPath
+		if login.ReturnTo != "" {
+			loginPath += "?" + url.Values{returnToParam: {login.ReturnTo}}.Encode()
+		}
+		login.Providers = append(login.Providers, loginContextProvider{
+			DisplayName: displayName,
+			LoginPath:   loginPath,
+		})
+	}
+	oidcHtmx, err := renderHtmx("component/auth_oidc_challenge", login)
 	if err != nil {
 		httpError(r.Context(), w, "error on render oidc auth", err, http.StatusInternalServerError)
 		return
@@ -122,10 +175,10 @@ func (s *httpServer) htmxComponentListFiles(ctx context.Context, userScopedStora
 }
 
 func (s *httpServer) getRssLink(meta *storage.Metadata) string {
-	return fmt.Sprintf("%s/rss/%s/%s", s.serverPublicUrl, meta.Email, meta.Secret)
+	return fmt.Sprintf("%s/rss/%s", s.serverPublicUrl, meta.ShareId)
 }
 func (s *httpServer) getShareLink(meta *storage.Metadata) string {
-	return fmt.Sprintf("%s/share/%s/%s", s.serverPublicUrl, meta.Email, meta.Secret)
+	return fmt.Sprintf("%s/share/%s", s.serverPublicUrl, meta.ShareId)
 }
 
 func bytesConvert(bytes int) string {
@@ -1,10 +1,10 @@
 package httpserver
 
 import (
-	"fmt"
 	"html/template"
 	"net/http"
-	"strings"
+
+	"github.com/paragor/sharefile/internal/audit"
 )
 
 type sharePageContext struct {
@@ -17,34 +17,17 @@ type sharePageFile struct {
 }
 
 func (s *httpServer) htmxPageShare(w http.ResponseWriter, r *http.Request) {
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 2 {
-		httpError(
-			r.Context(),
-			w,
-			"invalid url",
-			fmt.Errorf("invalid url for rss: %s", r.URL.Path),
-			http.StatusInternalServerError,
-		)
-		return
+	userStorage, meta, err := s.openShare(r)
+	event := audit.Event{Action: audit.ActionShareViewed, Interface: "web"}
+	if meta != nil {
+		event.Owner = meta.Email
 	}
-
-	email, rssSecret := parts[len(parts)-2], parts[len(parts)-1]
-	userStorage, err := s.storage.OpenStorage(r.Context(), email, false)
+	s.notifyWebhooks(r.Context(), s.audit(r, event, err))
 	if err != nil {
-		httpError(r.Context(), w, "unable communicate with storage", err, http.StatusInternalServerError)
-		return
-	}
-
-	meta, err := userStorage.GetMetadata(r.Context())
-	if err != nil {
-		httpError(r.Context(), w, "cant read metadata from storage", err, http.StatusInternalServerError)
-		return
-	}
-	if rssSecret != meta.Secret {
-		httpError(r.Context(), w, "invalid rss secret", err, http.StatusUnauthorized)
+		shareError(r, w, err)
 		return
 	}
+	shareHitsTotal.WithLabelValues("share").Inc()
 
 	listing, err := userStorage.ListFiles(r.Context())
 	if err != nil {
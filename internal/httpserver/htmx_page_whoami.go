@@ -5,12 +5,24 @@ import (
 	"html/template"
 	"net/http"
 	"time"
+
+	"github.com/paragor/sharefile/internal/sessions"
 )
 
 type whoAmIContext struct {
 	Expiration      time.Duration
 	TokenPrettyJson string
 	Email           string
+	Sessions        []whoAmIContextSession
+}
+type whoAmIContextSession struct {
+	Id         string
+	Current    bool
+	Method     string
+	UserAgent  string
+	RemoteAddr string
+	CreatedAt  time.Time
+	ExpireAt   time.Time
 }
 
 func (s *httpServer) htmxPageWhoami(w http.ResponseWriter, r *http.Request) {
@@ -26,18 +38,40 @@ func (s *httpServer) htmxPageWhoami(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	whoamiHtmx, err := renderHtmx("component/whoami", whoAmIContext{
-		Expiration:      auth.ExpireAt.Sub(time.Now()),
+	expiration := time.Duration(0)
+	if !auth.ExpireAt.IsZero() {
+		expiration = auth.ExpireAt.Sub(time.Now())
+	}
+	activeSessions, err := s.sessions.List(r.Context(), sessions.IndexEmail(auth.Email))
+	if err != nil {
+		httpError(r.Context(), w, "error on listing sessions", err, http.StatusInternalServerError)
+		return
+	}
+	renderContext := whoAmIContext{
+		Expiration:      expiration,
 		TokenPrettyJson: string(token),
 		Email:           auth.Email,
-	})
+		Sessions:        make([]whoAmIContextSession, 0, len(activeSessions)),
+	}
+	for _, session := range activeSessions {
+		renderContext.Sessions = append(renderContext.Sessions, whoAmIContextSession{
+			Id:         session.Id,
+			Current:    session.Id == auth.SessionId,
+			Method:     session.Method,
+			UserAgent:  session.UserAgent,
+			RemoteAddr: session.RemoteAddr,
+			CreatedAt:  session.CreatedAt,
+			ExpireAt:   session.ExpireAt,
+		})
+	}
+	whoamiHtmx, err := renderHtmx("component/whoami", renderContext)
 	if err != nil {
 		httpError(r.Context(), w, "error on render whoami component", err, http.StatusInternalServerError)
 		return
 	}
 
-	renderContext := s.htmxPrepareMainContext(r)
-	renderContext.ChildComponent = template.HTML(whoamiHtmx.String())
+	mainContext := s.htmxPrepareMainContext(r)
+	mainContext.ChildComponent = template.HTML(whoamiHtmx.String())
 
-	writeHtmx(w, r, "page/index", renderContext, http.StatusOK)
+	writeHtmx(w, r, "page/index", mainContext, http.StatusOK)
 }
@@ -0,0 +1,161 @@
+package httpserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+const (
+	fakeIdpClientId = "client"
+	fakeIdpKeyId    = "key"
+)
+
+// fakeIdp serves discovery, jwks and token endpoint, tokens are signed by its rsa key
+type fakeIdp struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	lock sync.Mutex
+	// token handles token endpoint, it responds 500 if nil
+	token         http.HandlerFunc
+	tokenRequests int
+	// userinfo handles userinfo endpoint, it responds with claims of default id token if nil
+	userinfo http.HandlerFunc
+}
+
+func newFakeIdp(t *testing.T) *fakeIdp {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdp{key: key}
+	idp.server = httptest.NewServer(http.HandlerFunc(idp.serveHTTP))
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (i *fakeIdp) issuer() string {
+	return i.server.URL
+}
+
+func (i *fakeIdp) providerConfig(name string) *AuthOidcConfig {
+	return &AuthOidcConfig{
+		Name:         name,
+		ClientId:     fakeIdpClientId,
+		ClientSecret: "secret",
+		IssuerUrl:    i.issuer(),
+		Scopes:       []string{"openid", "email"},
+	}
+}
+
+func (i *fakeIdp) setToken(handler http.HandlerFunc) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.token = handler
+}
+
+func (i *fakeIdp) setUserinfo(handler http.HandlerFunc) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.userinfo = handler
+}
+
+func (i *fakeIdp) tokenRequestsCount() int {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.tokenRequests
+}
+
+func (i *fakeIdp) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeJson(w, map[string]any{
+			"issuer":                                i.issuer(),
+			"authorization_endpoint":                i.issuer() + "/auth",
+			"token_endpoint":                        i.issuer() + "/token",
+			"userinfo_endpoint":                     i.issuer() + "/userinfo",
+			"jwks_uri":                              i.issuer() + "/jwks",
+			"end_session_endpoint":                  i.issuer() + "/logout",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	case "/jwks":
+		writeJson(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: i.key.Public(), KeyID: fakeIdpKeyId, Algorithm: "RS256", Use: "sig"}}})
+	case "/userinfo":
+		i.lock.Lock()
+		handler := i.userinfo
+		i.lock.Unlock()
+		if handler != nil {
+			handler(w, r)
+			return
+		}
+		writeJson(w, map[string]any{"sub": "subject", "email": "user@example.com", "email_verified": true, "groups": []string{"staff"}})
+	case "/token":
+		i.lock.Lock()
+		i.tokenRequests++
+		handler := i.token
+		i.lock.Unlock()
+		if handler == nil {
+			http.Error(w, "token endpoint is not configured", http.StatusInternalServerError)
+			return
+		}
+		handler(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// sign issues jwt with claims, claims of id token are filled by default and may be overridden, nil value removes claim
+func (i *fakeIdp) sign(t *testing.T, overrides map[string]any) string {
+	t.Helper()
+	now := time.Now()
+	claims := map[string]any{
+		"iss":            i.issuer(),
+		"aud":            []string{fakeIdpClientId},
+		"sub":            "subject",
+		"email":          "user@example.com",
+		"email_verified": true,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+	for name, value := range overrides {
+		if value == nil {
+			delete(claims, name)
+			continue
+		}
+		claims[name] = value
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: i.key, KeyID: fakeIdpKeyId}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := signed.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func writeJson(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,130 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/paragor/sharefile/internal/log"
+	"github.com/paragor/sharefile/internal/sessions"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sharefile_http_request_duration_seconds",
+		Help:    "Duration of http requests by route group",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+	downloadLinksTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sharefile_download_links_generated_total",
+		Help: "Download links generated by users",
+	})
+	shareHitsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sharefile_share_hits_total",
+		Help: "Opened share pages and rss feeds",
+	}, []string{"kind"})
+)
+
+// MetricsMiddleware observes duration of requests of route group, e.g. public or api
+func MetricsMiddleware(route string) mux.MiddlewareFunc {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: writer, status: http.StatusOK}
+			handler.ServeHTTP(recorder, request)
+			httpRequestDurationSeconds.
+				WithLabelValues(route, request.Method, strconv.Itoa(recorder.status)).
+				Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *statusRecorder) Flush() {
+	r.wroteHeader = true
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap is used by http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// sessionsCollector exports number of active sessions, it is cached because store may be s3
+type sessionsCollector struct {
+	store sessions.Store
+	desc  *prometheus.Desc
+
+	lock      sync.Mutex
+	count     int
+	updatedAt time.Time
+}
+
+func newSessionsCollector(store sessions.Store) *sessionsCollector {
+	return &sessionsCollector{
+		store: store,
+		desc:  prometheus.NewDesc("sharefile_active_sessions", "Stored login sessions", nil, nil),
+	}
+}
+
+func (c *sessionsCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- c.desc
+}
+
+func (c *sessionsCollector) Collect(metrics chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if time.Since(c.updatedAt) > time.Minute {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		count, err := c.store.Count(ctx)
+		if err != nil {
+			log.FromContext(ctx).With(log.Error(err)).Error("cant count sessions for metrics")
+			metrics <- prometheus.NewInvalidMetric(c.desc, err)
+			return
+		}
+		c.count = count
+		c.updatedAt = time.Now()
+	}
+	metrics <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(c.count))
+}
+
+// registerSessionsCollector replaces collector of previous server, so only current store is exported
+func registerSessionsCollector(store sessions.Store) error {
+	collector := newSessionsCollector(store)
+	if err := prometheus.Register(collector); err != nil {
+		var already prometheus.AlreadyRegisteredError
+		if !errors.As(err, &already) {
+			return err
+		}
+		prometheus.Unregister(already.ExistingCollector)
+		return prometheus.Register(collector)
+	}
+	return nil
+}
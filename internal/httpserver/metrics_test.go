@@ -0,0 +1,183 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/paragor/sharefile/internal/sessions"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gatherValue returns sample count of histogram or value of gauge with labels, ok=false if series is not exported
+func gatherValue(t *testing.T, name string, labels map[string]string) (value float64, ok bool) {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			matched := 0
+			for _, label := range metric.GetLabel() {
+				if want, ok := labels[label.GetName()]; ok && want == label.GetValue() {
+					matched++
+				}
+			}
+			if matched != len(labels) {
+				continue
+			}
+			if metric.GetHistogram() != nil {
+				return float64(metric.GetHistogram().GetSampleCount()), true
+			}
+			return metric.GetGauge().GetValue(), true
+		}
+	}
+	return 0, false
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		handler  http.HandlerFunc
+		wantCode string
+	}{
+		{
+			name:     "implicit ok",
+			method:   http.MethodGet,
+			handler:  func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("ok")) },
+			wantCode: "200",
+		},
+		{
+			name:     "explicit status",
+			method:   http.MethodPost,
+			handler:  func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusCreated) },
+			wantCode: "201",
+		},
+		{
+			name:   "first status wins",
+			method: http.MethodPut,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			wantCode: "404",
+		},
+		{
+			name:   "status after body is ignored",
+			method: http.MethodDelete,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("ok"))
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			wantCode: "200",
+		},
+		{
+			name:   "status after flush is ignored",
+			method: http.MethodPatch,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if err := http.NewResponseController(w).Flush(); err != nil {
+					t.Errorf("cant flush through recorder: %s", err)
+				}
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			wantCode: "200",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := "test-" + tt.method
+			labels := map[string]string{"route": route, "method": tt.method, "code": tt.wantCode}
+			before, _ := gatherValue(t, "sharefile_http_request_duration_seconds", labels)
+			MetricsMiddleware(route)(tt.handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, "/", nil))
+			after, ok := gatherValue(t, "sharefile_http_request_duration_seconds", labels)
+			if !ok || after != before+1 {
+				t.Fatalf("%v requests with labels %v, want %v", after, labels, before+1)
+			}
+		})
+	}
+}
+
+func TestMetricsMiddlewareRoutes(t *testing.T) {
+	fakeStorage := newFakeStorage()
+	user := fakeStorage.addUser("user@example.com", nil)
+	s := newTestServer(t, fakeStorage, nil)
+	tests := []struct {
+		path   string
+		labels map[string]string
+	}{
+		{path: "/share/" + user.meta.ShareId, labels: map[string]string{"route": "public", "method": "GET", "code": "200"}},
+		{path: "/share/unknown", labels: map[string]string{"route": "public", "method": "GET", "code": "404"}},
+		{path: "/healthz", labels: map[string]string{"route": "diags", "method": "GET", "code": "200"}},
+	}
+	for _, tt := range tests {
+		before, _ := gatherValue(t, "sharefile_http_request_duration_seconds", tt.labels)
+		serve(s, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if after, _ := gatherValue(t, "sharefile_http_request_duration_seconds", tt.labels); after != before+1 {
+			t.Fatalf("%s: %v requests with labels %v, want %v", tt.path, after, tt.labels, before+1)
+		}
+	}
+}
+
+type countingStore struct {
+	sessions.Store
+	count int
+	err   error
+	calls int
+}
+
+func (s *countingStore) Count(context.Context) (int, error) {
+	s.calls++
+	return s.count, s.err
+}
+
+func TestRegisterSessionsCollector(t *testing.T) {
+	first := &countingStore{Store: sessions.NewMemoryStore(), count: 1}
+	second := &countingStore{Store: sessions.NewMemoryStore(), count: 2}
+	for _, store := range []*countingStore{first, second} {
+		if err := registerSessionsCollector(store); err != nil {
+			t.Fatalf("cant register collector again: %s", err)
+		}
+	}
+	// collector of next test server must not be affected by this test
+	t.Cleanup(func() {
+		if err := registerSessionsCollector(sessions.NewMemoryStore()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if value, ok := gatherValue(t, "sharefile_active_sessions", nil); !ok || value != 2 {
+		t.Fatalf("active sessions %v, want count of last store", value)
+	}
+	if first.calls != 0 {
+		t.Fatalf("replaced store is counted %d times", first.calls)
+	}
+	second.count = 3
+	if value, _ := gatherValue(t, "sharefile_active_sessions", nil); value != 2 || second.calls != 1 {
+		t.Fatalf("active sessions %v after %d counts, want cached value", value, second.calls)
+	}
+}
+
+func TestSessionsCollectorError(t *testing.T) {
+	store := &countingStore{Store: sessions.NewMemoryStore(), err: errors.New("store is down")}
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(newSessionsCollector(store)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.Gather(); err == nil {
+		t.Fatal("error of store is not reported")
+	}
+	// failed count is not cached, so next scrape retries it
+	store.err = nil
+	store.count = 5
+	families, err := registry.Gather()
+	if err != nil || len(families) != 1 || families[0].GetMetric()[0].GetGauge().GetValue() != 5 {
+		t.Fatalf("families %v, %v, want 5 active sessions", families, err)
+	}
+}
@@ -0,0 +1,89 @@
+// Page behaviour lives here instead of inline scripts, so content security policy can forbid them.
+
+// Network errors
+(function () {
+    var hideTimeout;
+
+    function errorDiv() {
+        return document.querySelector('[data-network-error]');
+    }
+
+    function showError(message) {
+        var div = errorDiv();
+        if (!div) {
+            return;
+        }
+        div.style.display = 'block';
+        div.textContent = message;
+
+        // Clear any existing timeout to prevent multiple hide actions
+        clearTimeout(hideTimeout);
+
+        // Set a new timeout to hide the error message after 5 seconds
+        hideTimeout = setTimeout(function () {
+            div.style.display = 'none';
+        }, 5000);
+    }
+
+    // Hide error message when a new request is sent
+    document.addEventListener('htmx:beforeRequest', function () {
+        var div = errorDiv();
+        if (div) {
+            div.style.display = 'none';
+        }
+
+        // Clear any existing timeout to ensure it doesn't hide after a new request
+        clearTimeout(hideTimeout);
+    });
+
+    document.addEventListener('htmx:sendError', function () {
+        showError('Network error');
+    });
+
+    document.addEventListener('htmx:afterRequest', function (evt) {
+        if (!evt.detail.successful && (evt.detail.xhr.status === 0 || evt.detail.xhr.status >= 600)) {
+            showError('Request error');
+        }
+    });
+})();
+
+// Upload progress
+document.addEventListener('htmx:xhr:progress', function (evt) {
+    if (evt.target.id !== 'upload-form') {
+        return;
+    }
+    var progress = document.getElementById('progress');
+    if (progress) {
+        progress.setAttribute('value', evt.detail.loaded / evt.detail.total * 100);
+    }
+});
+
+// Copy buttons
+document.addEventListener('click', function (evt) {
+    var button = evt.target.closest('[data-clipboard-text]');
+    if (button) {
+        navigator.clipboard.writeText(button.getAttribute('data-clipboard-text'));
+    }
+});
+
+// Scroll up button
+document.addEventListener('DOMContentLoaded', function () {
+    var button = document.getElementById('btn-back-to-top');
+    if (!button) {
+        return;
+    }
+
+    window.addEventListener('scroll', function () {
+        if (document.body.scrollTop > 20 || document.documentElement.scrollTop > 20) {
+            button.style.display = 'block';
+        } else {
+            button.style.display = 'none';
+        }
+    });
+
+    // When the user clicks on the button, scroll to the top of the document
+    button.addEventListener('click', function () {
+        document.body.scrollTop = 0;
+        document.documentElement.scrollTop = 0;
+    });
+});
@@ -0,0 +1,122 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// RateLimit is token bucket: Burst requests at once, refilled with RequestsPerSecond
+type RateLimit struct {
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// RateLimitConfig nil group is not limited
+type RateLimitConfig struct {
+	Public *RateLimit
+	Htmx   *RateLimit
+	Api    *RateLimit
+}
+
+var rateLimitRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sharefile_rate_limit_rejected_total",
+	Help: "Requests rejected by rate limit",
+}, []string{"group", "key_type"})
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type rateLimiter struct {
+	limit RateLimit
+
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPurge time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:     limit,
+		buckets:   map[string]*tokenBucket{},
+		lastPurge: time.Now(),
+	}
+}
+
+// take returns 0 if request of key is allowed, otherwise time until next token
+func (l *rateLimiter) take(key string) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	l.purge(now)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(l.limit.Burst), updatedAt: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = l.refill(bucket, now)
+	bucket.updatedAt = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0
+	}
+	return time.Duration((1 - bucket.tokens) / l.limit.RequestsPerSecond * float64(time.Second))
+}
+
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	return min(float64(l.limit.Burst), bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*l.limit.RequestsPerSecond)
+}
+
+// purge forgets full buckets, they are the same as new ones
+func (l *rateLimiter) purge(now time.Time) {
+	if now.Sub(l.lastPurge) < time.Minute {
+		return
+	}
+	l.lastPurge = now
+	for key, bucket := range l.buckets {
+		if l.refill(bucket, now) >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimitMiddleware limits requests of authenticated user by email and of others by client ip,
+// should be used after AuthMiddleware in authenticated groups
+func (s *httpServer) RateLimitMiddleware(group string, limit *RateLimit) mux.MiddlewareFunc {
+	if limit == nil {
+		return func(handler http.Handler) http.Handler {
+			return handler
+		}
+	}
+	limiter := newRateLimiter(*limit)
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			keyType, key := "ip", s.clientIp(request)
+			if auth, err := s.extractAuthContext(request); err == nil {
+				keyType, key = "user", auth.Email
+			}
+			wait := limiter.take(keyType + ":" + key)
+			if wait <= 0 {
+				handler.ServeHTTP(writer, request)
+				return
+			}
+			rateLimitRejectedTotal.WithLabelValues(group, keyType).Inc()
+			writer.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			httpError(
+				request.Context(),
+				writer,
+				"too many requests",
+				fmt.Errorf("rate limit of '%s' group is exceeded by %s '%s'", group, keyType, key),
+				http.StatusTooManyRequests,
+			)
+		})
+	}
+}
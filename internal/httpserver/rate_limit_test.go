@@ -0,0 +1,89 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	tests := []struct {
+		name        string
+		limit       RateLimit
+		requests    int
+		idle        time.Duration
+		wantAllowed int
+	}{
+		{name: "burst", limit: RateLimit{RequestsPerSecond: 1, Burst: 3}, requests: 5, wantAllowed: 3},
+		{name: "refilled after idle", limit: RateLimit{RequestsPerSecond: 1, Burst: 3}, requests: 5, idle: 2 * time.Second, wantAllowed: 2},
+		{name: "refill is capped by burst", limit: RateLimit{RequestsPerSecond: 1, Burst: 3}, requests: 5, idle: time.Hour, wantAllowed: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRateLimiter(tt.limit)
+			if tt.idle > 0 {
+				// bucket is drained, then client is idle
+				for range tt.limit.Burst {
+					limiter.take("key")
+				}
+				limiter.buckets["key"].updatedAt = time.Now().Add(-tt.idle)
+			}
+			allowed := 0
+			var wait time.Duration
+			for range tt.requests {
+				if wait = limiter.take("key"); wait == 0 {
+					allowed++
+				}
+			}
+			if allowed != tt.wantAllowed {
+				t.Fatalf("allowed %d requests, want %d", allowed, tt.wantAllowed)
+			}
+			if allowed < tt.requests && (wait <= 0 || wait > time.Second) {
+				t.Fatalf("wait %s, want time of one token", wait)
+			}
+			if other := limiter.take("other"); other != 0 {
+				t.Fatalf("other key is limited for %s", other)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddlewareClientIp(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []netip.Prefix
+		// forwardedFor of two requests from proxy
+		forwardedFor [2]string
+		wantLimited  bool
+	}{
+		{name: "untrusted proxy", forwardedFor: [2]string{"203.0.113.1", "203.0.113.2"}, wantLimited: true},
+		{name: "trusted proxy", trustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, forwardedFor: [2]string{"203.0.113.1", "203.0.113.2"}},
+		{name: "same client behind trusted proxy", trustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, forwardedFor: [2]string{"203.0.113.1", "203.0.113.1"}, wantLimited: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, newFakeStorage(), nil)
+			s.trustedProxies = tt.trustedProxies
+			handler := s.RateLimitMiddleware("test", &RateLimit{RequestsPerSecond: 0.001, Burst: 1})(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			codes := [2]int{}
+			for i, forwardedFor := range tt.forwardedFor {
+				request := httptest.NewRequest(http.MethodGet, "/", nil)
+				request.RemoteAddr = "10.0.0.1:1234"
+				request.Header.Set("X-Forwarded-For", forwardedFor)
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, request)
+				codes[i] = recorder.Code
+			}
+			if codes[0] != http.StatusOK {
+				t.Fatalf("first request status %d, want 200", codes[0])
+			}
+			if limited := codes[1] == http.StatusTooManyRequests; limited != tt.wantLimited {
+				t.Fatalf("second request status %d, want limited %v", codes[1], tt.wantLimited)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/paragor/sharefile/internal/log"
+	"github.com/zitadel/oidc/v3/pkg/client"
+)
+
+type ReadinessConfig struct {
+	// CacheTTL is how long check results are reused, so frequent probes do not load storage and idp
+	CacheTTL time.Duration
+	// Timeout limits every check
+	Timeout time.Duration
+}
+
+const (
+	readinessStatusOk   = "ok"
+	readinessStatusFail = "fail"
+)
+
+type readinessCheck struct {
+	name string
+	// critical check fails readiness, others are only reported
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+type readinessResult struct {
+	Status          string    `json:"status"`
+	Critical        bool      `json:"critical"`
+	Error           string    `json:"error,omitempty"`
+	CheckedAt       time.Time `json:"checked_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+}
+
+type readinessResponse struct {
+	Status string                      `json:"status"`
+	Checks map[string]*readinessResult `json:"checks"`
+}
+
+// readinessChecker runs checks in parallel and caches results, concurrent probes wait for one run
+type readinessChecker struct {
+	cfg    *ReadinessConfig
+	checks []readinessCheck
+
+	lock      sync.Mutex
+	checkedAt time.Time
+	response  *readinessResponse
+}
+
+func newReadinessChecker(cfg *ReadinessConfig, checks []readinessCheck) *readinessChecker {
+	return &readinessChecker{cfg: cfg, checks: checks}
+}
+
+func (c *readinessChecker) check(ctx context.Context) *readinessResponse {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.response != nil && time.Since(c.checkedAt) < c.cfg.CacheTTL {
+		return c.response
+	}
+	response := &readinessResponse{Status: readinessStatusOk, Checks: make(map[string]*readinessResult, len(c.checks))}
+	results := make([]*readinessResult, len(c.checks))
+	wg := sync.WaitGroup{}
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx, check)
+		}()
+	}
+	wg.Wait()
+	for i, check := range c.checks {
+		response.Checks[check.name] = results[i]
+		if results[i].Status != readinessStatusOk {
+			if check.critical {
+				response.Status = readinessStatusFail
+			}
+			log.FromContext(ctx).With(slog.String("check", check.name), slog.String("error", results[i].Error)).Warn("readiness check failed")
+		}
+	}
+	c.response = response
+	c.checkedAt = time.Now()
+	return response
+}
+
+func (c *readinessChecker) run(ctx context.Context, check readinessCheck) *readinessResult {
+	// probe may be cancelled by kubelet, result is cached for other probes anyway
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.cfg.Timeout)
+	defer cancel()
+	start := time.Now()
+	err := check.check(ctx)
+	result := &readinessResult{
+		Status:          readinessStatusOk,
+		Critical:        check.critical,
+		CheckedAt:       start,
+		DurationSeconds: time.Since(start).Seconds(),
+	}
+	if err != nil {
+		result.Status = readinessStatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// apiReady responds 503 if storage is unavailable, liveness stays on apiPing, so pod is not restarted because of storage outage.
+// Idp is reported, but does not fail readiness: all replicas share one idp, so removing them from service does not help,
+// and share links, webdav and api tokens keep working without it
+func (s *httpServer) apiReady(w http.ResponseWriter, r *http.Request) {
+	response := s.readiness.check(r.Context())
+	code := http.StatusOK
+	if response.Status != readinessStatusOk {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func (s *httpServer) readinessChecks() []readinessCheck {
+	checks := []readinessCheck{{name: "storage", critical: true, check: s.storage.Ping}}
+	for _, oidc := range s.oidc {
+		name := "oidc"
+		if oidc.cfg.Name != "" {
+			name += ":" + oidc.cfg.Name
+		}
+		checks = append(checks, readinessCheck{name: name, check: oidc.checkIdp})
+	}
+	return checks
+}
+
+// checkIdp fetches discovery document and jwks, so logins fail fast if idp is down or has no signing keys
+func (oc *authOidcContext) checkIdp(ctx context.Context) error {
+	discovery, err := client.Discover(ctx, oc.cfg.IssuerUrl, oc.provider.HttpClient())
+	if err != nil {
+		return fmt.Errorf("cant discover issuer: %w", err)
+	}
+	if discovery.JwksURI == "" {
+		return fmt.Errorf("discovery document has no jwks uri")
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, discovery.JwksURI, nil)
+	if err != nil {
+		return fmt.Errorf("cant create jwks request: %w", err)
+	}
+	response, err := oc.provider.HttpClient().Do(request)
+	if err != nil {
+		return fmt.Errorf("cant fetch jwks: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks responded with %d", response.StatusCode)
+	}
+	jwks := struct {
+		Keys []json.RawMessage `json:"keys"`
+	}{}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1024*1024)).Decode(&jwks); err != nil {
+		return fmt.Errorf("cant decode jwks: %w", err)
+	}
+	if len(jwks.Keys) == 0 {
+		return fmt.Errorf("jwks has no keys")
+	}
+	return nil
+}
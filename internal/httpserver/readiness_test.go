@@ -0,0 +1,50 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApiReady(t *testing.T) {
+	tests := []struct {
+		name        string
+		storageErr  error
+		idpDown     bool
+		wantCode    int
+		wantStorage string
+		wantOidc    string
+	}{
+		{name: "all ok", wantCode: http.StatusOK, wantStorage: readinessStatusOk, wantOidc: readinessStatusOk},
+		{name: "storage is down", storageErr: errors.New("bucket is gone"), wantCode: http.StatusServiceUnavailable, wantStorage: readinessStatusFail, wantOidc: readinessStatusOk},
+		{name: "idp is down", idpDown: true, wantCode: http.StatusOK, wantStorage: readinessStatusOk, wantOidc: readinessStatusFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIdp(t)
+			fakeStorage := newFakeStorage()
+			fakeStorage.pingErr = tt.storageErr
+			s := newTestServer(t, fakeStorage, &AuthConfig{Oidc: []*AuthOidcConfig{idp.providerConfig("corp")}})
+			if tt.idpDown {
+				idp.server.Close()
+			}
+
+			response, body := serve(s, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, tt.wantCode, body)
+			}
+			result := readinessResponse{}
+			if err := json.Unmarshal([]byte(body), &result); err != nil {
+				t.Fatal(err)
+			}
+			if got := result.Checks["storage"]; got == nil || got.Status != tt.wantStorage || !got.Critical {
+				t.Fatalf("storage check %+v, want critical %s", got, tt.wantStorage)
+			}
+			if got := result.Checks["oidc:corp"]; got == nil || got.Status != tt.wantOidc || got.Critical {
+				t.Fatalf("oidc check %+v, want non-critical %s", got, tt.wantOidc)
+			}
+		})
+	}
+}
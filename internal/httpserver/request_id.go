@@ -0,0 +1,40 @@
+package httpserver
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+	"github.com/paragor/sharefile/internal/log"
+)
+
+const requestIdHeaderName = "X-Request-ID"
+
+// validRequestId keeps ids of clients and proxies, other values are replaced to not let clients forge log lines
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIdContextKey struct{}
+
+var requestIdContextKeyValue = requestIdContextKey{}
+
+// requestId returns id of current request, empty outside of request
+func requestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdContextKeyValue).(string)
+	return id
+}
+
+// requestIdMiddleware takes id from X-Request-ID or generates it, returns it in response and adds it to logger
+func requestIdMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id := request.Header.Get(requestIdHeaderName)
+		if !validRequestId.MatchString(id) {
+			id = uuid.NewString()
+		}
+		writer.Header().Set(requestIdHeaderName, id)
+		ctx := context.WithValue(request.Context(), requestIdContextKeyValue, id)
+		ctx = log.PutIntoContext(ctx, log.FromContext(ctx).With(slog.String("request_id", id)))
+		handler.ServeHTTP(writer, request.WithContext(ctx))
+	})
+}
@@ -0,0 +1,67 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRequestIdMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		wantKept bool
+	}{
+		{name: "uuid", incoming: "0b4c8e36-7c1f-4a3e-9b7e-0e5f6a7b8c9d", wantKept: true},
+		{name: "id of proxy", incoming: "trace.1:span_2-3", wantKept: true},
+		{name: "longest id", incoming: strings.Repeat("a", 128), wantKept: true},
+		{name: "missing"},
+		{name: "oversized", incoming: strings.Repeat("a", 129)},
+		{name: "forged log line", incoming: "id\n{\"level\":\"ERROR\"}"},
+		{name: "spaces", incoming: "some id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handlerId string
+			handler := requestIdMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerId = requestId(r.Context())
+			}))
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				request.Header.Set(requestIdHeaderName, tt.incoming)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			echoed := recorder.Header().Get(requestIdHeaderName)
+			if echoed != handlerId {
+				t.Fatalf("response id %q, want id of handler %q", echoed, handlerId)
+			}
+			if tt.wantKept {
+				if handlerId != tt.incoming {
+					t.Fatalf("id %q, want incoming %q", handlerId, tt.incoming)
+				}
+				return
+			}
+			if _, err := uuid.Parse(handlerId); err != nil {
+				t.Fatalf("id %q is not generated uuid", handlerId)
+			}
+		})
+	}
+}
+
+func TestRequestIdInErrorResponse(t *testing.T) {
+	s := newTestServer(t, newFakeStorage(), nil)
+	request := httptest.NewRequest(http.MethodGet, "/share/unknown", nil)
+	request.Header.Set(requestIdHeaderName, "support-ticket-1")
+	response, body := serve(s, request)
+	if response.Header.Get(requestIdHeaderName) != "support-ticket-1" {
+		t.Fatalf("response id %q, want incoming id", response.Header.Get(requestIdHeaderName))
+	}
+	if response.StatusCode < 400 || !strings.Contains(body, "(request id: support-ticket-1)") {
+		t.Fatalf("status %d, want error with request id: %s", response.StatusCode, body)
+	}
+}
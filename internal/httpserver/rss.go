@@ -1,43 +1,25 @@
 package httpserver
 
 import (
-	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/gorilla/feeds"
+	"github.com/paragor/sharefile/internal/audit"
 )
 
 func (s *httpServer) generateRSS(w http.ResponseWriter, r *http.Request) {
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 2 {
-		httpError(
-			r.Context(),
-			w,
-			"invalid url",
-			fmt.Errorf("invalid url for rss: %s", r.URL.Path),
-			http.StatusInternalServerError,
-		)
-		return
-	}
-
-	email, rssSecret := parts[len(parts)-2], parts[len(parts)-1]
-	userStorage, err := s.storage.OpenStorage(r.Context(), email, false)
-	if err != nil {
-		httpError(r.Context(), w, "unable communicate with storage", err, http.StatusInternalServerError)
-		return
+	userStorage, meta, err := s.openShare(r)
+	event := audit.Event{Action: audit.ActionRssFetched, Interface: "web"}
+	if meta != nil {
+		event.Owner = meta.Email
 	}
-
-	meta, err := userStorage.GetMetadata(r.Context())
+	s.notifyWebhooks(r.Context(), s.audit(r, event, err))
 	if err != nil {
-		httpError(r.Context(), w, "cant read metadata from storage", err, http.StatusInternalServerError)
-		return
-	}
-	if rssSecret != meta.Secret {
-		httpError(r.Context(), w, "invalid rss secret", err, http.StatusUnauthorized)
+		shareError(r, w, err)
 		return
 	}
+	shareHitsTotal.WithLabelValues("rss").Inc()
 
 	listing, err := userStorage.ListFiles(r.Context())
 	if err != nil {
@@ -46,9 +28,8 @@ func (s *httpServer) generateRSS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	feed := &feeds.Feed{
-		Title:       "Share File Of " + email,
+		Title:       "Share File",
 		Description: "Shared files",
-		Author:      &feeds.Author{Name: email, Email: email},
 		Created:     time.Now(),
 	}
 
@@ -0,0 +1,377 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/paragor/sharefile/internal/audit"
+	"github.com/paragor/sharefile/internal/log"
+	"github.com/paragor/sharefile/internal/storage"
+)
+
+const (
+	s3GatewayPrefix = "/s3"
+	// s3GatewayBucket is the only virtual bucket, it is mapped to the caller's files
+	s3GatewayBucket   = "files"
+	s3ListMaxKeys     = 1000
+	s3XmlNamespace    = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3TimestampFormat = "2006-01-02T15:04:05.000Z"
+)
+
+type s3ErrorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestId string   `xml:"RequestId,omitempty"`
+}
+
+type s3ListObjectsV2Response struct {
+	XMLName               xml.Name           `xml:"ListBucketResult"`
+	Xmlns                 string             `xml:"xmlns,attr"`
+	Name                  string             `xml:"Name"`
+	Prefix                string             `xml:"Prefix"`
+	Delimiter             string             `xml:"Delimiter,omitempty"`
+	StartAfter            string             `xml:"StartAfter,omitempty"`
+	ContinuationToken     string             `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string             `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int                `xml:"KeyCount"`
+	MaxKeys               int                `xml:"MaxKeys"`
+	IsTruncated           bool               `xml:"IsTruncated"`
+	Contents              []s3ObjectInList   `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefixes `xml:"CommonPrefixes"`
+}
+
+type s3ObjectInList struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type s3CommonPrefixes struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func s3Error(ctx context.Context, w http.ResponseWriter, code string, publicMsg string, err error, status int) {
+	log.FromContext(ctx).With(log.Error(err), slog.Int("response_code", status), slog.String("s3_code", code)).Error(publicMsg)
+	writeS3Xml(ctx, w, &s3ErrorResponse{Code: code, Message: publicMsg, RequestId: requestId(ctx)}, status)
+}
+
+func writeS3Xml(ctx context.Context, w http.ResponseWriter, data any, status int) {
+	body, err := xml.Marshal(data)
+	if err != nil {
+		log.FromContext(ctx).With(log.Error(err)).Error("cant marshal s3 response")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(body)
+}
+
+// s3OpenStorage checks the bucket and opens caller's storage, it writes s3 error on failure
+func (s *httpServer) s3OpenStorage(w http.ResponseWriter, r *http.Request) (storage.UserScopedStorage, bool) {
+	if bucket := mux.Vars(r)["bucket"]; bucket != s3GatewayBucket {
+		s3Error(r.Context(), w, "NoSuchBucket", "the specified bucket does not exist", fmt.Errorf("unknown bucket '%s'", bucket), http.StatusNotFound)
+		return nil, false
+	}
+	email, err := s.extractEmail(r)
+	if err != nil {
+		s3Error(r.Context(), w, "InternalError", "cant read email from request", err, http.StatusInternalServerError)
+		return nil, false
+	}
+	userStorage, err := s.storage.OpenStorage(r.Context(), email, false)
+	if err != nil {
+		s3Error(r.Context(), w, "InternalError", "unable to open user scoped storage", err, http.StatusInternalServerError)
+		return nil, false
+	}
+	return userStorage, true
+}
+
+func (s *httpServer) s3HeadBucket(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.s3OpenStorage(w, r); !ok {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *httpServer) s3ListObjectsV2(w http.ResponseWriter, r *http.Request) {
+	userStorage, ok := s.s3OpenStorage(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" {
+		s3Error(r.Context(), w, "NotImplemented", "only ListObjectsV2 is supported", fmt.Errorf("list type is '%s'", query.Get("list-type")), http.StatusNotImplemented)
+		return
+	}
+	response := &s3ListObjectsV2Response{
+		Xmlns:             s3XmlNamespace,
+		Name:              s3GatewayBucket,
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		MaxKeys:           s3ListMaxKeys,
+	}
+	if maxKeys := query.Get("max-keys"); maxKeys != "" {
+		value, err := strconv.Atoi(maxKeys)
+		if err != nil || value < 0 {
+			s3Error(r.Context(), w, "InvalidArgument", "invalid max-keys", err, http.StatusBadRequest)
+			return
+		}
+		response.MaxKeys = min(value, s3ListMaxKeys)
+	}
+
+	listing, err := userStorage.ListFiles(r.Context())
+	if err != nil {
+		s3Error(r.Context(), w, "InternalError", "unable to list files", err, http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(listing, func(i, j int) bool {
+		return listing[i].Path < listing[j].Path
+	})
+
+	after := max(response.StartAfter, response.ContinuationToken)
+	seenPrefixes := map[string]bool{}
+	for _, file := range listing {
+		if !strings.HasPrefix(file.Path, response.Prefix) || file.Path <= after {
+			continue
+		}
+		key := file.Path
+		if response.Delimiter != "" {
+			if idx := strings.Index(key[len(response.Prefix):], response.Delimiter); idx >= 0 {
+				key = key[:len(response.Prefix)+idx+len(response.Delimiter)]
+				if seenPrefixes[key] || key <= after {
+					continue
+				}
+			}
+		}
+		if response.KeyCount >= response.MaxKeys {
+			response.IsTruncated = true
+			break
+		}
+		response.KeyCount++
+		response.NextContinuationToken = key
+		if key != file.Path {
+			seenPrefixes[key] = true
+			response.CommonPrefixes = append(response.CommonPrefixes, s3CommonPrefixes{Prefix: key})
+			continue
+		}
+		response.Contents = append(response.Contents, s3ObjectInList{
+			Key:          file.Path,
+			LastModified: file.LastModifiedAt.UTC().Format(s3TimestampFormat),
+			ETag:         file.ETag,
+			Size:         file.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+	if !response.IsTruncated {
+		response.NextContinuationToken = ""
+	}
+
+	writeS3Xml(r.Context(), w, response, http.StatusOK)
+}
+
+func (s *httpServer) s3PutObject(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	// files are flat, keys with slashes would be unreachable from web, share page and webdav
+	key := mux.Vars(r)["key"]
+	if err := validateFilePath(key); err != nil {
+		s3Error(r.Context(), w, "InvalidArgument", "object key should not contain '/'", err, http.StatusBadRequest)
+		return
+	}
+	userStorage, ok := s.s3OpenStorage(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		s3Error(r.Context(), w, "NotImplemented", "copy object is not supported", fmt.Errorf("copy source is set"), http.StatusNotImplemented)
+		return
+	}
+	auth, err := s.extractAuthContext(r)
+	if err != nil {
+		s3Error(r.Context(), w, "InternalError", "cant read auth from request", err, http.StatusInternalServerError)
+		return
+	}
+	limit, err := uploadLimit(r.Context(), auth.Policy, userStorage)
+	if err != nil {
+		s3Error(r.Context(), w, "InternalError", "unable to check upload limits", err, http.StatusInternalServerError)
+		return
+	}
+	if err := checkUploadSize(limit, r.ContentLength); err != nil {
+		s3Error(r.Context(), w, "EntityTooLarge", "object exceeds max upload size or quota", err, http.StatusBadRequest)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	body := newUploadLimitReader(r.Body, limit)
+	err = userStorage.Upload(r.Context(), key, contentType, body)
+	s.notifyWebhooks(r.Context(), s.audit(r, audit.Event{Action: audit.ActionUpload, Owner: auth.Email, Path: key, Interface: "s3"}, err))
+	if err != nil {
+		if body.Exceeded() {
+			s3Error(r.Context(), w, "EntityTooLarge", "object exceeds max upload size or quota", err, http.StatusBadRequest)
+			return
+		}
+		if payload, ok := r.Body.(s3PayloadReader); ok && payload.PayloadError() != nil {
+			payloadErr := payload.PayloadError()
+			s3Error(r.Context(), w, payloadErr.code, payloadErr.message, err, payloadErr.status)
+			return
+		}
+		s3Error(r.Context(), w, "InternalError", "error on upload file", err, http.StatusInternalServerError)
+		return
+	}
+	if file, err := userStorage.Stat(r.Context(), key); err == nil {
+		w.Header().Set("ETag", file.ETag)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *httpServer) s3GetObject(w http.ResponseWriter, r *http.Request) {
+	userStorage, ok := s.s3OpenStorage(w, r)
+	if !ok {
+		return
+	}
+	key := mux.Vars(r)["key"]
+	file, ok := s.s3StatObject(w, r, userStorage, key)
+	if !ok {
+		return
+	}
+	start, end, partial, err := parseByteRange(r.Header.Get("Range"), int64(file.Size))
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", file.Size))
+		s3Error(r.Context(), w, "InvalidRange", "the requested range is not satisfiable", err, http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	body, err := userStorage.Download(r.Context(), key, start)
+	s.audit(r, audit.Event{Action: audit.ActionDownload, Path: key, Interface: "s3"}, err)
+	if err != nil {
+		s3Error(r.Context(), w, "InternalError", "unable to download file", err, http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+
+	writeS3ObjectHeaders(w, file)
+	status := http.StatusOK
+	if partial {
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.Size))
+		w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	}
+	w.WriteHeader(status)
+	if _, err := io.Copy(w, io.LimitReader(body, end-start+1)); err != nil {
+		log.FromContext(r.Context()).With(log.Error(err)).Error("cant stream file")
+	}
+}
+
+func (s *httpServer) s3HeadObject(w http.ResponseWriter, r *http.Request) {
+	userStorage, ok := s.s3OpenStorage(w, r)
+	if !ok {
+		return
+	}
+	file, ok := s.s3StatObject(w, r, userStorage, mux.Vars(r)["key"])
+	if !ok {
+		return
+	}
+	writeS3ObjectHeaders(w, file)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *httpServer) s3DeleteObject(w http.ResponseWriter, r *http.Request) {
+	userStorage, ok := s.s3OpenStorage(w, r)
+	if !ok {
+		return
+	}
+	key := mux.Vars(r)["key"]
+	err := userStorage.Delete(r.Context(), key)
+	s.notifyWebhooks(r.Context(), s.audit(r, audit.Event{Action: audit.ActionDelete, Path: key, Interface: "s3"}, err))
+	if err != nil {
+		s3Error(r.Context(), w, "InternalError", "unable to delete file", err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *httpServer) s3StatObject(w http.ResponseWriter, r *http.Request, userStorage storage.UserScopedStorage, key string) (*storage.FileInList, bool) {
+	file, err := userStorage.Stat(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s3Error(r.Context(), w, "NoSuchKey", "the specified key does not exist", err, http.StatusNotFound)
+			return nil, false
+		}
+		s3Error(r.Context(), w, "InternalError", "unable to stat file", err, http.StatusInternalServerError)
+		return nil, false
+	}
+	return file, true
+}
+
+func writeS3ObjectHeaders(w http.ResponseWriter, file *storage.FileInList) {
+	w.Header().Set("Content-Length", strconv.Itoa(file.Size))
+	w.Header().Set("Last-Modified", file.LastModifiedAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("ETag", file.ETag)
+	w.Header().Set("Accept-Ranges", "bytes")
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(file.Path))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+}
+
+// parseByteRange returns inclusive bounds of single byte range, partial is false if header is absent or ignored,
+// as s3 does for multiple or malformed ranges, error means that range is not satisfiable
+func parseByteRange(header string, size int64) (start int64, end int64, partial bool, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, size - 1, false, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, size - 1, false, nil
+	}
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, size - 1, false, nil
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, false, fmt.Errorf("suffix range '%s' of %d bytes", header, size)
+		}
+		return max(size-suffix, 0), size - 1, true, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size - 1, false, nil
+	}
+	end = size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, size - 1, false, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return 0, 0, false, fmt.Errorf("range '%s' starts after %d bytes", header, size)
+	}
+	return start, end, true, nil
+}
@@ -0,0 +1,291 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/paragor/sharefile/internal/log"
+)
+
+const (
+	sigV4Algorithm     = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat    = "20060102T150405Z"
+	sigV4MaxClockSkew  = 15 * time.Minute
+	sigV4UnsignedBody  = "UNSIGNED-PAYLOAD"
+	sigV4StreamingBody = "STREAMING-"
+	// streaming payloads are aws-chunked, current aws cli and sdks send unsigned trailer by default
+	sigV4StreamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	sigV4StreamingSigned          = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	sigV4StreamingSignedTrailer   = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+)
+
+type sigV4Authorization struct {
+	AccessKey     string
+	Date          string
+	Region        string
+	Service       string
+	SignedHeaders []string
+	Signature     string
+}
+
+func parseSigV4Authorization(header string) (*sigV4Authorization, error) {
+	if !strings.HasPrefix(header, sigV4Algorithm+" ") {
+		return nil, fmt.Errorf("unsupported authorization algorithm")
+	}
+	auth := &sigV4Authorization{}
+	for _, part := range strings.Split(strings.TrimPrefix(header, sigV4Algorithm+" "), ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid authorization part '%s'", part)
+		}
+		switch key {
+		case "Credential":
+			// access key is an email, so it is the only part which may contain arbitrary characters
+			scope := strings.Split(value, "/")
+			if len(scope) < 5 || scope[len(scope)-1] != "aws4_request" {
+				return nil, fmt.Errorf("invalid credential scope '%s'", value)
+			}
+			auth.AccessKey = strings.Join(scope[:len(scope)-4], "/")
+			auth.Date = scope[len(scope)-4]
+			auth.Region = scope[len(scope)-3]
+			auth.Service = scope[len(scope)-2]
+		case "SignedHeaders":
+			auth.SignedHeaders = strings.Split(value, ";")
+		case "Signature":
+			auth.Signature = value
+		}
+	}
+	if auth.AccessKey == "" || len(auth.SignedHeaders) == 0 || auth.Signature == "" {
+		return nil, fmt.Errorf("incomplete authorization header")
+	}
+	return auth, nil
+}
+
+func (a *sigV4Authorization) scope() string {
+	return strings.Join([]string{a.Date, a.Region, a.Service, "aws4_request"}, "/")
+}
+
+func (a *sigV4Authorization) signature(secretKey string, amzDate string, canonicalRequest string) string {
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		a.scope(),
+		hex.EncodeToString(canonicalRequestHash[:]),
+	}, "\n")
+
+	return hex.EncodeToString(hmacSha256(a.signingKey(secretKey), stringToSign))
+}
+
+func (a *sigV4Authorization) signingKey(secretKey string) []byte {
+	key := hmacSha256([]byte("AWS4"+secretKey), a.Date)
+	key = hmacSha256(key, a.Region)
+	key = hmacSha256(key, a.Service)
+	return hmacSha256(key, "aws4_request")
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sigV4CanonicalRequest(r *http.Request, signedHeaders []string, payloadHash string) string {
+	canonicalHeaders := strings.Builder{}
+	for _, name := range signedHeaders {
+		var values []string
+		switch name {
+		case "host":
+			values = []string{r.Host}
+		case "content-length":
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		default:
+			values = r.Header.Values(name)
+		}
+		for i := range values {
+			values[i] = strings.Join(strings.Fields(values[i]), " ")
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		sigV4CanonicalQuery(r.URL.Query()),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func sigV4CanonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, sigV4Escape(key)+"="+sigV4Escape(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape encodes everything except unreserved characters, as aws does
+func sigV4Escape(value string) string {
+	result := strings.Builder{}
+	for _, c := range []byte(value) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			result.WriteByte(c)
+			continue
+		}
+		result.WriteString(fmt.Sprintf("%%%02X", c))
+	}
+	return result.String()
+}
+
+// S3AuthMiddleware verifies aws signature v4, where access key is email and secret key is api token
+func (s *httpServer) S3AuthMiddleware() mux.MiddlewareFunc {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			auth, err := parseSigV4Authorization(request.Header.Get("Authorization"))
+			if err != nil {
+				s3Error(request.Context(), writer, "AccessDenied", "invalid authorization header", err, http.StatusForbidden)
+				return
+			}
+
+			amzDate := request.Header.Get("X-Amz-Date")
+			signedAt, err := time.Parse(sigV4TimeFormat, amzDate)
+			if err != nil {
+				s3Error(request.Context(), writer, "AccessDenied", "invalid x-amz-date header", err, http.StatusForbidden)
+				return
+			}
+			if skew := time.Since(signedAt); skew > sigV4MaxClockSkew || skew < -sigV4MaxClockSkew {
+				s3Error(request.Context(), writer, "RequestTimeTooSkewed", "request time too skewed", fmt.Errorf("skew is %s", skew), http.StatusForbidden)
+				return
+			}
+
+			payloadHash := request.Header.Get("X-Amz-Content-Sha256")
+			if payloadHash == "" {
+				s3Error(request.Context(), writer, "InvalidRequest", "missing x-amz-content-sha256 header", fmt.Errorf("no payload hash"), http.StatusBadRequest)
+				return
+			}
+			streaming := payloadHash == sigV4StreamingUnsignedTrailer || payloadHash == sigV4StreamingSigned || payloadHash == sigV4StreamingSignedTrailer
+			if strings.HasPrefix(payloadHash, sigV4StreamingBody) && !streaming {
+				s3Error(request.Context(), writer, "NotImplemented", "streaming payload is not supported", fmt.Errorf("payload is %s", payloadHash), http.StatusNotImplemented)
+				return
+			}
+
+			// throttle is checked before storage, so guesses do not cost s3 reads
+			clientIp, target := s.clientIp(request), "token:"+auth.AccessKey
+			if wait := s.throttle.retryAfter(clientIp, target); wait > 0 {
+				writer.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+				s3Error(request.Context(), writer, "SlowDown", "too many failed requests", &throttledError{retryAfter: wait}, http.StatusServiceUnavailable)
+				return
+			}
+			userStorage, err := s.storage.OpenStorage(request.Context(), auth.AccessKey, false)
+			if err != nil {
+				s.throttle.fail(clientIp, target)
+				s3Error(request.Context(), writer, "InvalidAccessKeyId", "unknown access key", err, http.StatusForbidden)
+				return
+			}
+			meta, err := userStorage.GetMetadata(request.Context())
+			if err != nil {
+				s3Error(request.Context(), writer, "InternalError", "unable to fetch metadata", err, http.StatusInternalServerError)
+				return
+			}
+			expected := auth.signature(meta.ApiToken, amzDate, sigV4CanonicalRequest(request, auth.SignedHeaders, payloadHash))
+			if subtle.ConstantTimeCompare([]byte(expected), []byte(auth.Signature)) != 1 {
+				s.throttle.fail(clientIp, target)
+				s3Error(request.Context(), writer, "SignatureDoesNotMatch", "signature does not match", fmt.Errorf("signature mismatch for '%s'", auth.AccessKey), http.StatusForbidden)
+				return
+			}
+			switch {
+			case request.Body == nil || payloadHash == sigV4UnsignedBody:
+			case streaming:
+				var signer *sigV4ChunkSigner
+				if payloadHash != sigV4StreamingUnsignedTrailer {
+					signer = &sigV4ChunkSigner{key: auth.signingKey(meta.ApiToken), amzDate: amzDate, scope: auth.scope(), previous: auth.Signature}
+				}
+				body, err := newAwsChunkedReader(request, signer)
+				if err != nil {
+					s3Error(request.Context(), writer, "InvalidArgument", "invalid aws-chunked headers", err, http.StatusBadRequest)
+					return
+				}
+				request.Body = body
+				request.ContentLength = body.decodedLength
+			default:
+				request.Body = &sha256VerifyingReader{body: request.Body, hash: sha256.New(), expected: payloadHash}
+			}
+
+			authCtx := &authContext{
+				Email:  meta.Email,
+				Groups: meta.Groups,
+				Policy: s.authConfig.policyFor(meta.Groups),
+			}
+
+			ctx := request.Context()
+			ctx = context.WithValue(ctx, authContextKeyValue, authCtx)
+			logger := log.FromContext(ctx).With(
+				slog.String("user", authCtx.Email),
+			)
+			logger.Debug("s3 auth middleware is successfully passed")
+			ctx = log.PutIntoContext(ctx, logger)
+			handler.ServeHTTP(writer, request.WithContext(ctx))
+		})
+	}
+}
+
+// s3PayloadError is failure caused by client payload, body readers remember it, because storage does not keep wrapped errors
+type s3PayloadError struct {
+	code    string
+	message string
+	status  int
+}
+
+func (e *s3PayloadError) Error() string {
+	return e.message
+}
+
+// s3PayloadReader is request body, which verifies payload while it is read
+type s3PayloadReader interface {
+	PayloadError() *s3PayloadError
+}
+
+// sha256VerifyingReader fails on EOF if body does not match signed payload hash, so storage aborts the upload
+type sha256VerifyingReader struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	expected string
+	err      *s3PayloadError
+}
+
+func (r *sha256VerifyingReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(r.hash.Sum(nil)) != r.expected {
+		r.err = &s3PayloadError{code: "XAmzContentSHA256Mismatch", message: "payload does not match x-amz-content-sha256", status: http.StatusBadRequest}
+		return n, r.err
+	}
+	return n, err
+}
+
+func (r *sha256VerifyingReader) PayloadError() *s3PayloadError {
+	return r.err
+}
+
+func (r *sha256VerifyingReader) Close() error {
+	return r.body.Close()
+}
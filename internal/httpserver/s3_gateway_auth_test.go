@@ -0,0 +1,171 @@
+package httpserver
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+func signS3Request(t *testing.T, request *http.Request, body string, secret string, signedAt time.Time) {
+	t.Helper()
+	signer := v4.NewSigner(credentials.NewStaticCredentials("user@example.com", secret, ""))
+	if _, err := signer.Sign(request, strings.NewReader(body), "s3", "us-east-1", signedAt); err != nil {
+		t.Fatalf("cant sign request: %s", err)
+	}
+}
+
+func TestS3AuthMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare signs request, it may break it after signing
+		prepare  func(t *testing.T, request *http.Request)
+		method   string
+		target   string
+		body     string
+		wantCode int
+		wantS3   string
+	}{
+		{
+			name:     "valid list",
+			method:   http.MethodGet,
+			target:   "/s3/files?list-type=2",
+			prepare:  func(t *testing.T, r *http.Request) { signS3Request(t, r, "", "token", time.Now()) },
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "valid put",
+			method:   http.MethodPut,
+			target:   "/s3/files/file.txt",
+			body:     "hello",
+			prepare:  func(t *testing.T, r *http.Request) { signS3Request(t, r, "hello", "token", time.Now()) },
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "wrong secret",
+			method:   http.MethodGet,
+			target:   "/s3/files",
+			prepare:  func(t *testing.T, r *http.Request) { signS3Request(t, r, "", "wrong", time.Now()) },
+			wantCode: http.StatusForbidden,
+			wantS3:   "SignatureDoesNotMatch",
+		},
+		{
+			name:     "skewed time",
+			method:   http.MethodGet,
+			target:   "/s3/files",
+			prepare:  func(t *testing.T, r *http.Request) { signS3Request(t, r, "", "token", time.Now().Add(-time.Hour)) },
+			wantCode: http.StatusForbidden,
+			wantS3:   "RequestTimeTooSkewed",
+		},
+		{
+			name:   "tampered query",
+			method: http.MethodGet,
+			target: "/s3/files?prefix=a",
+			prepare: func(t *testing.T, r *http.Request) {
+				signS3Request(t, r, "", "token", time.Now())
+				r.URL.RawQuery = "prefix=b"
+			},
+			wantCode: http.StatusForbidden,
+			wantS3:   "SignatureDoesNotMatch",
+		},
+		{
+			name:     "no authorization",
+			method:   http.MethodGet,
+			target:   "/s3/files",
+			prepare:  func(t *testing.T, r *http.Request) {},
+			wantCode: http.StatusForbidden,
+			wantS3:   "AccessDenied",
+		},
+		{
+			name:   "unknown access key",
+			method: http.MethodGet,
+			target: "/s3/files",
+			prepare: func(t *testing.T, r *http.Request) {
+				signS3Request(t, r, "", "token", time.Now())
+				r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), "user@example.com", "other@example.com", 1))
+			},
+			wantCode: http.StatusForbidden,
+			wantS3:   "InvalidAccessKeyId",
+		},
+		{
+			name:   "payload mismatch",
+			method: http.MethodPut,
+			target: "/s3/files/file.txt",
+			body:   "tampered",
+			prepare: func(t *testing.T, r *http.Request) {
+				signS3Request(t, r, "hello", "token", time.Now())
+				r.Body = httptest.NewRequest(http.MethodPut, "/", strings.NewReader("tampered")).Body
+				r.ContentLength = int64(len("tampered"))
+			},
+			wantCode: http.StatusBadRequest,
+			wantS3:   "XAmzContentSHA256Mismatch",
+		},
+		{
+			name:     "key with slash",
+			method:   http.MethodPut,
+			target:   "/s3/files/dir/file.txt",
+			body:     "hello",
+			prepare:  func(t *testing.T, r *http.Request) { signS3Request(t, r, "hello", "token", time.Now()) },
+			wantCode: http.StatusBadRequest,
+			wantS3:   "InvalidArgument",
+		},
+		{
+			name:   "sigv4a streaming payload",
+			method: http.MethodPut,
+			target: "/s3/files/file.txt",
+			body:   "hello",
+			prepare: func(t *testing.T, r *http.Request) {
+				r.Header.Set("X-Amz-Content-Sha256", "STREAMING-AWS4-ECDSA-P256-SHA256-PAYLOAD")
+				signS3Request(t, r, "hello", "token", time.Now())
+			},
+			wantCode: http.StatusNotImplemented,
+			wantS3:   "NotImplemented",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeStorage := newFakeStorage()
+			fakeStorage.addUser("user@example.com", nil)
+			s := newTestServer(t, fakeStorage, nil)
+			request := httptest.NewRequest(tt.method, "http://sharefile.local"+tt.target, strings.NewReader(tt.body))
+			tt.prepare(t, request)
+
+			response, body := serve(s, request)
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, tt.wantCode, body)
+			}
+			if tt.wantS3 == "" {
+				return
+			}
+			s3Err := &s3ErrorResponse{}
+			if err := xml.Unmarshal([]byte(body), s3Err); err != nil {
+				t.Fatalf("cant unmarshal s3 error %q: %s", body, err)
+			}
+			if s3Err.Code != tt.wantS3 {
+				t.Fatalf("s3 code %s, want %s", s3Err.Code, tt.wantS3)
+			}
+		})
+	}
+}
+
+func TestSigV4Escape(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "abcXYZ019-_.~", want: "abcXYZ019-_.~"},
+		{value: "a b", want: "a%20b"},
+		{value: "a/b+c=d", want: "a%2Fb%2Bc%3Dd"},
+		{value: "ü", want: "%C3%BC"},
+	}
+	for _, tt := range tests {
+		if got := sigV4Escape(tt.value); got != tt.want {
+			t.Errorf("sigV4Escape(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
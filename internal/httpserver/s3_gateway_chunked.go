@@ -0,0 +1,265 @@
+package httpserver
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	sigV4ChunkAlgorithm   = "AWS4-HMAC-SHA256-PAYLOAD"
+	sigV4TrailerAlgorithm = "AWS4-HMAC-SHA256-TRAILER"
+	sigV4EmptyHash        = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	awsChunkMaxSize       = 16 << 20
+)
+
+// awsChecksums are trailer checksums, which are verified, values are base64 of big endian digest
+var awsChecksums = map[string]func() hash.Hash{
+	"x-amz-checksum-crc32":     func() hash.Hash { return crc32.NewIEEE() },
+	"x-amz-checksum-crc32c":    func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"x-amz-checksum-crc64nvme": func() hash.Hash { return crc64.New(crc64.MakeTable(0x9a6c9329ac4bc9b5)) },
+	"x-amz-checksum-sha1":      sha1.New,
+	"x-amz-checksum-sha256":    sha256.New,
+}
+
+// sigV4ChunkSigner verifies chain of chunk signatures, which starts from the request signature
+type sigV4ChunkSigner struct {
+	key      []byte
+	amzDate  string
+	scope    string
+	previous string
+}
+
+func (s *sigV4ChunkSigner) sign(algorithm string, hashes ...string) string {
+	stringToSign := strings.Join(append([]string{algorithm, s.amzDate, s.scope, s.previous}, hashes...), "\n")
+	return hex.EncodeToString(hmacSha256(s.key, stringToSign))
+}
+
+func (s *sigV4ChunkSigner) verify(signature string, algorithm string, hashes ...string) bool {
+	if subtle.ConstantTimeCompare([]byte(s.sign(algorithm, hashes...)), []byte(signature)) != 1 {
+		return false
+	}
+	s.previous = signature
+	return true
+}
+
+// awsChunkedReader decodes aws-chunked body, it verifies chunk signatures if signer is set and trailer checksums
+type awsChunkedReader struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	signer *sigV4ChunkSigner
+	// decodedLength is -1 if client did not send x-amz-decoded-content-length
+	decodedLength int64
+	decoded       int64
+	checksums     map[string]hash.Hash
+	// remaining is unread size of current chunk
+	remaining      int64
+	chunkSignature string
+	chunkHash      hash.Hash
+	done           bool
+	err            *s3PayloadError
+}
+
+func newAwsChunkedReader(r *http.Request, signer *sigV4ChunkSigner) (*awsChunkedReader, error) {
+	result := &awsChunkedReader{
+		body:          r.Body,
+		reader:        bufio.NewReader(r.Body),
+		signer:        signer,
+		decodedLength: -1,
+		checksums:     map[string]hash.Hash{},
+		chunkHash:     sha256.New(),
+	}
+	if value := r.Header.Get("X-Amz-Decoded-Content-Length"); value != "" {
+		length, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("invalid x-amz-decoded-content-length '%s'", value)
+		}
+		result.decodedLength = length
+	}
+	for _, name := range strings.Split(r.Header.Get("X-Amz-Trailer"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if newHash, ok := awsChecksums[name]; ok {
+			result.checksums[name] = newHash()
+		}
+	}
+	return result, nil
+}
+
+func (r *awsChunkedReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.done {
+		return 0, io.EOF
+	}
+	if r.remaining == 0 {
+		if err := r.nextChunk(); err != nil {
+			return 0, err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+	}
+
+	n, err := r.reader.Read(p[:min(int64(len(p)), r.remaining)])
+	r.remaining -= int64(n)
+	r.decoded += int64(n)
+	r.chunkHash.Write(p[:n])
+	for _, checksum := range r.checksums {
+		checksum.Write(p[:n])
+	}
+	if errors.Is(err, io.EOF) {
+		return n, r.fail("IncompleteBody", "body ends inside of chunk")
+	}
+	if err != nil {
+		return n, err
+	}
+	if r.remaining == 0 {
+		if err := r.endChunk(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (r *awsChunkedReader) nextChunk() error {
+	line, err := r.readLine()
+	if err != nil {
+		return err
+	}
+	sizeValue, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeValue, 16, 64)
+	if err != nil || size < 0 || size > awsChunkMaxSize {
+		return r.fail("IncompleteBody", "invalid chunk size")
+	}
+	r.chunkSignature = ""
+	if r.signer != nil {
+		signature, ok := strings.CutPrefix(extension, "chunk-signature=")
+		if !ok {
+			return r.fail("SignatureDoesNotMatch", "chunk signature is missing")
+		}
+		r.chunkSignature = signature
+	}
+	r.chunkHash.Reset()
+	r.remaining = size
+	if size > 0 {
+		return nil
+	}
+
+	if err := r.verifyChunk(); err != nil {
+		return err
+	}
+	if err := r.readTrailer(); err != nil {
+		return err
+	}
+	if r.decodedLength >= 0 && r.decoded != r.decodedLength {
+		return r.fail("IncompleteBody", "body does not match x-amz-decoded-content-length")
+	}
+	r.done = true
+	return nil
+}
+
+func (r *awsChunkedReader) endChunk() error {
+	line, err := r.readLine()
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return r.fail("IncompleteBody", "chunk is longer than its size")
+	}
+	return r.verifyChunk()
+}
+
+func (r *awsChunkedReader) verifyChunk() error {
+	if r.signer == nil {
+		return nil
+	}
+	if !r.signer.verify(r.chunkSignature, sigV4ChunkAlgorithm, sigV4EmptyHash, hex.EncodeToString(r.chunkHash.Sum(nil))) {
+		return r.fail("SignatureDoesNotMatch", "chunk signature does not match")
+	}
+	return nil
+}
+
+// readTrailer reads trailing headers after the last chunk, signed trailer ends with x-amz-trailer-signature
+func (r *awsChunkedReader) readTrailer() error {
+	trailer := strings.Builder{}
+	values := map[string]string{}
+	signature := ""
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return r.fail("IncompleteBody", "invalid trailer")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "x-amz-trailer-signature" {
+			signature = strings.TrimSpace(value)
+			continue
+		}
+		values[name] = strings.TrimSpace(value)
+		trailer.WriteString(name + ":" + values[name] + "\n")
+	}
+
+	if r.signer != nil && trailer.Len() > 0 {
+		trailerHash := sha256.Sum256([]byte(trailer.String()))
+		if !r.signer.verify(signature, sigV4TrailerAlgorithm, hex.EncodeToString(trailerHash[:])) {
+			return r.fail("SignatureDoesNotMatch", "trailer signature does not match")
+		}
+	}
+	for name, checksum := range r.checksums {
+		if values[name] != base64.StdEncoding.EncodeToString(checksum.Sum(nil)) {
+			return r.fail("BadDigest", fmt.Sprintf("payload does not match %s", name))
+		}
+	}
+	return nil
+}
+
+// readLine reads line without CRLF, body must not end before the last chunk and trailer
+func (r *awsChunkedReader) readLine() (string, error) {
+	line, err := r.reader.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", r.fail("IncompleteBody", "chunk header is too long")
+	}
+	if errors.Is(err, io.EOF) {
+		return "", r.fail("IncompleteBody", "body ends before the last chunk")
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (r *awsChunkedReader) fail(code string, message string) error {
+	status := http.StatusBadRequest
+	if code == "SignatureDoesNotMatch" {
+		status = http.StatusForbidden
+	}
+	r.err = &s3PayloadError{code: code, message: message, status: status}
+	return r.err
+}
+
+func (r *awsChunkedReader) PayloadError() *s3PayloadError {
+	return r.err
+}
+
+func (r *awsChunkedReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,271 @@
+package httpserver
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSigV4ChunkSigner checks example of aws documentation for streaming upload in multiple chunks
+func TestSigV4ChunkSigner(t *testing.T) {
+	auth := &sigV4Authorization{Date: "20130524", Region: "us-east-1", Service: "s3"}
+	signer := &sigV4ChunkSigner{
+		key:      auth.signingKey("wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"),
+		amzDate:  "20130524T000000Z",
+		scope:    auth.scope(),
+		previous: "4f232c4386841ef735655705268965c44a0e4690baa4adea153f7db9fa80a0a9",
+	}
+	chunks := []struct {
+		size int
+		want string
+	}{
+		{size: 65536, want: "ad80c730a21e5b8d04586a2213dd63b9a0e99e0e2307b0ade35a65485a288648"},
+		{size: 1024, want: "0055627c9e194cb4542bae2aa5492e3c1575bbb81b612b7d234b86a503ef5497"},
+		{size: 0, want: "b6c6ea8a5354eaf15b3cb7646744f4275b71ea724fed81ceb9323e279d449df9"},
+	}
+	for _, chunk := range chunks {
+		chunkHash := sha256.Sum256([]byte(strings.Repeat("a", chunk.size)))
+		if !signer.verify(chunk.want, sigV4ChunkAlgorithm, sigV4EmptyHash, hex.EncodeToString(chunkHash[:])) {
+			t.Fatalf("signature of %d bytes chunk does not match", chunk.size)
+		}
+	}
+}
+
+// awsChunkedBody encodes chunks as aws sdk does, signatures are chained from request signature if signer is set
+func awsChunkedBody(signer *sigV4ChunkSigner, chunks []string, trailer string) string {
+	body := strings.Builder{}
+	for _, chunk := range append(chunks, "") {
+		body.WriteString(strconv.FormatInt(int64(len(chunk)), 16))
+		if signer != nil {
+			chunkHash := sha256.Sum256([]byte(chunk))
+			signer.previous = signer.sign(sigV4ChunkAlgorithm, sigV4EmptyHash, hex.EncodeToString(chunkHash[:]))
+			body.WriteString(";chunk-signature=" + signer.previous)
+		}
+		body.WriteString("\r\n")
+		if chunk != "" {
+			body.WriteString(chunk + "\r\n")
+		}
+	}
+	if trailer != "" {
+		body.WriteString(trailer + "\r\n")
+		if signer != nil {
+			trailerHash := sha256.Sum256([]byte(trailer + "\n"))
+			body.WriteString("x-amz-trailer-signature:" + signer.sign(sigV4TrailerAlgorithm, hex.EncodeToString(trailerHash[:])) + "\r\n")
+		}
+	}
+	body.WriteString("\r\n")
+	return body.String()
+}
+
+func crc32Trailer(data string) string {
+	checksum := crc32.NewIEEE()
+	checksum.Write([]byte(data))
+	return "x-amz-checksum-crc32:" + base64.StdEncoding.EncodeToString(checksum.Sum(nil))
+}
+
+func TestS3PutObjectAwsChunked(t *testing.T) {
+	tests := []struct {
+		name        string
+		payloadHash string
+		// body encodes payload, signer is nil for unsigned payload
+		body          func(signer *sigV4ChunkSigner) string
+		trailer       string
+		decodedLength string
+		wantCode      int
+		wantS3        string
+		wantContent   string
+	}{
+		{
+			name:        "unsigned trailer",
+			payloadHash: sigV4StreamingUnsignedTrailer,
+			body: func(_ *sigV4ChunkSigner) string {
+				return awsChunkedBody(nil, []string{"hello ", "world"}, crc32Trailer("hello world"))
+			},
+			trailer:       "x-amz-checksum-crc32",
+			decodedLength: "11",
+			wantCode:      http.StatusOK,
+			wantContent:   "hello world",
+		},
+		{
+			name:        "unsigned trailer without decoded length",
+			payloadHash: sigV4StreamingUnsignedTrailer,
+			body: func(_ *sigV4ChunkSigner) string {
+				return awsChunkedBody(nil, []string{"hello world"}, crc32Trailer("hello world"))
+			},
+			trailer:     "x-amz-checksum-crc32",
+			wantCode:    http.StatusOK,
+			wantContent: "hello world",
+		},
+		{
+			name:        "unsigned trailer with wrong checksum",
+			payloadHash: sigV4StreamingUnsignedTrailer,
+			body: func(_ *sigV4ChunkSigner) string {
+				return awsChunkedBody(nil, []string{"hello world"}, crc32Trailer("tampered"))
+			},
+			trailer:       "x-amz-checksum-crc32",
+			decodedLength: "11",
+			wantCode:      http.StatusBadRequest,
+			wantS3:        "BadDigest",
+		},
+		{
+			name:          "unsigned trailer without last chunk",
+			payloadHash:   sigV4StreamingUnsignedTrailer,
+			body:          func(_ *sigV4ChunkSigner) string { return "b\r\nhello world\r\n" },
+			trailer:       "x-amz-checksum-crc32",
+			decodedLength: "11",
+			wantCode:      http.StatusBadRequest,
+			wantS3:        "IncompleteBody",
+		},
+		{
+			name:        "unsigned trailer with wrong decoded length",
+			payloadHash: sigV4StreamingUnsignedTrailer,
+			body: func(_ *sigV4ChunkSigner) string {
+				return awsChunkedBody(nil, []string{"hello world"}, crc32Trailer("hello world"))
+			},
+			trailer:       "x-amz-checksum-crc32",
+			decodedLength: "12",
+			wantCode:      http.StatusBadRequest,
+			wantS3:        "IncompleteBody",
+		},
+		{
+			name:          "signed payload",
+			payloadHash:   sigV4StreamingSigned,
+			body:          func(signer *sigV4ChunkSigner) string { return awsChunkedBody(signer, []string{"hello ", "world"}, "") },
+			decodedLength: "11",
+			wantCode:      http.StatusOK,
+			wantContent:   "hello world",
+		},
+		{
+			name:        "signed payload with tampered chunk",
+			payloadHash: sigV4StreamingSigned,
+			body: func(signer *sigV4ChunkSigner) string {
+				return strings.Replace(awsChunkedBody(signer, []string{"hello ", "world"}, ""), "world", "WORLD", 1)
+			},
+			decodedLength: "11",
+			wantCode:      http.StatusForbidden,
+			wantS3:        "SignatureDoesNotMatch",
+		},
+		{
+			name:        "signed payload with wrong seed",
+			payloadHash: sigV4StreamingSigned,
+			body: func(signer *sigV4ChunkSigner) string {
+				signer.previous = sigV4EmptyHash
+				return awsChunkedBody(signer, []string{"hello world"}, "")
+			},
+			decodedLength: "11",
+			wantCode:      http.StatusForbidden,
+			wantS3:        "SignatureDoesNotMatch",
+		},
+		{
+			name:        "signed trailer",
+			payloadHash: sigV4StreamingSignedTrailer,
+			body: func(signer *sigV4ChunkSigner) string {
+				return awsChunkedBody(signer, []string{"hello world"}, crc32Trailer("hello world"))
+			},
+			trailer:       "x-amz-checksum-crc32",
+			decodedLength: "11",
+			wantCode:      http.StatusOK,
+			wantContent:   "hello world",
+		},
+		{
+			name:        "signed trailer with tampered checksum",
+			payloadHash: sigV4StreamingSignedTrailer,
+			body: func(signer *sigV4ChunkSigner) string {
+				body := awsChunkedBody(signer, []string{"hello world"}, crc32Trailer("hello world"))
+				return strings.Replace(body, crc32Trailer("hello world"), crc32Trailer("tampered"), 1)
+			},
+			trailer:       "x-amz-checksum-crc32",
+			decodedLength: "11",
+			wantCode:      http.StatusForbidden,
+			wantS3:        "SignatureDoesNotMatch",
+		},
+		{
+			name:          "ecdsa payload",
+			payloadHash:   "STREAMING-AWS4-ECDSA-P256-SHA256-PAYLOAD",
+			body:          func(_ *sigV4ChunkSigner) string { return awsChunkedBody(nil, []string{"hello world"}, "") },
+			decodedLength: "11",
+			wantCode:      http.StatusNotImplemented,
+			wantS3:        "NotImplemented",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeStorage := newFakeStorage()
+			fakeStorage.addUser("user@example.com", nil)
+			s := newTestServer(t, fakeStorage, nil)
+			request := httptest.NewRequest(http.MethodPut, "http://sharefile.local/s3/files/file.txt", nil)
+			request.Header.Set("X-Amz-Content-Sha256", tt.payloadHash)
+			request.Header.Set("Content-Encoding", "aws-chunked")
+			if tt.trailer != "" {
+				request.Header.Set("X-Amz-Trailer", tt.trailer)
+			}
+			if tt.decodedLength != "" {
+				request.Header.Set("X-Amz-Decoded-Content-Length", tt.decodedLength)
+			}
+			signedAt := time.Now()
+			signS3Request(t, request, "", "token", signedAt)
+			auth, err := parseSigV4Authorization(request.Header.Get("Authorization"))
+			if err != nil {
+				t.Fatalf("cant parse signed request: %s", err)
+			}
+			signer := &sigV4ChunkSigner{
+				key:      auth.signingKey("token"),
+				amzDate:  signedAt.UTC().Format(sigV4TimeFormat),
+				scope:    auth.scope(),
+				previous: auth.Signature,
+			}
+			body := tt.body(signer)
+			request.Body = httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body)).Body
+			request.ContentLength = int64(len(body))
+
+			response, responseBody := serve(s, request)
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, tt.wantCode, responseBody)
+			}
+			content, ok := fakeStorage.file("user@example.com", "file.txt")
+			if tt.wantContent != content {
+				t.Fatalf("content %q (uploaded %t), want %q", content, ok, tt.wantContent)
+			}
+			if tt.wantS3 == "" {
+				return
+			}
+			s3Err := &s3ErrorResponse{}
+			if err := xml.Unmarshal([]byte(responseBody), s3Err); err != nil {
+				t.Fatalf("cant unmarshal s3 error %q: %s", responseBody, err)
+			}
+			if s3Err.Code != tt.wantS3 {
+				t.Fatalf("s3 code %s, want %s", s3Err.Code, tt.wantS3)
+			}
+		})
+	}
+}
+
+func TestS3PutObjectAwsChunkedOverLimit(t *testing.T) {
+	fakeStorage := newFakeStorage()
+	fakeStorage.addUser("user@example.com", nil)
+	s := newTestServer(t, fakeStorage, &AuthConfig{DefaultPolicy: &AuthPolicy{MaxUploadSizeBytes: 5}})
+	request := httptest.NewRequest(http.MethodPut, "http://sharefile.local/s3/files/file.txt", nil)
+	request.Header.Set("X-Amz-Content-Sha256", sigV4StreamingUnsignedTrailer)
+	request.Header.Set("X-Amz-Decoded-Content-Length", "11")
+	signS3Request(t, request, "", "token", time.Now())
+	// encoded body is small, but decoded length is over limit
+	body := awsChunkedBody(nil, []string{"hello world"}, "")
+	request.Body = httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body)).Body
+	request.ContentLength = int64(len(body))
+
+	response, responseBody := serve(s, request)
+	if response.StatusCode != http.StatusBadRequest || !strings.Contains(responseBody, "EntityTooLarge") {
+		t.Fatalf("status %d, want 400 EntityTooLarge: %s", response.StatusCode, responseBody)
+	}
+	if _, ok := fakeStorage.file("user@example.com", "file.txt"); ok {
+		t.Fatalf("file is uploaded over limit")
+	}
+}
@@ -0,0 +1,63 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestS3GetObject(t *testing.T) {
+	tests := []struct {
+		name             string
+		method           string
+		rangeHeader      string
+		wantCode         int
+		wantBody         string
+		wantContentRange string
+		wantLength       string
+	}{
+		{name: "full", method: http.MethodGet, wantCode: http.StatusOK, wantBody: "hello world", wantLength: "11"},
+		{name: "head", method: http.MethodHead, wantCode: http.StatusOK, wantLength: "11"},
+		{name: "range", method: http.MethodGet, rangeHeader: "bytes=0-4", wantCode: http.StatusPartialContent, wantBody: "hello", wantContentRange: "bytes 0-4/11", wantLength: "5"},
+		{name: "open range", method: http.MethodGet, rangeHeader: "bytes=6-", wantCode: http.StatusPartialContent, wantBody: "world", wantContentRange: "bytes 6-10/11", wantLength: "5"},
+		{name: "suffix range", method: http.MethodGet, rangeHeader: "bytes=-3", wantCode: http.StatusPartialContent, wantBody: "rld", wantContentRange: "bytes 8-10/11", wantLength: "3"},
+		{name: "range over end", method: http.MethodGet, rangeHeader: "bytes=6-100", wantCode: http.StatusPartialContent, wantBody: "world", wantContentRange: "bytes 6-10/11", wantLength: "5"},
+		{name: "unsatisfiable range", method: http.MethodGet, rangeHeader: "bytes=11-", wantCode: http.StatusRequestedRangeNotSatisfiable, wantContentRange: "bytes */11"},
+		{name: "multiple ranges are ignored", method: http.MethodGet, rangeHeader: "bytes=0-1,3-4", wantCode: http.StatusOK, wantBody: "hello world", wantLength: "11"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeStorage := newFakeStorage()
+			user := fakeStorage.addUser("user@example.com", map[string]string{"file.txt": "hello world"})
+			// stored content type wins over extension
+			user.files["file.txt"].contentType = "application/x-custom"
+			s := newTestServer(t, fakeStorage, nil)
+			request := httptest.NewRequest(tt.method, "http://sharefile.local/s3/files/file.txt", nil)
+			if tt.rangeHeader != "" {
+				request.Header.Set("Range", tt.rangeHeader)
+			}
+			signS3Request(t, request, "", "token", time.Now())
+
+			response, body := serve(s, request)
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, tt.wantCode, body)
+			}
+			if got := response.Header.Get("Content-Range"); got != tt.wantContentRange {
+				t.Fatalf("content range %q, want %q", got, tt.wantContentRange)
+			}
+			if tt.wantCode == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if body != tt.wantBody {
+				t.Fatalf("body %q, want %q", body, tt.wantBody)
+			}
+			if got := response.Header.Get("Content-Length"); got != tt.wantLength {
+				t.Fatalf("content length %q, want %q", got, tt.wantLength)
+			}
+			if got := response.Header.Get("Content-Type"); got != "application/x-custom" {
+				t.Fatalf("content type %q, want stored one", got)
+			}
+		})
+	}
+}
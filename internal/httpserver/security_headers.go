@@ -0,0 +1,71 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cspNoncePlaceholder in ContentSecurityPolicy is replaced by random nonce of every response
+const cspNoncePlaceholder = "{nonce}"
+
+// SecurityHeadersConfig empty values disable corresponding header
+type SecurityHeadersConfig struct {
+	ContentSecurityPolicy string
+	// HstsMaxAge is sent only if server public url is https
+	HstsMaxAge        time.Duration
+	FrameOptions      string
+	ReferrerPolicy    string
+	PermissionsPolicy string
+}
+
+type cspNonceContextKey struct{}
+
+var cspNonceContextKeyValue = cspNonceContextKey{}
+
+// cspNonce returns nonce allowed by content security policy of current response, empty if csp is disabled
+func cspNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceContextKeyValue).(string)
+	return nonce
+}
+
+func securityHeadersMiddleware(cfg *SecurityHeadersConfig, serverPublicUrl string) func(http.Handler) http.Handler {
+	hsts := ""
+	if cfg.HstsMaxAge > 0 && strings.HasPrefix(serverPublicUrl, "https://") {
+		hsts = "max-age=" + strconv.FormatInt(int64(cfg.HstsMaxAge.Seconds()), 10) + "; includeSubDomains"
+	}
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			headers := writer.Header()
+			headers.Set("X-Content-Type-Options", "nosniff")
+			if cfg.ContentSecurityPolicy != "" {
+				policy := cfg.ContentSecurityPolicy
+				if strings.Contains(policy, cspNoncePlaceholder) {
+					nonce := make([]byte, 16)
+					_, _ = rand.Read(nonce)
+					encodedNonce := base64.StdEncoding.EncodeToString(nonce)
+					policy = strings.ReplaceAll(policy, cspNoncePlaceholder, encodedNonce)
+					request = request.WithContext(context.WithValue(request.Context(), cspNonceContextKeyValue, encodedNonce))
+				}
+				headers.Set("Content-Security-Policy", policy)
+			}
+			if hsts != "" {
+				headers.Set("Strict-Transport-Security", hsts)
+			}
+			if cfg.FrameOptions != "" {
+				headers.Set("X-Frame-Options", cfg.FrameOptions)
+			}
+			if cfg.ReferrerPolicy != "" {
+				headers.Set("Referrer-Policy", cfg.ReferrerPolicy)
+			}
+			if cfg.PermissionsPolicy != "" {
+				headers.Set("Permissions-Policy", cfg.PermissionsPolicy)
+			}
+			handler.ServeHTTP(writer, request)
+		})
+	}
+}
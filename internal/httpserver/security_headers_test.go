@@ -0,0 +1,126 @@
+package httpserver
+
+import (
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testContentSecurityPolicy = "default-src 'self'; script-src 'self' 'nonce-{nonce}'"
+
+var cspNonceRegexp = regexp.MustCompile(`'nonce-([^']+)'`)
+
+func newSecurityHeadersTestHandler(t *testing.T, cfg *SecurityHeadersConfig, serverPublicUrl string) http.Handler {
+	t.Helper()
+	s := newTestServer(t, newFakeStorage(), nil)
+	return securityHeadersMiddleware(cfg, serverPublicUrl)(s.mux)
+}
+
+func serveHandler(handler http.Handler, request *http.Request) (*http.Response, string) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	response := recorder.Result()
+	body, _ := io.ReadAll(response.Body)
+	return response, string(body)
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name            string
+		cfg             *SecurityHeadersConfig
+		serverPublicUrl string
+		want            map[string]string
+	}{
+		{
+			name: "all headers",
+			cfg: &SecurityHeadersConfig{
+				ContentSecurityPolicy: "default-src 'self'",
+				HstsMaxAge:            time.Hour,
+				FrameOptions:          "DENY",
+				ReferrerPolicy:        "no-referrer",
+				PermissionsPolicy:     "camera=()",
+			},
+			serverPublicUrl: "https://sharefile.local",
+			want: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"Content-Security-Policy":   "default-src 'self'",
+				"Strict-Transport-Security": "max-age=3600; includeSubDomains",
+				"X-Frame-Options":           "DENY",
+				"Referrer-Policy":           "no-referrer",
+				"Permissions-Policy":        "camera=()",
+			},
+		},
+		{
+			name:            "hsts is not sent over http",
+			cfg:             &SecurityHeadersConfig{HstsMaxAge: time.Hour},
+			serverPublicUrl: "http://sharefile.local",
+			want:            map[string]string{"X-Content-Type-Options": "nosniff", "Strict-Transport-Security": ""},
+		},
+		{
+			name:            "empty values disable headers",
+			cfg:             &SecurityHeadersConfig{},
+			serverPublicUrl: "https://sharefile.local",
+			want: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"Content-Security-Policy":   "",
+				"Strict-Transport-Security": "",
+				"X-Frame-Options":           "",
+				"Referrer-Policy":           "",
+				"Permissions-Policy":        "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newSecurityHeadersTestHandler(t, tt.cfg, tt.serverPublicUrl)
+			for _, path := range []string{"/", "/static/main.js", "/share/unknown"} {
+				response, _ := serveHandler(handler, httptest.NewRequest(http.MethodGet, path, nil))
+				for name, want := range tt.want {
+					if got := response.Header.Get(name); got != want {
+						t.Fatalf("%s: header %s is %q, want %q", path, name, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCspNonce(t *testing.T) {
+	handler := newSecurityHeadersTestHandler(t, &SecurityHeadersConfig{ContentSecurityPolicy: testContentSecurityPolicy}, "http://sharefile.local")
+	nonces := map[string]bool{}
+	for range 3 {
+		response, body := serveHandler(handler, httptest.NewRequest(http.MethodGet, "/", nil))
+		match := cspNonceRegexp.FindStringSubmatch(response.Header.Get("Content-Security-Policy"))
+		if match == nil || strings.Contains(match[1], cspNoncePlaceholder) {
+			t.Fatalf("csp %q has no nonce", response.Header.Get("Content-Security-Policy"))
+		}
+		nonce := match[1]
+		if nonces[nonce] {
+			t.Fatalf("nonce %s is reused", nonce)
+		}
+		nonces[nonce] = true
+
+		// template escapes base64 in attributes, browser unescapes it before comparing with header
+		body = html.UnescapeString(body)
+		scripts := strings.Count(body, "<script ")
+		if scripts == 0 || strings.Count(body, `nonce="`+nonce+`"`) != scripts {
+			t.Fatalf("%d scripts of page, want all of them with nonce %s of header: %s", scripts, nonce, body)
+		}
+		if !strings.Contains(body, `"inlineScriptNonce": "`+nonce+`"`) {
+			t.Fatalf("htmx config does not have nonce %s of header", nonce)
+		}
+	}
+}
+
+func TestCspWithoutNonce(t *testing.T) {
+	handler := newSecurityHeadersTestHandler(t, &SecurityHeadersConfig{ContentSecurityPolicy: "default-src 'self'"}, "http://sharefile.local")
+	_, body := serveHandler(handler, httptest.NewRequest(http.MethodGet, "/", nil))
+	if strings.Count(body, `nonce="`) != strings.Count(body, `nonce=""`) {
+		t.Fatalf("page has nonce, which is not allowed by csp: %s", body)
+	}
+}
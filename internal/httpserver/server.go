@@ -6,16 +6,21 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"path"
 	"strconv"
 	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/paragor/sharefile/internal/audit"
 	"github.com/paragor/sharefile/internal/httpserver/public"
 	"github.com/paragor/sharefile/internal/log"
+	"github.com/paragor/sharefile/internal/sessions"
 	"github.com/paragor/sharefile/internal/storage"
+	"github.com/paragor/sharefile/internal/webhooks"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	httphelper "github.com/zitadel/oidc/v3/pkg/http"
 )
 
 type Server interface {
@@ -26,8 +31,27 @@ type Server interface {
 type httpServer struct {
 	rssExpirationLink time.Duration
 	storage           storage.Storage
-	oidc              *authOidcContext
+	oidc              []*authOidcContext
+	cookieHandler     *httphelper.CookieHandler
+	returnTo          *returnToSigner
+	authConfig        *AuthConfig
+	sessions          sessions.Store
+	trustedProxies    []netip.Prefix
 	serverPublicUrl   string
+	webdavLocks       *webdavLockSystems
+	// legacyShareUrls keeps /share/<email>/<secret> and /rss/<email>/<secret> working
+	legacyShareUrls bool
+	// throttle slows down guessing of share links, local passwords and api tokens
+	throttle *guessThrottle
+	// userStates caches checks of AuthMiddleware, so every request does not read metadata
+	userStates *userStates
+	// auditSink is nil if audit is disabled
+	auditSink audit.Sink
+	// webhooks is nil if webhooks are disabled
+	webhooks *webhooks.Dispatcher
+	// shareAccessWebhooks limits share access events per visitor
+	shareAccessWebhooks *webhookLimiter
+	readiness           *readinessChecker
 
 	mux    *mux.Router
 	server *http.Server
@@ -35,6 +59,10 @@ type httpServer struct {
 
 func httpError(ctx context.Context, w http.ResponseWriter, publicMsg string, err error, code int) {
 	log.FromContext(ctx).With(log.Error(err), slog.Int("response_code", code)).Error(publicMsg)
+	// users quote request id in support tickets, it finds log lines of request
+	if id := requestId(ctx); id != "" {
+		publicMsg += " (request id: " + id + ")"
+	}
 	http.Error(w, publicMsg, code)
 }
 
@@ -69,36 +97,83 @@ func (s *httpServer) Shutdown(ctx context.Context) error {
 	return s.server.Shutdown(ctx)
 }
 
-func NewHttpServer(
-	listen string,
-	storage storage.Storage,
-	authConfig *AuthOidcConfig,
-	serverPublicUrl string,
-	diagnosticEndpointsEnabled bool,
-	rssExpirationLink time.Duration,
-) (Server, error) {
-	if rssExpirationLink <= 0 {
+// Config of http server, nil SecurityHeaders, AuditSink and Webhooks disable these features
+type Config struct {
+	Listen    string
+	Storage   storage.Storage
+	Auth      *AuthConfig
+	PublicUrl string
+	// DiagnosticEndpointsEnabled serves /metrics, /healthz and /readyz
+	DiagnosticEndpointsEnabled bool
+	RssExpirationLink          time.Duration
+	// TrustedProxies may set X-Forwarded-For and auth proxy headers
+	TrustedProxies  []netip.Prefix
+	Sessions        sessions.Store
+	SecurityHeaders *SecurityHeadersConfig
+	// LegacyShareUrls keeps /share/<email>/<secret> and /rss/<email>/<secret> working
+	LegacyShareUrls bool
+	RateLimit       *RateLimitConfig
+	AuditSink       audit.Sink
+	Webhooks        *webhooks.Dispatcher
+	Readiness       *ReadinessConfig
+}
+
+func NewHttpServer(cfg Config) (Server, error) {
+	if cfg.RssExpirationLink <= 0 {
 		return nil, fmt.Errorf("rss expiration link should be > 0")
 	}
-	oidc, err := newOidcContext(authConfig, serverPublicUrl+"/oidc/callback", "/")
-	if err != nil {
-		return nil, fmt.Errorf("cant init oidc: %w", err)
+	if cfg.Readiness == nil || cfg.Readiness.Timeout <= 0 {
+		return nil, fmt.Errorf("readiness timeout should be > 0")
+	}
+	cookieHandler := httphelper.NewCookieHandler([]byte(cfg.Auth.CookieKey), []byte(cfg.Auth.CookieKey))
+	returnTo := newReturnToSigner(cfg.Auth.CookieKey)
+	oidcProviders := make([]*authOidcContext, 0, len(cfg.Auth.Oidc))
+	for _, providerConfig := range cfg.Auth.Oidc {
+		oidc, err := newOidcContext(providerConfig, cookieHandler, cfg.Sessions, cfg.Auth.SessionTTL, cfg.PublicUrl, "/", returnTo)
+		if err != nil {
+			return nil, fmt.Errorf("cant init oidc provider '%s': %w", providerConfig.Name, err)
+		}
+		oidcProviders = append(oidcProviders, oidc)
+	}
+	if cfg.RateLimit == nil {
+		cfg.RateLimit = &RateLimitConfig{}
+	}
+	if err := registerSessionsCollector(cfg.Sessions); err != nil {
+		return nil, fmt.Errorf("cant register sessions metrics: %w", err)
 	}
 	router := mux.NewRouter()
 	srv := &http.Server{
-		Addr:    listen,
+		Addr:    cfg.Listen,
 		Handler: router,
 	}
 	server := &httpServer{
-		server:            srv,
-		mux:               router,
-		storage:           storage,
-		oidc:              oidc,
-		serverPublicUrl:   serverPublicUrl,
-		rssExpirationLink: rssExpirationLink,
+		server:              srv,
+		mux:                 router,
+		storage:             cfg.Storage,
+		oidc:                oidcProviders,
+		cookieHandler:       cookieHandler,
+		returnTo:            returnTo,
+		authConfig:          cfg.Auth,
+		sessions:            cfg.Sessions,
+		trustedProxies:      cfg.TrustedProxies,
+		serverPublicUrl:     cfg.PublicUrl,
+		rssExpirationLink:   cfg.RssExpirationLink,
+		webdavLocks:         &webdavLockSystems{},
+		legacyShareUrls:     cfg.LegacyShareUrls,
+		throttle:            newGuessThrottle(len(cfg.TrustedProxies) > 0),
+		userStates:          newUserStates(),
+		auditSink:           cfg.AuditSink,
+		webhooks:            cfg.Webhooks,
+		shareAccessWebhooks: newWebhookLimiter(shareAccessWebhookInterval),
 	}
+	for _, oidc := range oidcProviders {
+		oidc.audit = server.audit
+	}
+	server.readiness = newReadinessChecker(cfg.Readiness, server.readinessChecks())
 
 	server.mux.Use(
+		// request id goes first, so panics are logged with it
+		requestIdMiddleware,
 		func(handler http.Handler) http.Handler {
 			return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 				defer func() {
@@ -113,10 +188,14 @@ func NewHttpServer(
 				handler.ServeHTTP(writer, request)
 			})
 		},
+		tracingMiddleware,
 		logsMiddleware,
 		handlers.CompressHandler,
 	)
-	server.mux.Name("static").PathPrefix("/static/").Handler(
+	if cfg.SecurityHeaders != nil {
+		server.mux.Use(securityHeadersMiddleware(cfg.SecurityHeaders, cfg.PublicUrl))
+	}
+	server.mux.Name("static").PathPrefix("/static/").Handler(MetricsMiddleware("static")(
 		restartEtag(
 			cacheMiddleware(
 				http.FileServer(
@@ -127,33 +206,80 @@ func NewHttpServer(
 				5*time.Minute,
 			),
 		),
-	)
+	))
 
-	if diagnosticEndpointsEnabled {
+	if cfg.DiagnosticEndpointsEnabled {
 		diags := server.mux.Name("diags").Subrouter()
+		diags.Use(MetricsMiddleware("diags"))
 		diags.Path("/metrics").Handler(promhttp.Handler())
 		diags.Path("/healthz").HandlerFunc(server.apiPing)
-		diags.Path("/readyz").HandlerFunc(server.apiPing)
+		diags.Path("/readyz").HandlerFunc(server.apiReady)
 	}
 
 	pub := server.mux.Name("public").Subrouter()
+	pub.Use(MetricsMiddleware("public"), server.RateLimitMiddleware("public", cfg.RateLimit.Public))
 	pub.PathPrefix("/rss/").Methods(http.MethodGet).HandlerFunc(server.generateRSS)
 	pub.PathPrefix("/share/").Methods(http.MethodGet).HandlerFunc(server.htmxPageShare)
 	pub.Path("/login").HandlerFunc(server.htmxPageLogin)
-	pub.Path("/oidc/callback").Handler(server.oidc.AuthCallbackHandler())
-	pub.Path("/oidc/login").Handler(server.oidc.AuthLoginHandler())
+	for _, oidc := range server.oidc {
+		pub.Path(oidc.callbackPath).Handler(oidc.AuthCallbackHandler())
+		pub.Path(oidc.loginPath).Handler(oidc.AuthLoginHandler())
+		pub.Path(oidc.backChannelLogoutPath).Methods(http.MethodPost).Handler(oidc.BackChannelLogoutHandler())
+	}
+	if cfg.Auth.Local != nil {
+		pub.Path("/local/login").Methods(http.MethodPost).HandlerFunc(server.apiLocalLogin)
+	}
 
 	htmx := server.mux.Name("htmx").Subrouter()
-	htmx.Use(server.AuthMiddleware())
+	htmx.Use(MetricsMiddleware("htmx"), server.AuthMiddleware(), server.RateLimitMiddleware("htmx", cfg.RateLimit.Htmx))
 	htmx.Path("/").HandlerFunc(server.htmxPageMain)
 	htmx.Path("/whoami").HandlerFunc(server.htmxPageWhoami)
+	if cfg.Webhooks != nil {
+		htmx.Path("/webhooks").HandlerFunc(server.htmxPageWebhooks)
+	}
 
 	api := server.mux.Name("api").PathPrefix("/api/").Subrouter()
-	api.Use(server.AuthMiddleware())
+	api.Use(MetricsMiddleware("api"), server.AuthMiddleware(), server.RateLimitMiddleware("api", cfg.RateLimit.Api), server.CsrfMiddleware())
 	api.Path("/upload").Methods(http.MethodPost).HandlerFunc(server.apiUploadFile)
 	api.Path("/delete").Methods(http.MethodDelete).HandlerFunc(server.apiDelteFile)
 	api.Path("/link").Methods(http.MethodGet).HandlerFunc(server.apiGenerateDownloadFileLink)
-	api.Path("/logout").Methods(http.MethodGet).HandlerFunc(server.apiLogout)
+	api.Path("/logout").Methods(http.MethodPost).HandlerFunc(server.apiLogout)
+	api.Path("/logout/everywhere").Methods(http.MethodPost).HandlerFunc(server.apiLogoutEverywhere)
+	api.Path("/sessions/revoke").Methods(http.MethodPost).HandlerFunc(server.apiRevokeSession)
+	if cfg.Webhooks != nil {
+		api.Path("/webhooks").Methods(http.MethodPost).HandlerFunc(server.apiAddWebhook)
+		api.Path("/webhooks").Methods(http.MethodDelete).HandlerFunc(server.apiDeleteWebhook)
+	}
+
+	admin := server.mux.Name("admin").PathPrefix("/admin").Subrouter()
+	admin.Use(MetricsMiddleware("admin"), server.AuthMiddleware(), server.AdminMiddleware(), server.CsrfMiddleware())
+	admin.Path("").Methods(http.MethodGet).HandlerFunc(server.htmxPageAdminUsers)
+	admin.Path("/user/{email}").Methods(http.MethodGet).HandlerFunc(server.htmxPageAdminUser)
+	admin.Path("/api/user-row").Methods(http.MethodGet).HandlerFunc(server.htmxAdminUsersRow)
+	admin.Path("/api/delete").Methods(http.MethodDelete).HandlerFunc(server.apiAdminDeleteFile)
+	admin.Path("/api/rotate").Methods(http.MethodPost).HandlerFunc(server.apiAdminRotateSecrets)
+	admin.Path("/api/disable").Methods(http.MethodPost).HandlerFunc(server.apiAdminSetDisabled)
+	admin.Path("/api/log-level").Methods(http.MethodGet).HandlerFunc(server.apiAdminGetLogLevel)
+	admin.Path("/api/log-level").Methods(http.MethodPost).HandlerFunc(server.apiAdminSetLogLevel)
+
+	raw := server.mux.Name("raw").PathPrefix("/u/").Subrouter()
+	raw.Use(MetricsMiddleware("raw"), server.TokenAuthMiddleware())
+	raw.Path("/{name}").Methods(http.MethodPut).HandlerFunc(server.apiRawUploadFile)
+
+	dav := server.mux.Name("dav").Subrouter()
+	dav.Use(MetricsMiddleware("dav"), server.TokenAuthMiddleware())
+	dav.Path(webdavPrefix).HandlerFunc(server.webdavHandler)
+	dav.PathPrefix(webdavPrefix + "/").HandlerFunc(server.webdavHandler)
+
+	s3gw := server.mux.Name("s3").PathPrefix(s3GatewayPrefix + "/").Subrouter()
+	s3gw.Use(MetricsMiddleware("s3"), server.S3AuthMiddleware())
+	s3gw.Path("/{bucket}").Methods(http.MethodHead).HandlerFunc(server.s3HeadBucket)
+	s3gw.Path("/{bucket}").Methods(http.MethodGet).HandlerFunc(server.s3ListObjectsV2)
+	s3gw.Path("/{bucket}/").Methods(http.MethodGet).HandlerFunc(server.s3ListObjectsV2)
+	s3gw.Path("/{bucket}/{key:.+}").Methods(http.MethodPut).HandlerFunc(server.s3PutObject)
+	s3gw.Path("/{bucket}/{key:.+}").Methods(http.MethodGet).HandlerFunc(server.s3GetObject)
+	s3gw.Path("/{bucket}/{key:.+}").Methods(http.MethodHead).HandlerFunc(server.s3HeadObject)
+	s3gw.Path("/{bucket}/{key:.+}").Methods(http.MethodDelete).HandlerFunc(server.s3DeleteObject)
 
 	return server, nil
 }
@@ -166,7 +292,6 @@ func logsMiddleware(handler http.Handler) http.Handler {
 			With(slog.Float64("duration_seconds", time.Now().Sub(params.TimeStamp).Seconds())).
 			With(slog.String("request_uri", params.Request.RequestURI)).
 			With(slog.String("remote_addr", params.Request.RemoteAddr)).
-			With(slog.String("request_id", params.Request.Header.Get("X-Request-ID"))).
 			Info("request processed")
 	})
 }
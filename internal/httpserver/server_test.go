@@ -0,0 +1,66 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paragor/sharefile/internal/log"
+	"github.com/paragor/sharefile/internal/sessions"
+)
+
+func TestMain(m *testing.M) {
+	// error responses are logged, they are expected in tests
+	if err := log.Setup(log.Config{Level: "error", Format: log.FormatText, File: os.DevNull}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+const testCookieKey = "kiel4teof4Eoziheigiesh7ooquiepho"
+
+// newTestServer builds server with all routes over fake storage, users authenticate by api token or local password
+func newTestServer(t *testing.T, fakeStorage *fakeStorage, authConfig *AuthConfig) *httpServer {
+	t.Helper()
+	if authConfig == nil {
+		authConfig = &AuthConfig{}
+	}
+	authConfig.CookieKey = testCookieKey
+	authConfig.SessionTTL = time.Hour
+	if authConfig.DefaultPolicy == nil {
+		authConfig.DefaultPolicy = &AuthPolicy{PublicShares: true}
+	}
+	server, err := NewHttpServer(Config{
+		Listen:                     "127.0.0.1:0",
+		Storage:                    fakeStorage,
+		Auth:                       authConfig,
+		PublicUrl:                  "http://sharefile.local",
+		DiagnosticEndpointsEnabled: true,
+		RssExpirationLink:          time.Hour,
+		Sessions:                   sessions.NewMemoryStore(),
+		Readiness:                  &ReadinessConfig{CacheTTL: 0, Timeout: time.Second},
+	})
+	if err != nil {
+		t.Fatalf("cant create server: %s", err)
+	}
+	return server.(*httpServer)
+}
+
+// serve sends request through router and returns response with read body
+func serve(s *httpServer, request *http.Request) (*http.Response, string) {
+	recorder := httptest.NewRecorder()
+	s.mux.ServeHTTP(recorder, request)
+	response := recorder.Result()
+	body, _ := io.ReadAll(response.Body)
+	return response, string(body)
+}
+
+func newTokenRequest(method string, target string, body string, email string, token string) *http.Request {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.SetBasicAuth(email, token)
+	return request
+}
@@ -0,0 +1,82 @@
+package httpserver
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/paragor/sharefile/internal/storage"
+)
+
+var errShareNotFound = errors.New("share not found")
+
+// openShare resolves /<prefix>/<share id>, or legacy /<prefix>/<email>/<secret> if it is enabled.
+// Returns errShareNotFound if link is unknown, rotated or disabled, and *throttledError after repeated failures.
+func (s *httpServer) openShare(r *http.Request) (storage.UserScopedStorage, *storage.Metadata, error) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	// throttle is checked before storage, so guesses do not cost s3 reads
+	clientIp, target := s.clientIp(r), ""
+	if len(parts) == 3 {
+		target = "email:" + parts[1]
+	}
+	if wait := s.throttle.retryAfter(clientIp, target); wait > 0 {
+		return nil, nil, &throttledError{retryAfter: wait}
+	}
+
+	userStorage, meta, err := s.openShareStorage(r, parts)
+	if errors.Is(err, errShareNotFound) {
+		s.throttle.fail(clientIp, target)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// link is valid, so it is not counted as guess, but owner may have lost public shares since link was copied
+	if !s.authConfig.policyFor(meta.Groups).PublicShares {
+		return nil, nil, fmt.Errorf("%w: public shares are disabled for '%s'", errShareNotFound, meta.Email)
+	}
+	return userStorage, meta, nil
+}
+
+func (s *httpServer) openShareStorage(r *http.Request, parts []string) (storage.UserScopedStorage, *storage.Metadata, error) {
+	var userStorage storage.UserScopedStorage
+	var err error
+	switch {
+	case len(parts) == 2:
+		userStorage, err = s.storage.OpenStorageByShareId(r.Context(), parts[1])
+	case len(parts) == 3 && s.legacyShareUrls:
+		userStorage, err = s.storage.OpenStorage(r.Context(), parts[1], false)
+	default:
+		return nil, nil, fmt.Errorf("%w: invalid url '%s'", errShareNotFound, r.URL.Path)
+	}
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrUserDisabled) {
+		return nil, nil, fmt.Errorf("%w: %w", errShareNotFound, err)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable communicate with storage: %w", err)
+	}
+
+	meta, err := userStorage.GetMetadata(r.Context())
+	if err != nil {
+		return nil, nil, fmt.Errorf("cant read metadata from storage: %w", err)
+	}
+	if len(parts) == 3 && subtle.ConstantTimeCompare([]byte(parts[2]), []byte(meta.Secret)) != 1 {
+		return nil, nil, fmt.Errorf("%w: invalid secret of '%s'", errShareNotFound, meta.Email)
+	}
+	return userStorage, meta, nil
+}
+
+// shareError reports unknown links as 404, so they do not tell whether user exists
+func shareError(r *http.Request, w http.ResponseWriter, err error) {
+	var throttled *throttledError
+	if errors.As(err, &throttled) {
+		writeThrottled(r, w, throttled)
+		return
+	}
+	if errors.Is(err, errShareNotFound) {
+		httpError(r.Context(), w, "share not found", err, http.StatusNotFound)
+		return
+	}
+	httpError(r.Context(), w, "unable to open share", err, http.StatusInternalServerError)
+}
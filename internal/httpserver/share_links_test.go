@@ -0,0 +1,78 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestShareLinks(t *testing.T) {
+	tests := []struct {
+		name            string
+		legacyShareUrls bool
+		path            func(user *fakeUser) string
+		disabled        bool
+		wantCode        int
+	}{
+		{name: "share id", path: func(user *fakeUser) string { return "/share/" + user.meta.ShareId }, wantCode: http.StatusOK},
+		{name: "rss by share id", path: func(user *fakeUser) string { return "/rss/" + user.meta.ShareId }, wantCode: http.StatusOK},
+		{name: "unknown share id", path: func(*fakeUser) string { return "/share/" + strings.Repeat("0", 32) }, wantCode: http.StatusNotFound},
+		{name: "disabled owner", path: func(user *fakeUser) string { return "/share/" + user.meta.ShareId }, disabled: true, wantCode: http.StatusNotFound},
+		{
+			name:     "legacy url when legacy urls are off",
+			path:     func(user *fakeUser) string { return "/share/" + user.meta.Email + "/" + user.meta.Secret },
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "legacy rss url when legacy urls are off",
+			path:     func(user *fakeUser) string { return "/rss/" + user.meta.Email + "/" + user.meta.Secret },
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:            "legacy url",
+			legacyShareUrls: true,
+			path:            func(user *fakeUser) string { return "/share/" + user.meta.Email + "/" + user.meta.Secret },
+			wantCode:        http.StatusOK,
+		},
+		{
+			name:            "legacy url with wrong secret",
+			legacyShareUrls: true,
+			path:            func(user *fakeUser) string { return "/share/" + user.meta.Email + "/wrong" },
+			wantCode:        http.StatusNotFound,
+		},
+		{
+			name:            "legacy url of disabled owner",
+			legacyShareUrls: true,
+			path:            func(user *fakeUser) string { return "/share/" + user.meta.Email + "/" + user.meta.Secret },
+			disabled:        true,
+			wantCode:        http.StatusNotFound,
+		},
+		{
+			name:            "legacy url of unknown user",
+			legacyShareUrls: true,
+			path:            func(user *fakeUser) string { return "/share/other@example.com/" + user.meta.Secret },
+			wantCode:        http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeStorage := newFakeStorage()
+			user := fakeStorage.addUser("user@example.com", map[string]string{"file.txt": "data"})
+			user.meta.Disabled = tt.disabled
+			s := newTestServer(t, fakeStorage, nil)
+			s.legacyShareUrls = tt.legacyShareUrls
+
+			response, body := serve(s, httptest.NewRequest(http.MethodGet, tt.path(user), nil))
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, tt.wantCode, body)
+			}
+			if response.StatusCode == http.StatusOK && !strings.Contains(body, "file.txt") {
+				t.Fatalf("share does not list files: %s", body)
+			}
+			if _, ok := fakeStorage.users["other@example.com"]; ok {
+				t.Fatal("share link created user")
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paragor/sharefile/internal/storage"
+)
+
+// fakeStorage keeps users and files in memory, api token of every user is "token"
+type fakeStorage struct {
+	lock    sync.Mutex
+	users   map[string]*fakeUser
+	pingErr error
+	// opens counts OpenStorage calls
+	opens int
+}
+
+type fakeUser struct {
+	meta  *storage.Metadata
+	files map[string]*fakeFile
+}
+
+type fakeFile struct {
+	data        []byte
+	contentType string
+	modifiedAt  time.Time
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{users: map[string]*fakeUser{}}
+}
+
+// addUser creates user with files, share id is derived from user number
+func (f *fakeStorage) addUser(email string, files map[string]string) *fakeUser {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	user := &fakeUser{
+		meta: &storage.Metadata{
+			Version:  5,
+			Email:    email,
+			Secret:   "secret",
+			ApiToken: "token",
+			ShareId:  strings.Repeat("a", 31) + string(rune('a'+len(f.users))),
+		},
+		files: map[string]*fakeFile{},
+	}
+	for name, data := range files {
+		user.files[name] = &fakeFile{data: []byte(data), modifiedAt: time.Now()}
+	}
+	f.users[email] = user
+	return user
+}
+
+func (f *fakeStorage) file(email string, name string) (string, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	user, ok := f.users[email]
+	if !ok {
+		return "", false
+	}
+	file, ok := user.files[name]
+	if !ok {
+		return "", false
+	}
+	return string(file.data), true
+}
+
+func (f *fakeStorage) OpenStorage(_ context.Context, email string, autoCreate bool) (storage.UserScopedStorage, error) {
+	f.lock.Lock()
+	f.opens++
+	user, ok := f.users[email]
+	f.lock.Unlock()
+	if !ok {
+		if !autoCreate {
+			return nil, storage.ErrNotFound
+		}
+		user = f.addUser(email, nil)
+	}
+	if user.meta.Disabled {
+		return nil, storage.ErrUserDisabled
+	}
+	return &fakeUserStorage{storage: f, user: user}, nil
+}
+
+func (f *fakeStorage) opensCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.opens
+}
+
+func (f *fakeStorage) OpenStorageByShareId(_ context.Context, shareId string) (storage.UserScopedStorage, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, user := range f.users {
+		if user.meta.ShareId == shareId {
+			if user.meta.Disabled {
+				return nil, storage.ErrUserDisabled
+			}
+			return &fakeUserStorage{storage: f, user: user}, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (f *fakeStorage) OpenStorageAsAdmin(_ context.Context, email string) (storage.UserScopedStorage, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	user, ok := f.users[email]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &fakeUserStorage{storage: f, user: user}, nil
+}
+
+func (f *fakeStorage) ListUsers(_ context.Context) ([]*storage.Metadata, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	result := make([]*storage.Metadata, 0, len(f.users))
+	for _, user := range f.users {
+		meta := *user.meta
+		result = append(result, &meta)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Email < result[j].Email })
+	return result, nil
+}
+
+func (f *fakeStorage) Ping(_ context.Context) error {
+	return f.pingErr
+}
+
+type fakeUserStorage struct {
+	storage *fakeStorage
+	user    *fakeUser
+}
+
+func (s *fakeUserStorage) GetMetadata(_ context.Context) (*storage.Metadata, error) {
+	s.storage.lock.Lock()
+	defer s.storage.lock.Unlock()
+	meta := *s.user.meta
+	return &meta, nil
+}
+
+func (s *fakeUserStorage) Upload(_ context.Context, objPath string, contentType string, file io.Reader) error {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	s.storage.lock.Lock()
+	defer s.storage.lock.Unlock()
+	s.user.files[objPath] = &fakeFile{data: data, contentType: contentType, modifiedAt: time.Now()}
+	return nil
+}
+
+func (s *fakeUserStorage) Download(_ context.Context, objPath string, offset int64) (io.ReadCloser, error) {
+	s.storage.lock.Lock()
+	defer s.storage.lock.Unlock()
+	file, ok := s.user.files[objPath]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(file.data[offset:])), nil
+}
+
+func (s *fakeUserStorage) Stat(_ context.Context, objPath string) (*storage.FileInList, error) {
+	s.storage.lock.Lock()
+	defer s.storage.lock.Unlock()
+	file, ok := s.user.files[objPath]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &storage.FileInList{Path: objPath, LastModifiedAt: file.modifiedAt, Size: len(file.data), ContentType: file.contentType}, nil
+}
+
+func (s *fakeUserStorage) Move(_ context.Context, objPathOld string, objPathNew string) error {
+	s.storage.lock.Lock()
+	defer s.storage.lock.Unlock()
+	file, ok := s.user.files[objPathOld]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	s.user.files[objPathNew] = file
+	delete(s.user.files, objPathOld)
+	return nil
+}
+
+func (s *fakeUserStorage) Delete(_ context.Context, objPath string) error {
+	s.storage.lock.Lock()
+	defer s.storage.lock.Unlock()
+	delete(s.user.files, objPath)
+	return nil
+}
+
+func (s *fakeUserStorage) GenerateDownloadLink(_ context.Context, objPath string, _ time.Duration) (string, error) {
+	return "http://s3.local/" + objPath, nil
+}
+
+func (s *fakeUserStorage) ListFiles(_ context.Context) ([]storage.FileInList, error) {
+	s.storage.lock.Lock()
+	defer s.storage.lock.Unlock()
+	result := make([]storage.FileInList, 0, len(s.user.files))
+	for name, file := range s.user.files {
+		result = append(result, storage.FileInList{Path: name, LastModifiedAt: file.modifiedAt, Size: len(file.data)})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].LastModifiedAt.After(result[j].LastModifiedAt) })
+	return result, nil
+}
+
+func (s *fakeUserStorage) RotateSecrets(_ context.Context) (*storage.Metadata, error) {
+	s.storage.lock.Lock()
+	defer s.storage.lock.Unlock()
+	s.user.meta.Secret += "-rotated"
+	s.user.meta.ApiToken += "-rotated"
+	s.user.meta.ShareId = strings.Repeat("f", 32)
+	meta := *s.user.meta
+	return &meta, nil
+}
+
+func (s *fakeUserStorage) SetDisabled(_ context.Context, disabled bool) error {
+	s.storage.lock.Lock()
+	defer s.storage.lock.Unlock()
+	s.user.meta.Disabled = disabled
+	return nil
+}
+
+func (s *fakeUserStorage) SetWebhooks(_ context.Context, webhooks []storage.Webhook) error {
+	s.storage.lock.Lock()
+	defer s.storage.lock.Unlock()
+	s.user.meta.Webhooks = webhooks
+	return nil
+}
+
+func (s *fakeUserStorage) SetGroups(_ context.Context, groups []string) error {
+	s.storage.lock.Lock()
+	defer s.storage.lock.Unlock()
+	s.user.meta.Groups = groups
+	return nil
+}
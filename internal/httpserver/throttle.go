@@ -0,0 +1,143 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// failureThrottle blocks keys, e.g. client ip or guessed target, with exponential backoff after repeated failures
+type failureThrottle struct {
+	freeFailures int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+
+	lock      sync.Mutex
+	states    map[string]*failureState
+	lastPurge time.Time
+}
+
+type failureState struct {
+	failures      int
+	lastFailureAt time.Time
+	blockedUntil  time.Time
+}
+
+func newFailureThrottle(freeFailures int, baseDelay time.Duration, maxDelay time.Duration) *failureThrottle {
+	return &failureThrottle{
+		freeFailures: freeFailures,
+		baseDelay:    baseDelay,
+		maxDelay:     maxDelay,
+		states:       map[string]*failureState{},
+		lastPurge:    time.Now(),
+	}
+}
+
+// retryAfter returns longest block of keys, 0 if request is allowed
+func (t *failureThrottle) retryAfter(keys ...string) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	var result time.Duration
+	for _, key := range keys {
+		state, ok := t.states[key]
+		if !ok {
+			continue
+		}
+		if wait := state.blockedUntil.Sub(now); wait > result {
+			result = wait
+		}
+	}
+	return result
+}
+
+func (t *failureThrottle) fail(keys ...string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	t.purge(now)
+	for _, key := range keys {
+		state, ok := t.states[key]
+		if !ok {
+			state = &failureState{}
+			t.states[key] = state
+		}
+		state.failures++
+		state.lastFailureAt = now
+		if state.failures <= t.freeFailures {
+			continue
+		}
+		delay := t.maxDelay
+		if shift := state.failures - t.freeFailures - 1; shift < 32 {
+			delay = min(t.baseDelay<<shift, t.maxDelay)
+		}
+		state.blockedUntil = now.Add(delay)
+	}
+}
+
+// purge forgets keys without failures during maxDelay
+func (t *failureThrottle) purge(now time.Time) {
+	if now.Sub(t.lastPurge) < time.Minute {
+		return
+	}
+	t.lastPurge = now
+	for key, state := range t.states {
+		if now.Sub(state.lastFailureAt) > t.maxDelay && now.After(state.blockedUntil) {
+			delete(t.states, key)
+		}
+	}
+}
+
+// guessThrottle blocks client ip for long, but target of guesses, e.g. email or share owner, only shortly,
+// so attacker can not lock victim out, while guessing of one target from many ips is still slowed down
+type guessThrottle struct {
+	// clients is nil if client ips are not known
+	clients *failureThrottle
+	targets *failureThrottle
+}
+
+// newGuessThrottle blocks client ips only if perClientIp, without trusted proxies all clients behind reverse proxy
+// have its ip, so block of one guessing client would lock out everyone
+func newGuessThrottle(perClientIp bool) *guessThrottle {
+	t := &guessThrottle{targets: newFailureThrottle(10, time.Second, 30*time.Second)}
+	if perClientIp {
+		t.clients = newFailureThrottle(10, time.Second, 15*time.Minute)
+	}
+	return t
+}
+
+// retryAfter returns longest block of client ip and target, empty target is not checked
+func (t *guessThrottle) retryAfter(clientIp string, target string) time.Duration {
+	var wait time.Duration
+	if t.clients != nil {
+		wait = t.clients.retryAfter(clientIp)
+	}
+	if target != "" {
+		wait = max(wait, t.targets.retryAfter(target))
+	}
+	return wait
+}
+
+func (t *guessThrottle) fail(clientIp string, target string) {
+	if t.clients != nil {
+		t.clients.fail(clientIp)
+	}
+	if target != "" {
+		t.targets.fail(target)
+	}
+}
+
+type throttledError struct {
+	retryAfter time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("too many failures, retry after %s", e.retryAfter)
+}
+
+func writeThrottled(r *http.Request, w http.ResponseWriter, err *throttledError) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(err.retryAfter.Seconds())+1))
+	httpError(r.Context(), w, "too many requests", err, http.StatusTooManyRequests)
+}
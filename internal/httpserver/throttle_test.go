@@ -0,0 +1,121 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestFailureThrottle(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		// wantBlock is expected block after failures, zero means not blocked
+		wantBlock time.Duration
+	}{
+		{name: "no failures"},
+		{name: "free failures", failures: 3},
+		{name: "first block", failures: 4, wantBlock: time.Second},
+		{name: "exponential backoff", failures: 6, wantBlock: 4 * time.Second},
+		{name: "capped by max delay", failures: 20, wantBlock: time.Minute},
+		{name: "shift overflow", failures: 100, wantBlock: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			throttle := newFailureThrottle(3, time.Second, time.Minute)
+			for range tt.failures {
+				throttle.fail("key")
+			}
+			wait := throttle.retryAfter("key")
+			if wait > tt.wantBlock || wait < tt.wantBlock-time.Second/2 {
+				t.Fatalf("blocked for %s, want %s", wait, tt.wantBlock)
+			}
+			if other := throttle.retryAfter("other"); other != 0 {
+				t.Fatalf("other key is blocked for %s", other)
+			}
+		})
+	}
+}
+
+func TestGuessThrottle(t *testing.T) {
+	tests := []struct {
+		name string
+		// failures are made from distinct client ips if distributed
+		failures    int
+		distributed bool
+		// sharedIp means server has no trusted proxies, so all clients have ip of reverse proxy
+		sharedIp   bool
+		target     string
+		wantClient time.Duration
+		wantTarget time.Duration
+	}{
+		{name: "few failures", failures: 5, target: "local:user@example.com"},
+		{name: "guessing from one ip", failures: 30, target: "local:user@example.com", wantClient: 15 * time.Minute, wantTarget: 30 * time.Second},
+		{name: "guessing from many ips", failures: 30, distributed: true, target: "local:user@example.com", wantTarget: 30 * time.Second},
+		{name: "guessing without target", failures: 30, wantClient: 15 * time.Minute},
+		{name: "guessing behind shared ip", failures: 30, sharedIp: true, target: "local:user@example.com", wantTarget: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			throttle := newGuessThrottle(!tt.sharedIp)
+			for i := range tt.failures {
+				clientIp := "198.51.100.1"
+				if tt.distributed {
+					clientIp = fmt.Sprintf("198.51.100.%d", i+2)
+				}
+				throttle.fail(clientIp, tt.target)
+			}
+			if wait := throttle.retryAfter("198.51.100.1", ""); wait > tt.wantClient || wait < tt.wantClient-time.Second {
+				t.Fatalf("client is blocked for %s, want %s", wait, tt.wantClient)
+			}
+			// victim logs in from another ip
+			if wait := throttle.retryAfter("203.0.113.1", tt.target); wait > tt.wantTarget || wait < tt.wantTarget-time.Second {
+				t.Fatalf("target is blocked for %s, want %s", wait, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestGuessThrottleBehindSharedIp(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []netip.Prefix
+		// wantVictimCode is status of another user coming from the same reverse proxy after guesses of attacker
+		wantVictimCode int
+	}{
+		{name: "no trusted proxies", wantVictimCode: http.StatusCreated},
+		{name: "trusted proxy", trustedProxies: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, wantVictimCode: http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeStorage := newFakeStorage()
+			fakeStorage.addUser("victim@example.com", nil)
+			s := newTestServer(t, fakeStorage, nil)
+			s.trustedProxies = tt.trustedProxies
+			s.throttle = newGuessThrottle(len(tt.trustedProxies) > 0)
+			request := func(email string, token string, clientIp string) *http.Request {
+				request := newTokenRequest(http.MethodPut, "/u/file.txt", "x", email, token)
+				request.RemoteAddr = "192.0.2.1:1234"
+				request.Header.Set("X-Forwarded-For", clientIp)
+				return request
+			}
+
+			// attacker guesses tokens of many users
+			for i := range 30 {
+				serve(s, request(fmt.Sprintf("user-%d@example.com", i), "wrong", "198.51.100.1"))
+			}
+			response, _ := serve(s, request("victim@example.com", "token", "203.0.113.1"))
+			if response.StatusCode != tt.wantVictimCode {
+				t.Fatalf("victim got status %d, want %d", response.StatusCode, tt.wantVictimCode)
+			}
+			if len(tt.trustedProxies) > 0 {
+				response, _ := serve(s, request("victim@example.com", "token", "198.51.100.1"))
+				if response.StatusCode != http.StatusTooManyRequests {
+					t.Fatalf("attacker ip got status %d, want 429", response.StatusCode)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/paragor/sharefile/internal/storage"
+	"github.com/paragor/sharefile/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	// global provider is set once, tracers of packages are bound to first provider
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewTracerProvider(exporter, "sharefile", 1)
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+	})
+
+	const (
+		callerTraceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+		callerSpanId  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name        string
+		traceparent string
+		wantSpans   bool
+		// wantTraceId is empty if new trace should be started
+		wantTraceId string
+	}{
+		{name: "sampled caller", traceparent: "00-" + callerTraceId + "-" + callerSpanId + "-01", wantSpans: true, wantTraceId: callerTraceId},
+		{name: "not sampled caller", traceparent: "00-" + callerTraceId + "-" + callerSpanId + "-00"},
+		{name: "no caller", wantSpans: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			fakeStorage := newFakeStorage()
+			fakeStorage.addUser("user@example.com", nil)
+			s := newTestServer(t, fakeStorage, nil)
+			s.storage = storage.NewInstrumentedStorage("fake", fakeStorage)
+			request := newTokenRequest(http.MethodPut, "/u/file.txt", "hello", "user@example.com", "token")
+			if tt.traceparent != "" {
+				request.Header.Set("traceparent", tt.traceparent)
+			}
+
+			response, body := serve(s, request)
+			if response.StatusCode != http.StatusCreated {
+				t.Fatalf("status %d: %s", response.StatusCode, body)
+			}
+			if err := provider.ForceFlush(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			spans := exporter.GetSpans()
+			if !tt.wantSpans {
+				if len(spans) != 0 {
+					t.Fatalf("exported %d spans of not sampled trace", len(spans))
+				}
+				return
+			}
+
+			var server *tracetest.SpanStub
+			for i := range spans {
+				if spans[i].SpanKind == trace.SpanKindServer {
+					server = &spans[i]
+				}
+			}
+			if server == nil {
+				t.Fatalf("no server span in %d spans", len(spans))
+			}
+			if server.Name != "PUT /u/{name}" {
+				t.Fatalf("server span name %q", server.Name)
+			}
+			traceId := server.SpanContext.TraceID()
+			if tt.wantTraceId != "" {
+				if traceId.String() != tt.wantTraceId {
+					t.Fatalf("trace id %s, want %s of caller", traceId, tt.wantTraceId)
+				}
+				if server.Parent.SpanID().String() != callerSpanId || !server.Parent.IsRemote() {
+					t.Fatalf("server span parent %s, want remote %s", server.Parent.SpanID(), callerSpanId)
+				}
+			} else if traceId.String() == callerTraceId || server.Parent.IsValid() {
+				t.Fatalf("server span continues trace %s without caller", traceId)
+			}
+
+			children := map[string]bool{}
+			for _, span := range spans {
+				if span.Parent.SpanID() == server.SpanContext.SpanID() {
+					if span.SpanContext.TraceID() != traceId {
+						t.Fatalf("child span %s has trace id %s", span.Name, span.SpanContext.TraceID())
+					}
+					children[span.Name] = true
+				}
+			}
+			for _, name := range []string{"storage.OpenStorage", "storage.Upload"} {
+				if !children[name] {
+					t.Fatalf("server span has no child %s, children %v", name, children)
+				}
+			}
+		})
+	}
+}
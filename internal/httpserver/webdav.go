@@ -0,0 +1,427 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paragor/sharefile/internal/audit"
+	"github.com/paragor/sharefile/internal/log"
+	"github.com/paragor/sharefile/internal/storage"
+	"golang.org/x/net/webdav"
+)
+
+const webdavPrefix = "/dav"
+
+func (s *httpServer) webdavHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := s.extractAuthContext(r)
+	if err != nil {
+		httpError(r.Context(), w, "cant read auth from request", err, http.StatusInternalServerError)
+		return
+	}
+
+	userStorage, err := s.storage.OpenStorage(r.Context(), auth.Email, false)
+	if err != nil {
+		httpError(r.Context(), w, "unable to open user scoped storage", err, http.StatusInternalServerError)
+		return
+	}
+	limit := int64(-1)
+	if r.Method == http.MethodPut || r.Method == "COPY" {
+		limit, err = uploadLimit(r.Context(), auth.Policy, userStorage)
+		if err != nil {
+			httpError(r.Context(), w, "unable to check upload limits", err, http.StatusInternalServerError)
+			return
+		}
+		if err := checkUploadSize(limit, r.ContentLength); err != nil {
+			httpError(r.Context(), w, "file exceeds max upload size or quota", err, http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	fsys := &webdavFileSystem{storage: userStorage, uploadLimit: limit, putSize: -1}
+	if r.Method == http.MethodPut {
+		fsys.putBody = &webdavBody{ReadCloser: r.Body}
+		fsys.putSize = r.ContentLength
+		r.Body = fsys.putBody
+	}
+	handler := &webdav.Handler{
+		Prefix:     webdavPrefix,
+		FileSystem: fsys,
+		LockSystem: s.webdavLocks.get(auth.Email),
+		Logger: func(request *http.Request, err error) {
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.FromContext(request.Context()).With(log.Error(err)).Error("webdav request failed")
+			}
+			s.auditWebdav(request, err)
+		},
+	}
+	handler.ServeHTTP(&webdavStatusWriter{ResponseWriter: w, fsys: fsys}, r)
+}
+
+// webdavStatusWriter replaces 405, which webdav responds on any failed PUT, by 413 if upload went over limit
+type webdavStatusWriter struct {
+	http.ResponseWriter
+	fsys     *webdavFileSystem
+	replaced bool
+}
+
+func (w *webdavStatusWriter) WriteHeader(code int) {
+	if code == http.StatusMethodNotAllowed && w.fsys.limitExceeded {
+		w.replaced = true
+		code = http.StatusRequestEntityTooLarge
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *webdavStatusWriter) Write(p []byte) (int, error) {
+	if w.replaced {
+		// status text of 405 is written by webdav after header
+		w.replaced = false
+		_, err := w.ResponseWriter.Write([]byte("file exceeds max upload size or quota\n"))
+		return len(p), err
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// webdavBody remembers failure of request body, webdav copies body into file and closes file even if copy failed
+type webdavBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *webdavBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		b.err = err
+	}
+	return n, err
+}
+
+// auditWebdav records file changes and downloads, listing and locking are not audited
+func (s *httpServer) auditWebdav(r *http.Request, err error) {
+	event := audit.Event{Path: webdavObjPath(strings.TrimPrefix(r.URL.Path, webdavPrefix)), Interface: "webdav"}
+	if event.Path == "" {
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		event.Action = audit.ActionUpload
+	case http.MethodGet:
+		event.Action = audit.ActionDownload
+	case http.MethodDelete:
+		event.Action = audit.ActionDelete
+	case "MOVE":
+		event.Action = audit.ActionMove
+		if destination, parseErr := url.Parse(r.Header.Get("Destination")); parseErr == nil {
+			event.NewPath = webdavObjPath(strings.TrimPrefix(destination.Path, webdavPrefix))
+		}
+	default:
+		return
+	}
+	s.notifyWebhooks(r.Context(), s.audit(r, event, err))
+}
+
+// webdavLockSystems keeps lock system per user, so users cant lock each other's files
+type webdavLockSystems struct {
+	lock    sync.Mutex
+	systems map[string]webdav.LockSystem
+}
+
+func (l *webdavLockSystems) get(email string) webdav.LockSystem {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.systems == nil {
+		l.systems = map[string]webdav.LockSystem{}
+	}
+	ls, ok := l.systems[email]
+	if !ok {
+		ls = webdav.NewMemLS()
+		l.systems[email] = ls
+	}
+	return ls
+}
+
+// webdavFileSystem adapts flat user scoped storage to webdav: there is only root directory with files in it
+type webdavFileSystem struct {
+	storage storage.UserScopedStorage
+	// uploadLimit is calculated per request, -1 means unlimited
+	uploadLimit int64
+	// putBody and putSize are set for PUT, putSize is -1 if body length is unknown
+	putBody *webdavBody
+	putSize int64
+	// limitExceeded is set if upload failed because of uploadLimit
+	limitExceeded bool
+}
+
+func webdavObjPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (fsys *webdavFileSystem) Mkdir(_ context.Context, _ string, _ os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (fsys *webdavFileSystem) OpenFile(ctx context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	objPath := webdavObjPath(name)
+	if objPath == "" {
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return nil, os.ErrPermission
+		}
+		return &webdavDir{ctx: ctx, storage: fsys.storage}, nil
+	}
+	// PUT opens with O_CREATE|O_TRUNC, PROPPATCH opens existing file with O_RDWR and closes it without writing,
+	// so only truncating open replaces object
+	if flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		if err := validateFilePath(objPath); err != nil {
+			return nil, os.ErrPermission
+		}
+		return newWebdavWriteFile(ctx, fsys, objPath), nil
+	}
+
+	info, err := fsys.stat(ctx, objPath)
+	if err != nil {
+		return nil, err
+	}
+	return &webdavReadFile{ctx: ctx, storage: fsys.storage, info: info}, nil
+}
+
+func (fsys *webdavFileSystem) RemoveAll(ctx context.Context, name string) error {
+	objPath := webdavObjPath(name)
+	if objPath == "" {
+		return os.ErrPermission
+	}
+	if _, err := fsys.stat(ctx, objPath); err != nil {
+		return err
+	}
+	return fsys.storage.Delete(ctx, objPath)
+}
+
+func (fsys *webdavFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldPath, newPath := webdavObjPath(oldName), webdavObjPath(newName)
+	if oldPath == "" || newPath == "" {
+		return os.ErrPermission
+	}
+	if err := validateFilePath(newPath); err != nil {
+		return os.ErrPermission
+	}
+	return fsys.storage.Move(ctx, oldPath, newPath)
+}
+
+func (fsys *webdavFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	objPath := webdavObjPath(name)
+	if objPath == "" {
+		return webdavDirInfo{}, nil
+	}
+	return fsys.stat(ctx, objPath)
+}
+
+func (fsys *webdavFileSystem) stat(ctx context.Context, objPath string) (*webdavFileInfo, error) {
+	file, err := fsys.storage.Stat(ctx, objPath)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return &webdavFileInfo{file: *file}, nil
+}
+
+type webdavFileInfo struct {
+	file storage.FileInList
+}
+
+func (fi *webdavFileInfo) Name() string       { return path.Base(fi.file.Path) }
+func (fi *webdavFileInfo) Size() int64        { return int64(fi.file.Size) }
+func (fi *webdavFileInfo) Mode() fs.FileMode  { return 0644 }
+func (fi *webdavFileInfo) ModTime() time.Time { return fi.file.LastModifiedAt }
+func (fi *webdavFileInfo) IsDir() bool        { return false }
+func (fi *webdavFileInfo) Sys() any           { return nil }
+
+// ContentType prevents webdav from reading file head to guess content type on every propfind
+func (fi *webdavFileInfo) ContentType(_ context.Context) (string, error) {
+	contentType := mime.TypeByExtension(path.Ext(fi.file.Path))
+	if contentType == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return contentType, nil
+}
+
+type webdavDirInfo struct{}
+
+func (webdavDirInfo) Name() string       { return "/" }
+func (webdavDirInfo) Size() int64        { return 0 }
+func (webdavDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0755 }
+func (webdavDirInfo) ModTime() time.Time { return time.Time{} }
+func (webdavDirInfo) IsDir() bool        { return true }
+func (webdavDirInfo) Sys() any           { return nil }
+
+type webdavDir struct {
+	ctx     context.Context
+	storage storage.UserScopedStorage
+}
+
+func (d *webdavDir) Close() error { return nil }
+func (d *webdavDir) Read(_ []byte) (int, error) {
+	return 0, fmt.Errorf("cant read directory")
+}
+func (d *webdavDir) Seek(_ int64, _ int) (int64, error) {
+	return 0, fmt.Errorf("cant seek directory")
+}
+func (d *webdavDir) Write(_ []byte) (int, error) {
+	return 0, fmt.Errorf("cant write directory")
+}
+func (d *webdavDir) Stat() (fs.FileInfo, error) { return webdavDirInfo{}, nil }
+func (d *webdavDir) Readdir(count int) ([]fs.FileInfo, error) {
+	listing, err := d.storage.ListFiles(d.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 && len(listing) > count {
+		listing = listing[:count]
+	}
+	result := make([]fs.FileInfo, 0, len(listing))
+	for _, file := range listing {
+		result = append(result, &webdavFileInfo{file: file})
+	}
+	return result, nil
+}
+
+// webdavReadFile opens object lazily and reopens it from new offset after seek
+type webdavReadFile struct {
+	ctx     context.Context
+	storage storage.UserScopedStorage
+	info    *webdavFileInfo
+
+	offset int64
+	body   io.ReadCloser
+}
+
+func (f *webdavReadFile) Read(p []byte) (int, error) {
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		body, err := f.storage.Download(f.ctx, f.info.file.Path, f.offset)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *webdavReadFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset: %d", offset)
+	}
+	if offset != f.offset && f.body != nil {
+		_ = f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *webdavReadFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	return f.body.Close()
+}
+func (f *webdavReadFile) Write(_ []byte) (int, error) {
+	return 0, fmt.Errorf("file is opened for reading")
+}
+func (f *webdavReadFile) Readdir(_ int) ([]fs.FileInfo, error) {
+	return nil, fmt.Errorf("not a directory")
+}
+func (f *webdavReadFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// webdavWriteFile streams written data into storage upload through pipe
+type webdavWriteFile struct {
+	fsys   *webdavFileSystem
+	info   *webdavFileInfo
+	writer *io.PipeWriter
+	done   chan error
+}
+
+func newWebdavWriteFile(ctx context.Context, fsys *webdavFileSystem, objPath string) *webdavWriteFile {
+	contentType := mime.TypeByExtension(path.Ext(objPath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	reader, writer := io.Pipe()
+	f := &webdavWriteFile{
+		fsys:   fsys,
+		info:   &webdavFileInfo{file: storage.FileInList{Path: objPath, LastModifiedAt: time.Now()}},
+		writer: writer,
+		done:   make(chan error, 1),
+	}
+	go func() {
+		limitReader := newUploadLimitReader(reader, fsys.uploadLimit)
+		err := fsys.storage.Upload(ctx, objPath, contentType, limitReader)
+		if err != nil && limitReader.Exceeded() {
+			err = fmt.Errorf("%w: %w", errUploadLimitExceeded, err)
+		}
+		_ = reader.CloseWithError(err)
+		f.done <- err
+	}()
+	return f
+}
+
+func (f *webdavWriteFile) Write(p []byte) (int, error) {
+	n, err := f.writer.Write(p)
+	f.info.file.Size += n
+	return n, err
+}
+
+// Close commits upload, interrupted PUT is aborted, so truncated body does not replace existing file
+func (f *webdavWriteFile) Close() error {
+	var abort error
+	switch {
+	case f.fsys.putBody != nil && f.fsys.putBody.err != nil:
+		abort = fmt.Errorf("request body is interrupted: %w", f.fsys.putBody.err)
+	case f.fsys.putSize >= 0 && int64(f.info.file.Size) != f.fsys.putSize:
+		abort = fmt.Errorf("received %d of %d bytes", f.info.file.Size, f.fsys.putSize)
+	}
+	if abort != nil {
+		_ = f.writer.CloseWithError(abort)
+	} else {
+		_ = f.writer.Close()
+	}
+	err := <-f.done
+	if errors.Is(err, errUploadLimitExceeded) {
+		f.fsys.limitExceeded = true
+	}
+	return err
+}
+func (f *webdavWriteFile) Read(_ []byte) (int, error) {
+	return 0, fmt.Errorf("file is opened for writing")
+}
+func (f *webdavWriteFile) Seek(_ int64, _ int) (int64, error) {
+	return 0, fmt.Errorf("file is opened for writing")
+}
+func (f *webdavWriteFile) Readdir(_ int) ([]fs.FileInfo, error) {
+	return nil, fmt.Errorf("not a directory")
+}
+func (f *webdavWriteFile) Stat() (fs.FileInfo, error) { return f.info, nil }
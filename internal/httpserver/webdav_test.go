@@ -0,0 +1,150 @@
+package httpserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const webdavProppatchBody = `<?xml version="1.0" encoding="utf-8" ?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:schemas-microsoft-com:">
+  <D:set><D:prop><Z:Win32FileAttributes>00000020</Z:Win32FileAttributes></D:prop></D:set>
+</D:propertyupdate>`
+
+func TestWebdavKeepsFileContent(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		body        string
+		headers     map[string]string
+		wantFile    string
+		wantContent string
+	}{
+		{name: "proppatch", method: "PROPPATCH", body: webdavProppatchBody, wantFile: "file.txt", wantContent: "hello world"},
+		{name: "propfind", method: "PROPFIND", headers: map[string]string{"Depth": "0"}, wantFile: "file.txt", wantContent: "hello world"},
+		{name: "get", method: http.MethodGet, wantFile: "file.txt", wantContent: "hello world"},
+		{name: "put", method: http.MethodPut, body: "new", wantFile: "file.txt", wantContent: "new"},
+		{name: "move", method: "MOVE", headers: map[string]string{"Destination": "http://example.com/dav/moved.txt"}, wantFile: "moved.txt", wantContent: "hello world"},
+		{name: "copy", method: "COPY", headers: map[string]string{"Destination": "http://example.com/dav/copied.txt"}, wantFile: "copied.txt", wantContent: "hello world"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeStorage := newFakeStorage()
+			fakeStorage.addUser("user@example.com", map[string]string{"file.txt": "hello world"})
+			s := newTestServer(t, fakeStorage, nil)
+			request := newTokenRequest(tt.method, "http://example.com/dav/file.txt", tt.body, "user@example.com", "token")
+			for key, value := range tt.headers {
+				request.Header.Set(key, value)
+			}
+
+			response, body := serve(s, request)
+			if response.StatusCode >= 300 {
+				t.Fatalf("status %d: %s", response.StatusCode, body)
+			}
+			content, ok := fakeStorage.file("user@example.com", tt.wantFile)
+			if !ok {
+				t.Fatalf("file %s is not found", tt.wantFile)
+			}
+			if content != tt.wantContent {
+				t.Fatalf("content %q, want %q", content, tt.wantContent)
+			}
+		})
+	}
+}
+
+// interruptedReader returns data and then error as dropped client connection does
+type interruptedReader struct {
+	data io.Reader
+}
+
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if errors.Is(err, io.EOF) {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestWebdavPut(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          io.Reader
+		contentLength int64
+		policy        *AuthPolicy
+		wantCode      int
+		wantContent   string
+	}{
+		{
+			name:          "content length",
+			body:          strings.NewReader("new"),
+			contentLength: 3,
+			wantCode:      http.StatusCreated,
+			wantContent:   "new",
+		},
+		{
+			name:          "chunked",
+			body:          strings.NewReader("new"),
+			contentLength: -1,
+			wantCode:      http.StatusCreated,
+			wantContent:   "new",
+		},
+		{
+			name:          "interrupted body",
+			body:          &interruptedReader{data: strings.NewReader("ne")},
+			contentLength: 3,
+			wantCode:      http.StatusMethodNotAllowed,
+			wantContent:   "hello world",
+		},
+		{
+			name:          "interrupted chunked body",
+			body:          &interruptedReader{data: strings.NewReader("ne")},
+			contentLength: -1,
+			wantCode:      http.StatusMethodNotAllowed,
+			wantContent:   "hello world",
+		},
+		{
+			name:          "short body",
+			body:          strings.NewReader("ne"),
+			contentLength: 3,
+			wantCode:      http.StatusMethodNotAllowed,
+			wantContent:   "hello world",
+		},
+		{
+			name:          "chunked over limit",
+			body:          strings.NewReader("hello world, again"),
+			contentLength: -1,
+			policy:        &AuthPolicy{MaxUploadSizeBytes: 15},
+			wantCode:      http.StatusRequestEntityTooLarge,
+			wantContent:   "hello world",
+		},
+		{
+			name:          "chunked over quota",
+			body:          strings.NewReader("hello world, again"),
+			contentLength: -1,
+			policy:        &AuthPolicy{QuotaBytes: 20},
+			wantCode:      http.StatusRequestEntityTooLarge,
+			wantContent:   "hello world",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeStorage := newFakeStorage()
+			fakeStorage.addUser("user@example.com", map[string]string{"file.txt": "hello world"})
+			s := newTestServer(t, fakeStorage, &AuthConfig{DefaultPolicy: tt.policy})
+			request := newTokenRequest(http.MethodPut, "http://example.com/dav/file.txt", "", "user@example.com", "token")
+			request.Body = io.NopCloser(tt.body)
+			request.ContentLength = tt.contentLength
+
+			response, body := serve(s, request)
+			if response.StatusCode != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, tt.wantCode, body)
+			}
+			content, _ := fakeStorage.file("user@example.com", "file.txt")
+			if content != tt.wantContent {
+				t.Fatalf("content %q, want %q", content, tt.wantContent)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paragor/sharefile/internal/audit"
+	"github.com/paragor/sharefile/internal/storage"
+	"github.com/paragor/sharefile/internal/webhooks"
+)
+
+const maxWebhooksPerUser = 10
+
+// webhookEvents maps audited actions to webhook events, downloads and account changes are not sent
+var webhookEvents = map[string]string{
+	audit.ActionUpload:      webhooks.EventUpload,
+	audit.ActionDelete:      webhooks.EventDelete,
+	audit.ActionMove:        webhooks.EventMove,
+	audit.ActionShareViewed: webhooks.EventShareAccess,
+	audit.ActionRssFetched:  webhooks.EventShareAccess,
+}
+
+// shareAccessWebhookInterval is how often share access of one visitor is sent, feed readers poll every few minutes
+// and would flood receivers with identical events
+const shareAccessWebhookInterval = 10 * time.Minute
+
+// notifyWebhooks sends successful event audited by s.audit to webhooks of its owner
+func (s *httpServer) notifyWebhooks(ctx context.Context, event audit.Event) {
+	if s.webhooks == nil || event.Result != audit.ResultSuccess {
+		return
+	}
+	webhookEvent, ok := webhookEvents[event.Action]
+	if !ok {
+		return
+	}
+	if webhookEvent == webhooks.EventShareAccess && !s.shareAccessWebhooks.allow(event.Owner+"\x00"+event.Action+"\x00"+event.Ip) {
+		return
+	}
+	payload := webhooks.Payload{
+		Event:     webhookEvent,
+		Time:      event.Time,
+		Owner:     event.Owner,
+		Actor:     event.Actor,
+		Path:      event.Path,
+		NewPath:   event.NewPath,
+		Interface: event.Interface,
+	}
+	// share page and rss are both web requests, receivers may want to tell feed readers from visitors
+	if event.Action == audit.ActionRssFetched {
+		payload.Interface = "rss"
+	}
+	s.webhooks.Notify(ctx, payload)
+}
+
+// webhookLimiter lets one event per key during interval, suppressed events are dropped
+type webhookLimiter struct {
+	interval time.Duration
+
+	lock      sync.Mutex
+	sentAt    map[string]time.Time
+	lastPurge time.Time
+}
+
+func newWebhookLimiter(interval time.Duration) *webhookLimiter {
+	return &webhookLimiter{interval: interval, sentAt: map[string]time.Time{}, lastPurge: time.Now()}
+}
+
+func (l *webhookLimiter) allow(key string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastPurge) > l.interval {
+		l.lastPurge = now
+		for key, sentAt := range l.sentAt {
+			if now.Sub(sentAt) >= l.interval {
+				delete(l.sentAt, key)
+			}
+		}
+	}
+	if sentAt, ok := l.sentAt[key]; ok && now.Sub(sentAt) < l.interval {
+		return false
+	}
+	l.sentAt[key] = now
+	return true
+}
+
+type webhooksContext struct {
+	Events   []string
+	Webhooks []webhooksContextWebhook
+	// Global is shown to admins only, secrets are not rendered
+	Global []webhooksContextWebhook
+}
+type webhooksContextWebhook struct {
+	Id         string
+	Url        string
+	Secret     string
+	Events     []string
+	CreatedAt  time.Time
+	Deliveries []webhooks.Delivery
+}
+
+func (s *httpServer) htmxPageWebhooks(w http.ResponseWriter, r *http.Request) {
+	auth, err := s.extractAuthContext(r)
+	if err != nil {
+		httpError(r.Context(), w, "error on getting auth context", err, http.StatusInternalServerError)
+		return
+	}
+	userStorage, err := s.storage.OpenStorage(r.Context(), auth.Email, true)
+	if err != nil {
+		httpError(r.Context(), w, "unable to open user scoped storage", err, http.StatusInternalServerError)
+		return
+	}
+	meta, err := userStorage.GetMetadata(r.Context())
+	if err != nil {
+		httpError(r.Context(), w, "unable to fetch metadata", err, http.StatusInternalServerError)
+		return
+	}
+
+	renderContext := webhooksContext{Events: webhooks.Events}
+	for _, webhook := range meta.Webhooks {
+		renderContext.Webhooks = append(renderContext.Webhooks, webhooksContextWebhook{
+			Id:         webhook.Id,
+			Url:        webhook.Url,
+			Secret:     webhook.Secret,
+			Events:     webhook.Events,
+			CreatedAt:  webhook.CreatedAt,
+			Deliveries: s.webhooks.Deliveries(webhook.Id),
+		})
+	}
+	if auth.IsAdmin {
+		for _, webhook := range s.webhooks.Global() {
+			renderContext.Global = append(renderContext.Global, webhooksContextWebhook{
+				Id:         webhook.Id,
+				Url:        webhook.Url,
+				Events:     webhook.Events,
+				Deliveries: s.webhooks.Deliveries(webhook.Id),
+			})
+		}
+	}
+	webhooksHtmx, err := renderHtmx("component/webhooks", renderContext)
+	if err != nil {
+		httpError(r.Context(), w, "error on render webhooks component", err, http.StatusInternalServerError)
+		return
+	}
+
+	mainContext := s.htmxPrepareMainContext(r)
+	mainContext.ChildComponent = template.HTML(webhooksHtmx.String())
+
+	writeHtmx(w, r, "page/index", mainContext, http.StatusOK)
+}
+
+func (s *httpServer) apiAddWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		httpError(r.Context(), w, "cant parse form", err, http.StatusBadRequest)
+		return
+	}
+	webhookUrl := strings.TrimSpace(r.PostForm.Get("url"))
+	if err := webhooks.ValidateUrl(webhookUrl); err != nil {
+		httpError(r.Context(), w, "invalid webhook url", err, http.StatusBadRequest)
+		return
+	}
+	events := r.PostForm["events"]
+	if err := webhooks.ValidateEvents(events); err != nil {
+		httpError(r.Context(), w, "invalid webhook events", err, http.StatusBadRequest)
+		return
+	}
+	// all events are subscribed when every event is checked, so events added later are sent too
+	if len(events) == len(webhooks.Events) {
+		events = nil
+	}
+	userStorage, meta, ok := s.openWebhooksOwner(w, r)
+	if !ok {
+		return
+	}
+	if len(meta.Webhooks) >= maxWebhooksPerUser {
+		httpError(r.Context(), w, fmt.Sprintf("limit of %d webhooks is reached", maxWebhooksPerUser), fmt.Errorf("too many webhooks"), http.StatusBadRequest)
+		return
+	}
+	webhook := storage.Webhook{
+		Id:        uuid.NewString(),
+		Url:       webhookUrl,
+		Secret:    strings.ReplaceAll(uuid.NewString()+uuid.NewString(), "-", ""),
+		Events:    events,
+		CreatedAt: time.Now(),
+	}
+	if err := userStorage.SetWebhooks(r.Context(), append(meta.Webhooks, webhook)); err != nil {
+		httpError(r.Context(), w, "unable to save webhook", err, http.StatusInternalServerError)
+		return
+	}
+	s.webhooks.Invalidate(meta.Email)
+
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *httpServer) apiDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		httpError(r.Context(), w, "query param 'id' is empty", fmt.Errorf("no id in query"), http.StatusBadRequest)
+		return
+	}
+	userStorage, meta, ok := s.openWebhooksOwner(w, r)
+	if !ok {
+		return
+	}
+	webhooksLeft := slices.DeleteFunc(slices.Clone(meta.Webhooks), func(webhook storage.Webhook) bool {
+		return webhook.Id == id
+	})
+	if len(webhooksLeft) == len(meta.Webhooks) {
+		httpError(r.Context(), w, "webhook not found", fmt.Errorf("webhook '%s' is not found", id), http.StatusNotFound)
+		return
+	}
+	if err := userStorage.SetWebhooks(r.Context(), webhooksLeft); err != nil {
+		httpError(r.Context(), w, "unable to delete webhook", err, http.StatusInternalServerError)
+		return
+	}
+	s.webhooks.Invalidate(meta.Email)
+	s.webhooks.Forget(id)
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(""))
+}
+
+// openWebhooksOwner opens storage of authenticated user, it writes http error on failure
+func (s *httpServer) openWebhooksOwner(w http.ResponseWriter, r *http.Request) (storage.UserScopedStorage, *storage.Metadata, bool) {
+	email, err := s.extractEmail(r)
+	if err != nil {
+		httpError(r.Context(), w, "cant read email from request", err, http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	userStorage, err := s.storage.OpenStorage(r.Context(), email, true)
+	if err != nil {
+		httpError(r.Context(), w, "unable to open user scoped storage", err, http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	meta, err := userStorage.GetMetadata(r.Context())
+	if err != nil {
+		httpError(r.Context(), w, "unable to fetch metadata", err, http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return userStorage, meta, true
+}
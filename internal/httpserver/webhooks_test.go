@@ -0,0 +1,79 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paragor/sharefile/internal/storage"
+	"github.com/paragor/sharefile/internal/webhooks"
+)
+
+func TestShareAccessWebhooksAreLimited(t *testing.T) {
+	lock := sync.Mutex{}
+	var received []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		payload := webhooks.Payload{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("invalid payload %q: %s", body, err)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		received = append(received, payload.Event+" "+payload.Interface+" "+payload.Path)
+	}))
+	defer receiver.Close()
+
+	fakeStorage := newFakeStorage()
+	user := fakeStorage.addUser("user@example.com", map[string]string{"file.txt": "data"})
+	s := newTestServer(t, fakeStorage, nil)
+	s.webhooks = webhooks.NewDispatcher(webhooks.Config{
+		Global:      []storage.Webhook{{Url: receiver.URL}},
+		Timeout:     time.Second,
+		MaxAttempts: 1,
+	}, fakeStorage, 1)
+
+	requests := []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/share/"+user.meta.ShareId, nil),
+		// feed reader polls share again and again
+		httptest.NewRequest(http.MethodGet, "/share/"+user.meta.ShareId, nil),
+		httptest.NewRequest(http.MethodGet, "/rss/"+user.meta.ShareId, nil),
+		httptest.NewRequest(http.MethodGet, "/rss/"+user.meta.ShareId, nil),
+		// failed requests are audited, but not sent
+		httptest.NewRequest(http.MethodGet, "/share/unknown", nil),
+		newTokenRequest(http.MethodPut, "/u/new.txt", "data", "user@example.com", "token"),
+	}
+	other := httptest.NewRequest(http.MethodGet, "/share/"+user.meta.ShareId, nil)
+	other.RemoteAddr = "192.0.2.2:1234"
+	requests = append(requests, other)
+	for _, request := range requests {
+		serve(s, request)
+	}
+	if err := s.webhooks.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	sort.Strings(received)
+	want := []string{
+		"share_access rss ",
+		"share_access web ",
+		"share_access web ",
+		"upload raw new.txt",
+	}
+	if len(received) != len(want) {
+		t.Fatalf("received %q, want %q", received, want)
+	}
+	for i := range want {
+		if received[i] != want[i] {
+			t.Fatalf("received %q, want %q", received, want)
+		}
+	}
+}
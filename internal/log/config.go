@@ -0,0 +1,66 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+const (
+	FormatJson = "json"
+	FormatText = "text"
+)
+
+type Config struct {
+	// Level is one of debug, info, warn or error
+	Level string
+	// Format is FormatJson or FormatText
+	Format string
+	// File is written instead of stderr if it is not empty
+	File string
+	// MaxSizeMb rotates file after this size, 0 disables rotation
+	MaxSizeMb int
+	// MaxBackups is number of rotated files to keep
+	MaxBackups int
+}
+
+// Setup replaces default logger, loggers taken from context before it keep previous output.
+// Invalid config keeps current logger and level
+func Setup(cfg Config) error {
+	var newLevel slog.Level
+	if err := newLevel.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return fmt.Errorf("invalid log level '%s': %w", cfg.Level, err)
+	}
+	if cfg.Format != FormatJson && cfg.Format != FormatText {
+		return fmt.Errorf("unknown log format '%s'", cfg.Format)
+	}
+	var output io.Writer = os.Stderr
+	if cfg.File != "" {
+		file, err := newRotatingFile(cfg.File, int64(cfg.MaxSizeMb)*1024*1024, cfg.MaxBackups)
+		if err != nil {
+			return err
+		}
+		output = file
+	}
+	level.Set(newLevel)
+	options := &slog.HandlerOptions{Level: level}
+	if cfg.Format == FormatText {
+		logInstance = slog.New(slog.NewTextHandler(output, options))
+	} else {
+		logInstance = slog.New(slog.NewJSONHandler(output, options))
+	}
+	return nil
+}
+
+// SetLevel changes level of all loggers at runtime
+func SetLevel(name string) error {
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return fmt.Errorf("invalid log level '%s': %w", name, err)
+	}
+	return nil
+}
+
+func Level() string {
+	return level.Level().String()
+}
@@ -0,0 +1,93 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// keepLogger restores default logger and level changed by test
+func keepLogger(t *testing.T) {
+	t.Helper()
+	instance, previousLevel := logInstance, level.Level()
+	t.Cleanup(func() {
+		logInstance = instance
+		level.Set(previousLevel)
+	})
+}
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		// check validates line of info message
+		check func(line string) bool
+	}{
+		{name: "json", format: FormatJson, check: func(line string) bool {
+			entry := map[string]any{}
+			return json.Unmarshal([]byte(line), &entry) == nil && entry["msg"] == "shown" && entry["level"] == "INFO"
+		}},
+		{name: "text", format: FormatText, check: func(line string) bool {
+			return strings.Contains(line, "level=INFO msg=shown")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keepLogger(t)
+			path := filepath.Join(t.TempDir(), "sharefile.log")
+			if err := Setup(Config{Level: "info", Format: tt.format, File: path}); err != nil {
+				t.Fatal(err)
+			}
+			FromContext(context.Background()).Debug("hidden")
+			FromContext(context.Background()).Info("shown")
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if len(lines) != 1 || !tt.check(lines[0]) {
+				t.Fatalf("log file has %q, want info message only", lines)
+			}
+		})
+	}
+}
+
+func TestSetupInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "invalid level", cfg: Config{Level: "verbose", Format: FormatJson}},
+		{name: "empty level", cfg: Config{Format: FormatJson}},
+		{name: "invalid format", cfg: Config{Level: "debug", Format: "xml"}},
+		{name: "missing directory", cfg: Config{Level: "debug", Format: FormatJson, File: filepath.Join(os.TempDir(), "missing-sharefile-dir", "sharefile.log")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keepLogger(t)
+			level.Set(slog.LevelWarn)
+			instance := logInstance
+			if err := Setup(tt.cfg); err == nil {
+				t.Fatal("invalid config is accepted")
+			}
+			if logInstance != instance || Level() != "WARN" {
+				t.Fatalf("level %s, logger is replaced %t, want previous logger and level", Level(), logInstance != instance)
+			}
+		})
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	keepLogger(t)
+	if err := SetLevel("error"); err != nil || Level() != "ERROR" {
+		t.Fatalf("level %s, %v, want ERROR", Level(), err)
+	}
+	if err := SetLevel("loud"); err == nil || Level() != "ERROR" {
+		t.Fatalf("level %s, %v, want error and previous level", Level(), err)
+	}
+}
@@ -10,7 +10,10 @@ type logContext struct{}
 
 var logContextValue = logContext{}
 
-var logInstance = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+// level is shared by all handlers, so it is changed at runtime by SetLevel
+var level = new(slog.LevelVar)
+
+var logInstance = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
 
 func FromContext(ctx context.Context) *slog.Logger {
 	value := ctx.Value(logContextValue)
@@ -0,0 +1,106 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readLogFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func writeLines(t *testing.T, file *rotatingFile, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if _, err := file.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sharefile.log")
+	// every line is 6 bytes, so every file keeps two lines
+	file, err := newRotatingFile(path, 12, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.file.Close()
+	writeLines(t, file, "line1", "line2", "line3", "line4", "line5", "line6", "line7")
+
+	want := map[string]string{
+		path:        "line7\n",
+		path + ".1": "line5\nline6\n",
+		path + ".2": "line3\nline4\n",
+	}
+	for name, content := range want {
+		if got := readLogFile(t, name); got != content {
+			t.Fatalf("%s has %q, want %q", filepath.Base(name), got, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("backup over max backups exists: %v", err)
+	}
+}
+
+func TestRotatingFileWithoutBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sharefile.log")
+	file, err := newRotatingFile(path, 12, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.file.Close()
+	writeLines(t, file, "line1", "line2", "line3")
+
+	if got := readLogFile(t, path); got != "line3\n" {
+		t.Fatalf("log file has %q, want only line after rotation", got)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("directory has %d files, want log file only", len(entries))
+	}
+}
+
+func TestRotatingFileKeepsExistingSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sharefile.log")
+	if err := os.WriteFile(path, []byte("old01\nold02\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// file is reopened after restart, so its size counts towards limit
+	file, err := newRotatingFile(path, 12, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.file.Close()
+	writeLines(t, file, "line1")
+
+	if got := readLogFile(t, path+".1"); got != "old01\nold02\n" {
+		t.Fatalf("backup has %q, want previous content", got)
+	}
+	if got := readLogFile(t, path); got != "line1\n" {
+		t.Fatalf("log file has %q, want new line", got)
+	}
+}
+
+func TestRotatingFileWithoutLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sharefile.log")
+	file, err := newRotatingFile(path, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.file.Close()
+	writeLines(t, file, strings.Repeat("x", 100), strings.Repeat("y", 100))
+	if got := readLogFile(t, path); len(got) != 202 {
+		t.Fatalf("log file has %d bytes, want all lines without rotation", len(got))
+	}
+}
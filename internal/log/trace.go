@@ -0,0 +1,21 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PutTraceIntoContext adds trace and span ids of context span to context logger
+func PutTraceIntoContext(ctx context.Context) context.Context {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return ctx
+	}
+	logger := FromContext(ctx).With(
+		slog.String("trace_id", spanContext.TraceID().String()),
+		slog.String("span_id", spanContext.SpanID().String()),
+	)
+	return PutIntoContext(ctx, logger)
+}
@@ -0,0 +1,87 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// encryptedTokenPrefix marks encrypted tokens, sessions saved before encryption keep plain tokens until they expire
+const encryptedTokenPrefix = "enc:v1:"
+
+// tokenCipher encrypts oidc tokens of sessions in shared stores, so read access to bucket or redis does not leak them
+type tokenCipher struct {
+	aead cipher.AEAD
+}
+
+// newTokenCipher derives aes-256 key from key of any length, e.g. cookie key
+func newTokenCipher(key string) *tokenCipher {
+	sum := sha256.Sum256([]byte("sharefile sessions\x00" + key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		panic(fmt.Errorf("cant create session cipher: %w", err))
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(fmt.Errorf("cant create session cipher: %w", err))
+	}
+	return &tokenCipher{aead: aead}
+}
+
+func (c *tokenCipher) encrypt(sessionId string, token string) string {
+	if token == "" {
+		return ""
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Errorf("cant generate session nonce: %w", err))
+	}
+	// session id is authenticated, so token can not be moved into another session
+	sealed := c.aead.Seal(nonce, nonce, []byte(token), []byte(sessionId))
+	return encryptedTokenPrefix + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+func (c *tokenCipher) decrypt(sessionId string, token string) (string, error) {
+	encoded, ok := strings.CutPrefix(token, encryptedTokenPrefix)
+	if !ok {
+		return token, nil
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("cant decode encrypted token: %w", err)
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return "", fmt.Errorf("encrypted token is too short")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, ciphertext, []byte(sessionId))
+	if err != nil {
+		return "", fmt.Errorf("cant decrypt token: %w", err)
+	}
+	return string(plain), nil
+}
+
+func (c *tokenCipher) marshal(session *Session) ([]byte, error) {
+	encrypted := *session
+	encrypted.IdToken = c.encrypt(session.Id, session.IdToken)
+	encrypted.RefreshToken = c.encrypt(session.Id, session.RefreshToken)
+	return marshal(&encrypted)
+}
+
+func (c *tokenCipher) unmarshal(data []byte) (*Session, error) {
+	session, err := unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if session.IdToken, err = c.decrypt(session.Id, session.IdToken); err != nil {
+		return nil, fmt.Errorf("cant decrypt id token of session: %w", err)
+	}
+	if session.RefreshToken, err = c.decrypt(session.Id, session.RefreshToken); err != nil {
+		return nil, fmt.Errorf("cant decrypt refresh token of session: %w", err)
+	}
+	return session, nil
+}
@@ -0,0 +1,48 @@
+package sessions
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTokenCipher(t *testing.T) {
+	session := &Session{Id: NewId(), Email: "user@example.com", IdToken: "id-token", RefreshToken: "refresh-token"}
+	stored, err := newTokenCipher("key").marshal(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := marshal(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		key     string
+		wantErr bool
+	}{
+		{name: "encrypted", data: stored, key: "key"},
+		{name: "saved before encryption", data: plain, key: "key"},
+		{name: "another key", data: stored, key: "another", wantErr: true},
+		{name: "moved into another session", data: bytes.Replace(stored, []byte(session.Id), []byte(NewId()), 1), key: "key", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTokenCipher(tt.key).unmarshal(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.IdToken != session.IdToken || got.RefreshToken != session.RefreshToken {
+				t.Fatalf("tokens %q %q, want %q %q", got.IdToken, got.RefreshToken, session.IdToken, session.RefreshToken)
+			}
+		})
+	}
+	for _, token := range []string{session.IdToken, session.RefreshToken} {
+		if bytes.Contains(stored, []byte(token)) {
+			t.Fatalf("stored session contains plain token %q", token)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package sessions
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// memoryStore keeps sessions in process memory, so they are lost on restart and not shared between replicas
+type memoryStore struct {
+	lock     sync.Mutex
+	sessions map[string]*Session
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{sessions: map[string]*Session{}}
+}
+
+func (m *memoryStore) Save(_ context.Context, session *Session) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.purgeExpired()
+	copied := *session
+	m.sessions[session.Id] = &copied
+	return nil
+}
+
+func (m *memoryStore) Get(_ context.Context, id string) (*Session, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	session, ok := m.sessions[id]
+	if !ok || session.Expired() {
+		return nil, ErrNotFound
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (m *memoryStore) Delete(_ context.Context, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *memoryStore) List(_ context.Context, index string) ([]*Session, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.purgeExpired()
+	result := []*Session{}
+	for _, session := range m.sessions {
+		if slices.Contains(session.indexes(), index) {
+			copied := *session
+			result = append(result, &copied)
+		}
+	}
+	sortSessions(result)
+	return result, nil
+}
+
+func (m *memoryStore) Count(_ context.Context) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.purgeExpired()
+	return len(m.sessions), nil
+}
+
+func (m *memoryStore) purgeExpired() {
+	for id, session := range m.sessions {
+		if session.Expired() {
+			delete(m.sessions, id)
+		}
+	}
+}
+
+func sortSessions(list []*Session) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[j].CreatedAt.Before(list[i].CreatedAt)
+	})
+}
@@ -0,0 +1,346 @@
+package sessions
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RedisConfig struct {
+	Address  string
+	Password string
+	DB       int
+	// KeyPrefix allows to share redis with other applications
+	KeyPrefix string
+	// PoolSize limits open connections, requests wait for free one, zero means defaultRedisPoolSize
+	PoolSize int
+}
+
+const defaultRedisPoolSize = 10
+
+// redisStore keeps session under its own key with ttl and session ids of every index in a set.
+// It speaks plain RESP over a small pool of connections and works with any redis compatible server.
+type redisStore struct {
+	cfg     RedisConfig
+	timeout time.Duration
+	cipher  *tokenCipher
+
+	// slots limits open connections, idle keeps connections for reuse
+	slots chan struct{}
+	idle  chan *redisConn
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisStore encrypts oidc tokens of sessions by key derived from encryptionKey
+func NewRedisStore(cfg RedisConfig, encryptionKey string) Store {
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = defaultRedisPoolSize
+	}
+	return &redisStore{
+		cfg:     cfg,
+		timeout: 5 * time.Second,
+		cipher:  newTokenCipher(encryptionKey),
+		slots:   make(chan struct{}, cfg.PoolSize),
+		idle:    make(chan *redisConn, cfg.PoolSize),
+	}
+}
+
+var errRedisNil = errors.New("redis nil reply")
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (r *redisStore) sessionKey(id string) string {
+	return r.cfg.KeyPrefix + "session:" + id
+}
+
+func (r *redisStore) indexKey(index string) string {
+	return r.cfg.KeyPrefix + "sessions:" + index
+}
+
+func (r *redisStore) Save(ctx context.Context, session *Session) error {
+	ttl := time.Until(session.ExpireAt)
+	if ttl <= 0 {
+		return r.Delete(ctx, session.Id)
+	}
+	data, err := r.cipher.marshal(session)
+	if err != nil {
+		return err
+	}
+	ttlMs := strconv.FormatInt(ttl.Milliseconds()+1, 10)
+	if _, err := r.do(ctx, "SET", r.sessionKey(session.Id), string(data), "PX", ttlMs); err != nil {
+		return fmt.Errorf("cant save session: %w", err)
+	}
+	for _, index := range session.indexes() {
+		if _, err := r.do(ctx, "SADD", r.indexKey(index), session.Id); err != nil {
+			return fmt.Errorf("cant save session index: %w", err)
+		}
+		// index lives as long as the longest session, expired ids are removed on listing
+		pttl, err := r.do(ctx, "PTTL", r.indexKey(index))
+		if err != nil {
+			return fmt.Errorf("cant read session index ttl: %w", err)
+		}
+		if current, _ := pttl.(int64); current < ttl.Milliseconds() {
+			if _, err := r.do(ctx, "PEXPIRE", r.indexKey(index), ttlMs); err != nil {
+				return fmt.Errorf("cant extend session index ttl: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *redisStore) Get(ctx context.Context, id string) (*Session, error) {
+	reply, err := r.do(ctx, "GET", r.sessionKey(id))
+	if errors.Is(err, errRedisNil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cant read session: %w", err)
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected redis reply type %T", reply)
+	}
+	session, err := r.cipher.unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if session.Expired() {
+		return nil, ErrNotFound
+	}
+	return session, nil
+}
+
+func (r *redisStore) Delete(ctx context.Context, id string) error {
+	session, err := r.Get(ctx, id)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := r.do(ctx, "DEL", r.sessionKey(id)); err != nil {
+		return fmt.Errorf("cant delete session: %w", err)
+	}
+	for _, index := range session.indexes() {
+		if _, err := r.do(ctx, "SREM", r.indexKey(index), id); err != nil {
+			return fmt.Errorf("cant delete session index: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *redisStore) List(ctx context.Context, index string) ([]*Session, error) {
+	reply, err := r.do(ctx, "SMEMBERS", r.indexKey(index))
+	if err != nil {
+		return nil, fmt.Errorf("cant list sessions: %w", err)
+	}
+	members, _ := reply.([]any)
+	result := make([]*Session, 0, len(members))
+	for _, member := range members {
+		id, _ := member.([]byte)
+		session, err := r.Get(ctx, string(id))
+		if err == ErrNotFound {
+			if _, err := r.do(ctx, "SREM", r.indexKey(index), string(id)); err != nil {
+				return nil, fmt.Errorf("cant delete stale session index: %w", err)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, session)
+	}
+	sortSessions(result)
+	return result, nil
+}
+
+// Count scans session keys, sessions expire by redis ttl, so they are never stale
+func (r *redisStore) Count(ctx context.Context) (int, error) {
+	pattern := redisGlobEscaper.Replace(r.sessionKey("")) + "*"
+	count := 0
+	cursor := "0"
+	for {
+		reply, err := r.do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+		if err != nil {
+			return 0, fmt.Errorf("cant scan sessions: %w", err)
+		}
+		items, _ := reply.([]any)
+		if len(items) != 2 {
+			return 0, fmt.Errorf("unexpected redis scan reply %v", reply)
+		}
+		next, _ := items[0].([]byte)
+		keys, _ := items[1].([]any)
+		count += len(keys)
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return count, nil
+		}
+	}
+}
+
+var redisGlobEscaper = strings.NewReplacer("\\", "\\\\", "*", "\\*", "?", "\\?", "[", "\\[", "]", "\\]")
+
+// do sends command and returns reply: []byte, int64, string or []any. Nil reply is errRedisNil.
+func (r *redisStore) do(ctx context.Context, args ...string) (any, error) {
+	select {
+	case r.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("cant get redis connection: %w", ctx.Err())
+	}
+	defer func() { <-r.slots }()
+
+	for {
+		conn, reused, err := r.getConn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := conn.do(ctx, r.timeout, args)
+		if !isRedisConnError(err) {
+			r.putConn(conn)
+			return reply, err
+		}
+		// connection state is unknown after io error
+		_ = conn.conn.Close()
+		// idle connection may be closed by server or proxy, then command is repeated on another one,
+		// commands of store are idempotent
+		if !reused || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+}
+
+// isRedisConnError reports error, after which connection can not be reused
+func isRedisConnError(err error) bool {
+	var replyErr redisError
+	return err != nil && !errors.Is(err, errRedisNil) && !errors.As(err, &replyErr)
+}
+
+func (r *redisStore) getConn(ctx context.Context) (*redisConn, bool, error) {
+	select {
+	case conn := <-r.idle:
+		return conn, true, nil
+	default:
+	}
+	conn, err := r.connect(ctx)
+	return conn, false, err
+}
+
+func (r *redisStore) putConn(conn *redisConn) {
+	select {
+	case r.idle <- conn:
+	default:
+		_ = conn.conn.Close()
+	}
+}
+
+func (r *redisStore) connect(ctx context.Context) (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: r.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", r.cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("cant connect to redis: %w", err)
+	}
+	result := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+	if r.cfg.Password != "" {
+		if _, err := result.do(ctx, r.timeout, []string{"AUTH", r.cfg.Password}); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("cant auth in redis: %w", err)
+		}
+	}
+	if r.cfg.DB != 0 {
+		if _, err := result.do(ctx, r.timeout, []string{"SELECT", strconv.Itoa(r.cfg.DB)}); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("cant select redis db: %w", err)
+		}
+	}
+	return result, nil
+}
+
+func (c *redisConn) do(ctx context.Context, timeout time.Duration, args []string) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(timeout)
+	}
+	_ = c.conn.SetDeadline(deadline)
+
+	command := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		command = append(command, "$"+strconv.Itoa(len(arg))+"\r\n"+arg+"\r\n"...)
+	}
+	if _, err := c.conn.Write(command); err != nil {
+		return nil, fmt.Errorf("cant write redis command: %w", err)
+	}
+	return readRedisReply(c.reader)
+}
+
+// readRedisReply reads whole reply even if it contains errors, so connection stays in sync with server
+func readRedisReply(reader *bufio.Reader) (any, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("cant read redis reply: %w", err)
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("invalid redis reply '%s'", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis bulk size: %w", err)
+		}
+		if size < 0 {
+			return nil, errRedisNil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, fmt.Errorf("cant read redis bulk reply: %w", err)
+		}
+		return data[:size], nil
+	case '*':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis array size: %w", err)
+		}
+		if size < 0 {
+			return nil, errRedisNil
+		}
+		items := make([]any, 0, size)
+		var itemErr error
+		for i := 0; i < size; i++ {
+			item, err := readRedisReply(reader)
+			if isRedisConnError(err) {
+				return nil, err
+			}
+			if err != nil && !errors.Is(err, errRedisNil) && itemErr == nil {
+				itemErr = err
+			}
+			items = append(items, item)
+		}
+		if itemErr != nil {
+			return nil, itemErr
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown redis reply type '%c'", kind)
+	}
+}
@@ -0,0 +1,373 @@
+package sessions
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is in-memory redis compatible server, it implements only commands used by store
+type fakeRedis struct {
+	listener net.Listener
+
+	lock     sync.Mutex
+	values   map[string][]byte
+	sets     map[string]map[string]bool
+	expireAt map[string]time.Time
+	conns    map[net.Conn]bool
+	// dials counts accepted connections, active counts connections which are processing command
+	dials     int
+	active    int
+	maxActive int
+	// delay makes commands slow, so concurrent requests overlap
+	delay time.Duration
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeRedis{
+		listener: listener,
+		values:   map[string][]byte{},
+		sets:     map[string]map[string]bool{},
+		expireAt: map[string]time.Time{},
+		conns:    map[net.Conn]bool{},
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+		fake.dropConnections()
+	})
+	go fake.serve()
+	return fake
+}
+
+func (f *fakeRedis) address() string {
+	return f.listener.Addr().String()
+}
+
+// dropConnections closes connections as redis does on restart or idle timeout
+func (f *fakeRedis) dropConnections() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for conn := range f.conns {
+		_ = conn.Close()
+	}
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.lock.Lock()
+		f.dials++
+		f.conns[conn] = true
+		f.lock.Unlock()
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readFakeRedisCommand(reader)
+		if err != nil {
+			return
+		}
+		f.lock.Lock()
+		f.active++
+		f.maxActive = max(f.maxActive, f.active)
+		delay := f.delay
+		f.lock.Unlock()
+		time.Sleep(delay)
+
+		f.lock.Lock()
+		reply := f.execute(args)
+		f.active--
+		f.lock.Unlock()
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func readFakeRedisCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, size)
+	for range size {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args = append(args, string(data[:length]))
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) expire(key string) {
+	if at, ok := f.expireAt[key]; ok && time.Now().After(at) {
+		delete(f.values, key)
+		delete(f.sets, key)
+		delete(f.expireAt, key)
+	}
+}
+
+func (f *fakeRedis) execute(args []string) string {
+	if len(args) > 1 {
+		f.expire(args[1])
+	}
+	switch strings.ToUpper(args[0]) {
+	case "SET":
+		f.values[args[1]] = []byte(args[2])
+		delete(f.expireAt, args[1])
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			ms, _ := strconv.Atoi(args[4])
+			f.expireAt[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "GET":
+		value, ok := f.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fakeRedisBulk(string(value))
+	case "DEL":
+		_, ok := f.values[args[1]]
+		delete(f.values, args[1])
+		delete(f.expireAt, args[1])
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "SADD":
+		if f.sets[args[1]] == nil {
+			f.sets[args[1]] = map[string]bool{}
+		}
+		f.sets[args[1]][args[2]] = true
+		return ":1\r\n"
+	case "SREM":
+		delete(f.sets[args[1]], args[2])
+		return ":1\r\n"
+	case "SMEMBERS":
+		reply := "*" + strconv.Itoa(len(f.sets[args[1]])) + "\r\n"
+		for member := range f.sets[args[1]] {
+			reply += fakeRedisBulk(member)
+		}
+		return reply
+	case "PTTL":
+		at, ok := f.expireAt[args[1]]
+		if !ok {
+			return ":-1\r\n"
+		}
+		return ":" + strconv.FormatInt(time.Until(at).Milliseconds(), 10) + "\r\n"
+	case "PEXPIRE":
+		ms, _ := strconv.Atoi(args[2])
+		f.expireAt[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return ":1\r\n"
+	case "SCAN":
+		var keys []string
+		for key := range f.values {
+			f.expire(key)
+			if _, ok := f.values[key]; !ok {
+				continue
+			}
+			if ok, _ := path.Match(args[3], key); ok {
+				keys = append(keys, key)
+			}
+		}
+		reply := "*2\r\n" + fakeRedisBulk("0") + "*" + strconv.Itoa(len(keys)) + "\r\n"
+		for _, key := range keys {
+			reply += fakeRedisBulk(key)
+		}
+		return reply
+	default:
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	}
+}
+
+func fakeRedisBulk(value string) string {
+	return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+}
+
+func newTestRedisStore(t *testing.T, fake *fakeRedis, poolSize int) *redisStore {
+	t.Helper()
+	return NewRedisStore(RedisConfig{Address: fake.address(), KeyPrefix: "test:", PoolSize: poolSize}, "key").(*redisStore)
+}
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeRedis(t)
+	store := newTestRedisStore(t, fake, 2)
+	now := time.Now()
+	first := &Session{Id: NewId(), Email: "user@example.com", RefreshToken: "token", CreatedAt: now.Add(-time.Minute), ExpireAt: now.Add(time.Hour)}
+	second := &Session{Id: NewId(), Email: "user@example.com", CreatedAt: now, ExpireAt: now.Add(time.Hour)}
+	other := &Session{Id: NewId(), Email: "other@example.com", CreatedAt: now, ExpireAt: now.Add(time.Hour)}
+	for _, session := range []*Session{first, second, other} {
+		if err := store.Save(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := store.Get(ctx, first.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RefreshToken != "token" {
+		t.Fatalf("refresh token %q, want token", got.RefreshToken)
+	}
+	if _, err := store.Get(ctx, NewId()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("error %v for unknown session, want ErrNotFound", err)
+	}
+	listed, err := store.List(ctx, IndexEmail("user@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 || listed[0].Id != second.Id || listed[1].Id != first.Id {
+		t.Fatalf("listed %d sessions, want both sessions of user, newest first", len(listed))
+	}
+	if count, err := store.Count(ctx); err != nil || count != 3 {
+		t.Fatalf("count %d, %v, want 3", count, err)
+	}
+
+	if err := store.Delete(ctx, first.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, first.Id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("error %v for deleted session, want ErrNotFound", err)
+	}
+	if count, err := store.Count(ctx); err != nil || count != 2 {
+		t.Fatalf("count %d, %v after delete, want 2", count, err)
+	}
+}
+
+func TestRedisStorePool(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeRedis(t)
+	fake.delay = 20 * time.Millisecond
+	store := newTestRedisStore(t, fake, 3)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.Get(ctx, NewId()); !errors.Is(err, ErrNotFound) {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	if fake.maxActive < 2 {
+		t.Fatalf("%d commands were served at once, want concurrent connections", fake.maxActive)
+	}
+	if fake.dials > 3 {
+		t.Fatalf("opened %d connections, want at most pool size 3", fake.dials)
+	}
+}
+
+func TestRedisStoreRedialsDroppedConnection(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeRedis(t)
+	store := newTestRedisStore(t, fake, 2)
+	session := &Session{Id: NewId(), Email: "user@example.com", CreatedAt: time.Now(), ExpireAt: time.Now().Add(time.Hour)}
+	if err := store.Save(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.dropConnections()
+	if _, err := store.Get(ctx, session.Id); err != nil {
+		t.Fatalf("cant get session after connection is dropped: %s", err)
+	}
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	if fake.dials != 2 {
+		t.Fatalf("opened %d connections, want 2", fake.dials)
+	}
+}
+
+func TestRedisStoreUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	_ = listener.Close()
+	store := NewRedisStore(RedisConfig{Address: address}, "key")
+	if _, err := store.Get(context.Background(), NewId()); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("error %v, want connection error", err)
+	}
+}
+
+func TestReadRedisReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    string
+		wantErr string
+	}{
+		{name: "status", reply: "+OK\r\n", want: "OK"},
+		{name: "error", reply: "-ERR wrong type\r\n", wantErr: "redis: ERR wrong type"},
+		{name: "integer", reply: ":42\r\n", want: "42"},
+		{name: "bulk", reply: "$5\r\nhello\r\n", want: "[104 101 108 108 111]"},
+		{name: "nil bulk", reply: "$-1\r\n", wantErr: errRedisNil.Error()},
+		{name: "array with nil", reply: "*2\r\n$1\r\na\r\n$-1\r\n", want: "[[97] <nil>]"},
+		{name: "array with error", reply: "*3\r\n$1\r\na\r\n-ERR failed\r\n:1\r\n", wantErr: "redis: ERR failed"},
+		{name: "nested array with error", reply: "*2\r\n*2\r\n-ERR failed\r\n:1\r\n:2\r\n", wantErr: "redis: ERR failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// next reply must be read intact, so connection is still in sync after errors
+			reader := bufio.NewReader(strings.NewReader(tt.reply + "+NEXT\r\n"))
+			reply, err := readRedisReply(reader)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error %v, want %s", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got := fmt.Sprint(reply); got != tt.want {
+				t.Fatalf("reply %s, want %s", got, tt.want)
+			}
+			next, err := readRedisReply(reader)
+			if err != nil || next != "NEXT" {
+				t.Fatalf("next reply %v, %v, want NEXT", next, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/paragor/sharefile/internal/log"
+)
+
+// s3Prefix starts with underscore, so it never collides with user directories
+const s3Prefix = "_sessions/"
+
+// s3ExpirePrefix holds empty marker of every session, key contains expiration time, so markers are listed in its order
+const s3ExpirePrefix = s3Prefix + "expire/"
+
+// s3Store keeps sessions as objects in storage bucket, it is shared between replicas without extra infrastructure.
+// Each session has an empty object under prefix of every index to look up sessions and expiration marker.
+type s3Store struct {
+	client *s3.S3
+	bucket string
+	cipher *tokenCipher
+}
+
+// NewS3Store encrypts oidc tokens of sessions by key derived from encryptionKey,
+// expired sessions are deleted every purgeInterval, zero disables purge
+func NewS3Store(client *s3.S3, bucket string, encryptionKey string, purgeInterval time.Duration) Store {
+	s := &s3Store{client: client, bucket: bucket, cipher: newTokenCipher(encryptionKey)}
+	if purgeInterval > 0 {
+		go s.purgeLoop(purgeInterval)
+	}
+	return s
+}
+
+func (s *s3Store) sessionKey(id string) string {
+	return s3Prefix + "id/" + id + ".json"
+}
+
+func (s *s3Store) indexPrefix(index string) string {
+	return s3Prefix + "index/" + index + "/"
+}
+
+func (s *s3Store) expireKey(session *Session) string {
+	return s3ExpirePrefix + s3ExpireTime(session.ExpireAt) + "/" + session.Id
+}
+
+// s3ExpireTime is zero padded, so lexical order of keys is order of time
+func s3ExpireTime(t time.Time) string {
+	return fmt.Sprintf("%012d", max(t.Unix(), 0))
+}
+
+func (s *s3Store) Save(ctx context.Context, session *Session) error {
+	data, err := s.cipher.marshal(session)
+	if err != nil {
+		return err
+	}
+	if _, err := s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.sessionKey(session.Id)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}); err != nil {
+		return fmt.Errorf("cant upload session: %w", err)
+	}
+	for _, index := range session.indexes() {
+		if _, err := s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.indexPrefix(index) + session.Id),
+			Body:   bytes.NewReader(nil),
+		}); err != nil {
+			return fmt.Errorf("cant upload session index: %w", err)
+		}
+	}
+	return s.putExpireMarker(ctx, session)
+}
+
+func (s *s3Store) putExpireMarker(ctx context.Context, session *Session) error {
+	if _, err := s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.expireKey(session)),
+		Body:   bytes.NewReader(nil),
+	}); err != nil {
+		return fmt.Errorf("cant upload session expiration marker: %w", err)
+	}
+	return nil
+}
+
+func (s *s3Store) Get(ctx context.Context, id string) (*Session, error) {
+	obj, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.sessionKey(id)),
+	})
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("cant read session from s3: %w", err)
+	}
+	defer obj.Body.Close()
+	data, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cant read session from s3: %w", err)
+	}
+	session, err := s.cipher.unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if session.Expired() {
+		if err := s.delete(ctx, session); err != nil {
+			return nil, fmt.Errorf("cant delete expired session: %w", err)
+		}
+		return nil, ErrNotFound
+	}
+	return session, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, id string) error {
+	session, err := s.Get(ctx, id)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.delete(ctx, session)
+}
+
+func (s *s3Store) delete(ctx context.Context, session *Session) error {
+	keys := []string{s.sessionKey(session.Id)}
+	for _, index := range session.indexes() {
+		keys = append(keys, s.indexPrefix(index)+session.Id)
+	}
+	// marker is deleted last, so purge finds session if deletion is interrupted
+	keys = append(keys, s.expireKey(session))
+	for _, key := range keys {
+		if err := s.deleteObject(ctx, key); err != nil {
+			return fmt.Errorf("cant delete session: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *s3Store) deleteObject(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s *s3Store) List(ctx context.Context, index string) ([]*Session, error) {
+	var ids []string
+	prefix := s.indexPrefix(index)
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range output.Contents {
+			ids = append(ids, strings.TrimPrefix(aws.StringValue(obj.Key), prefix))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cant list sessions: %w", err)
+	}
+
+	result := make([]*Session, 0, len(ids))
+	for _, id := range ids {
+		session, err := s.Get(ctx, id)
+		if err == ErrNotFound {
+			// index of expired or half deleted session
+			if err := s.deleteObject(ctx, prefix+id); err != nil {
+				return nil, fmt.Errorf("cant delete stale session index: %w", err)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, session)
+	}
+	sortSessions(result)
+	return result, nil
+}
+
+// Count lists expiration markers of active sessions, sessions saved without marker are counted after purge marks them
+func (s *s3Store) Count(ctx context.Context) (int, error) {
+	count := 0
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:     aws.String(s.bucket),
+		Prefix:     aws.String(s3ExpirePrefix),
+		StartAfter: aws.String(s3ExpirePrefix + s3ExpireTime(time.Now())),
+	}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+		count += len(output.Contents)
+		return true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("cant list sessions: %w", err)
+	}
+	return count, nil
+}
+
+func (s *s3Store) purgeLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := s.purge(ctx); err != nil {
+			log.FromContext(ctx).With(log.Error(err)).Error("cant purge expired sessions")
+		}
+		cancel()
+	}
+}
+
+// purge deletes expired sessions by their markers, sessions saved before markers were introduced get one.
+// Replicas purge concurrently, deletes are idempotent.
+func (s *s3Store) purge(ctx context.Context) error {
+	now := s3ExpirePrefix + s3ExpireTime(time.Now())
+	marked := map[string]bool{}
+	var expired []string
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s3ExpirePrefix),
+	}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range output.Contents {
+			key := aws.StringValue(obj.Key)
+			marked[path.Base(key)] = true
+			if key < now {
+				expired = append(expired, key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("cant list session expiration markers: %w", err)
+	}
+	for _, key := range expired {
+		// expired session is deleted with its indexes and marker on read
+		if _, err := s.Get(ctx, path.Base(key)); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		// session may be deleted before, then only marker is left
+		if err := s.deleteObject(ctx, key); err != nil {
+			return fmt.Errorf("cant delete session expiration marker: %w", err)
+		}
+	}
+
+	var unmarked []string
+	err = s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s3Prefix + "id/"),
+	}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range output.Contents {
+			id := strings.TrimSuffix(path.Base(aws.StringValue(obj.Key)), ".json")
+			if !marked[id] {
+				unmarked = append(unmarked, id)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("cant list sessions: %w", err)
+	}
+	for _, id := range unmarked {
+		session, err := s.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.putExpireMarker(ctx, session); err != nil {
+			return err
+		}
+	}
+	log.FromContext(ctx).With(slog.Int("expired", len(expired)), slog.Int("marked", len(unmarked))).Debug("expired sessions are purged")
+	return nil
+}
+
+func isS3NotFound(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	// some s3 compatible storages respond to missing key with bare http status, which sdk reports as NotFound
+	return awsErr.Code() == s3.ErrCodeNoSuchKey || awsErr.Code() == "NotFound"
+}
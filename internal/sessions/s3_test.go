@@ -0,0 +1,165 @@
+package sessions
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const fakeS3Bucket = "bucket"
+
+// fakeS3 is in-memory s3 bucket, it implements only requests used by store
+type fakeS3 struct {
+	lock sync.Mutex
+	objs map[string][]byte
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *s3.S3) {
+	fake := &fakeS3{objs: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	cfg := aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("access", "secret", "")).
+		WithEndpoint(server.URL).
+		WithRegion("us-east-1").
+		WithS3ForcePathStyle(true)
+	return fake, s3.New(session.Must(session.NewSession(cfg)))
+}
+
+func (f *fakeS3) keys(prefix string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var keys []string
+	for key := range f.objs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+fakeS3Bucket), "/")
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		type content struct {
+			Key string `xml:"Key"`
+		}
+		response := struct {
+			XMLName  xml.Name  `xml:"ListBucketResult"`
+			Contents []content `xml:"Contents"`
+		}{}
+		var keys []string
+		for key := range f.objs {
+			if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("start-after") {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			response.Contents = append(response.Contents, content{Key: key})
+		}
+		_ = xml.NewEncoder(w).Encode(response)
+	case r.Method == http.MethodGet:
+		data, ok := f.objs[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+		_, _ = w.Write(data)
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objs[key] = data
+	case r.Method == http.MethodDelete:
+		delete(f.objs, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestIsS3NotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "no such key", err: awserr.New(s3.ErrCodeNoSuchKey, "missing", nil), want: true},
+		{name: "bare not found status", err: awserr.New("NotFound", "missing", nil), want: true},
+		{name: "access denied", err: awserr.New("AccessDenied", "denied", nil)},
+		{name: "network error", err: errors.New("connection refused")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isS3NotFound(tt.err); got != tt.want {
+				t.Fatalf("isS3NotFound %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestS3StoreCountAndPurge(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFakeS3(t)
+	store := NewS3Store(client, fakeS3Bucket, "key", 0).(*s3Store)
+	now := time.Now()
+	active := &Session{Id: NewId(), Email: "user@example.com", CreatedAt: now, ExpireAt: now.Add(time.Hour)}
+	expired := &Session{Id: NewId(), Email: "user@example.com", OidcProvider: "idp", OidcSid: "sid", CreatedAt: now.Add(-2 * time.Hour), ExpireAt: now.Add(-time.Hour)}
+	for _, session := range []*Session{active, expired} {
+		if err := store.Save(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// session saved before expiration markers were introduced
+	legacy := &Session{Id: NewId(), Email: "other@example.com", CreatedAt: now, ExpireAt: now.Add(time.Hour)}
+	if err := store.Save(ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+	fake.lock.Lock()
+	delete(fake.objs, store.expireKey(legacy))
+	fake.lock.Unlock()
+
+	if count, err := store.Count(ctx); err != nil || count != 1 {
+		t.Fatalf("count %d, %v before purge, want only active session with marker", count, err)
+	}
+	if err := store.purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := store.Count(ctx); err != nil || count != 2 {
+		t.Fatalf("count %d, %v after purge, want 2", count, err)
+	}
+	for _, key := range fake.keys(s3Prefix) {
+		if strings.Contains(key, expired.Id) {
+			t.Fatalf("object %s of expired session is not purged", key)
+		}
+	}
+	if _, err := store.Get(ctx, active.Id); err != nil {
+		t.Fatalf("active session is purged: %s", err)
+	}
+
+	if err := store.Delete(ctx, active.Id); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := store.Count(ctx); err != nil || count != 1 {
+		t.Fatalf("count %d, %v after delete, want 1", count, err)
+	}
+}
@@ -0,0 +1,121 @@
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNotFound = errors.New("session not found")
+
+const (
+	MethodLocal = "local"
+	MethodOidc  = "oidc"
+)
+
+// Session is server side login state, browser keeps only its id in signed cookie
+type Session struct {
+	Id     string   `json:"id"`
+	Email  string   `json:"email"`
+	Groups []string `json:"groups,omitempty"`
+	// Method is MethodLocal or MethodOidc
+	Method       string `json:"method"`
+	OidcProvider string `json:"oidc_provider,omitempty"`
+	IdToken      string `json:"id_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// OidcSubject and OidcSid identify session in back-channel logout
+	OidcSubject string `json:"oidc_subject,omitempty"`
+	OidcSid     string `json:"oidc_sid,omitempty"`
+	// OidcNonce is checked in id tokens received on refresh
+	OidcNonce string `json:"oidc_nonce,omitempty"`
+	// IdTokenExtendedUntil is set if idp refreshed tokens without new id token,
+	// claims of kept id token are trusted until refreshed access token expires
+	IdTokenExtendedUntil time.Time `json:"id_token_extended_until,omitempty"`
+
+	UserAgent  string    `json:"user_agent,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpireAt   time.Time `json:"expire_at"`
+}
+
+func (s *Session) Expired() bool {
+	return time.Now().After(s.ExpireAt)
+}
+
+// IndexEmail, IndexOidcSubject and IndexOidcSid are keys to look up sessions
+func IndexEmail(email string) string {
+	return "email/" + email
+}
+
+func IndexOidcSubject(provider string, subject string) string {
+	return "oidc-sub/" + provider + "/" + subject
+}
+
+func IndexOidcSid(provider string, sid string) string {
+	return "oidc-sid/" + provider + "/" + sid
+}
+
+func (s *Session) indexes() []string {
+	result := []string{IndexEmail(s.Email)}
+	if s.OidcSubject != "" {
+		result = append(result, IndexOidcSubject(s.OidcProvider, s.OidcSubject))
+	}
+	if s.OidcSid != "" {
+		result = append(result, IndexOidcSid(s.OidcProvider, s.OidcSid))
+	}
+	return result
+}
+
+type Store interface {
+	// Save creates or replaces session until its ExpireAt
+	Save(ctx context.Context, session *Session) error
+	// Get returns ErrNotFound if session does not exist or expired
+	Get(ctx context.Context, id string) (*Session, error)
+	Delete(ctx context.Context, id string) error
+	// List returns active sessions by index key, sorted by creation time desc
+	List(ctx context.Context, index string) ([]*Session, error)
+	// Count returns number of active sessions
+	Count(ctx context.Context) (int, error)
+}
+
+func NewId() string {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Errorf("cant generate session id: %w", err))
+	}
+	return hex.EncodeToString(id)
+}
+
+// DeleteByIndex logs out all sessions by index key, e.g. user everywhere
+func DeleteByIndex(ctx context.Context, store Store, index string) error {
+	list, err := store.List(ctx, index)
+	if err != nil {
+		return fmt.Errorf("cant list sessions: %w", err)
+	}
+	for _, session := range list {
+		if err := store.Delete(ctx, session.Id); err != nil {
+			return fmt.Errorf("cant delete session: %w", err)
+		}
+	}
+	return nil
+}
+
+func marshal(session *Session) ([]byte, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, fmt.Errorf("cant marshal session into json: %w", err)
+	}
+	return data, nil
+}
+
+func unmarshal(data []byte) (*Session, error) {
+	session := &Session{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, fmt.Errorf("cant unmarshal session from json: %w", err)
+	}
+	return session, nil
+}
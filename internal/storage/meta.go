@@ -2,14 +2,16 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-const currentVersion = 2
+const currentVersion = 5
 const metadataFile = "metadata.json"
 
 type Metadata struct {
@@ -17,10 +19,32 @@ type Metadata struct {
 	Email   string `json:"email"`
 	Secret  string `json:"secret"`
 
+	// added since v3
+	ApiToken string `json:"api_token,omitempty"`
+	// added since v5, opaque id of share and rss links
+	ShareId string `json:"share_id,omitempty"`
+
+	// optional, set by admin
+	Disabled bool `json:"disabled,omitempty"`
+	// optional, managed by user
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+	// optional, groups of last browser login, so policy of api token clients follows groups of user
+	Groups []string `json:"groups,omitempty"`
+
 	// removed since v2
 	RssSecret string `json:"rss_secret,omitempty"`
 }
 
+// Webhook receives file events of user, payloads are signed by secret
+type Webhook struct {
+	Id     string `json:"id"`
+	Url    string `json:"url"`
+	Secret string `json:"secret"`
+	// Events are subscribed event types, empty means all
+	Events    []string  `json:"events,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 func (m *Metadata) MigrationRequired() bool {
 	return m.Version < currentVersion
 }
@@ -31,21 +55,60 @@ func (m *Metadata) Migrate() {
 	if m.Version == 1 {
 		m.migrateFromV1()
 	}
+	if m.Version == 2 {
+		m.migrateFromV2()
+	}
+	if m.Version == 3 {
+		m.migrateFromV3()
+	}
+	if m.Version == 4 {
+		m.migrateFromV4()
+	}
 }
 func (m *Metadata) migrateFromV1() {
 	m.Version = 2
 	m.Secret = m.RssSecret
 	m.RssSecret = ""
 }
+func (m *Metadata) migrateFromV2() {
+	m.Version = 3
+	m.ApiToken = newApiToken()
+}
+
+// migrateFromV3 marks that user data is stored under key derived from email, objects are moved by storage
+func (m *Metadata) migrateFromV3() {
+	m.Version = 4
+}
+
+// migrateFromV4 adds share id, its index object is written by storage
+func (m *Metadata) migrateFromV4() {
+	m.Version = 5
+	if m.ShareId == "" {
+		m.ShareId = newShareId()
+	}
+}
 
 func newMetadata(email string) *Metadata {
 	return &Metadata{
-		Version: currentVersion,
-		Email:   email,
-		Secret:  uuid.New().String(),
+		Version:  currentVersion,
+		Email:    email,
+		Secret:   uuid.New().String(),
+		ApiToken: newApiToken(),
+		ShareId:  newShareId(),
 	}
 }
 
+func newShareId() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+func newApiToken() string {
+	return strings.ReplaceAll(uuid.New().String()+uuid.New().String(), "-", "")
+}
+
+// errInvalidMetadata is returned if stored metadata can not be decoded
+var errInvalidMetadata = errors.New("invalid metadata")
+
 func readMetadata(reader io.Reader) (*Metadata, error) {
 	obj := &Metadata{}
 	if err := json.NewDecoder(reader).Decode(obj); err != nil {
@@ -63,6 +126,12 @@ func readMetadata(reader io.Reader) (*Metadata, error) {
 	if obj.Version >= 2 && obj.Secret == "" {
 		return nil, fmt.Errorf("object does not contain secret field")
 	}
+	if obj.Version >= 3 && obj.ApiToken == "" {
+		return nil, fmt.Errorf("object does not contain api token field")
+	}
+	if obj.Version >= 5 && obj.ShareId == "" {
+		return nil, fmt.Errorf("object does not contain share id field")
+	}
 	return obj, nil
 }
 
@@ -78,4 +147,7 @@ type FileInList struct {
 	Path           string
 	LastModifiedAt time.Time
 	Size           int
+	ETag           string
+	// ContentType is set by Stat only, listing does not return it
+	ContentType string
 }
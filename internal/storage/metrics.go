@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	operationDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sharefile_storage_operation_duration_seconds",
+		Help:    "Duration of storage operations",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"backend", "method"})
+	operationErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sharefile_storage_operation_errors_total",
+		Help: "Failed storage operations, not found objects and disabled users are not errors",
+	}, []string{"backend", "method"})
+	uploadsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sharefile_uploads_total",
+		Help: "Successfully uploaded files",
+	})
+	uploadedBytesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sharefile_uploaded_bytes_total",
+		Help: "Size of successfully uploaded files",
+	})
+)
+
+// NewInstrumentedStorage traces every storage method and exports its latency, errors and upload stats
+func NewInstrumentedStorage(backend string, storage Storage) Storage {
+	return &instrumentedStorage{backend: backend, storage: storage}
+}
+
+type instrumentedStorage struct {
+	backend string
+	storage Storage
+}
+
+var tracer = otel.Tracer("github.com/paragor/sharefile/internal/storage")
+
+type operation struct {
+	backend string
+	method  string
+	start   time.Time
+	span    trace.Span
+}
+
+// startOperation starts span of storage method, operation must be ended with pointer to result error in defer
+func startOperation(ctx context.Context, backend string, method string) (context.Context, *operation) {
+	ctx, span := tracer.Start(ctx, "storage."+method, trace.WithAttributes(attribute.String("storage.backend", backend)))
+	return ctx, &operation{backend: backend, method: method, start: time.Now(), span: span}
+}
+
+func (o *operation) end(err *error) {
+	defer o.span.End()
+	operationDurationSeconds.WithLabelValues(o.backend, o.method).Observe(time.Since(o.start).Seconds())
+	if *err != nil && !errors.Is(*err, ErrNotFound) && !errors.Is(*err, ErrUserDisabled) {
+		operationErrorsTotal.WithLabelValues(o.backend, o.method).Inc()
+		o.span.RecordError(*err)
+		o.span.SetStatus(codes.Error, (*err).Error())
+	}
+}
+
+func (s *instrumentedStorage) wrap(userStorage UserScopedStorage, err error) (UserScopedStorage, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedUserScopedStorage{backend: s.backend, storage: userStorage}, nil
+}
+
+func (s *instrumentedStorage) OpenStorage(ctx context.Context, email string, autoCreate bool) (_ UserScopedStorage, err error) {
+	ctx, op := startOperation(ctx, s.backend, "OpenStorage")
+	defer op.end(&err)
+	return s.wrap(s.storage.OpenStorage(ctx, email, autoCreate))
+}
+
+func (s *instrumentedStorage) OpenStorageByShareId(ctx context.Context, shareId string) (_ UserScopedStorage, err error) {
+	ctx, op := startOperation(ctx, s.backend, "OpenStorageByShareId")
+	defer op.end(&err)
+	return s.wrap(s.storage.OpenStorageByShareId(ctx, shareId))
+}
+
+func (s *instrumentedStorage) OpenStorageAsAdmin(ctx context.Context, email string) (_ UserScopedStorage, err error) {
+	ctx, op := startOperation(ctx, s.backend, "OpenStorageAsAdmin")
+	defer op.end(&err)
+	return s.wrap(s.storage.OpenStorageAsAdmin(ctx, email))
+}
+
+func (s *instrumentedStorage) ListUsers(ctx context.Context) (_ []*Metadata, err error) {
+	ctx, op := startOperation(ctx, s.backend, "ListUsers")
+	defer op.end(&err)
+	return s.storage.ListUsers(ctx)
+}
+
+func (s *instrumentedStorage) Ping(ctx context.Context) (err error) {
+	ctx, op := startOperation(ctx, s.backend, "Ping")
+	defer op.end(&err)
+	return s.storage.Ping(ctx)
+}
+
+type instrumentedUserScopedStorage struct {
+	backend string
+	storage UserScopedStorage
+}
+
+func (s *instrumentedUserScopedStorage) GetMetadata(ctx context.Context) (_ *Metadata, err error) {
+	ctx, op := startOperation(ctx, s.backend, "GetMetadata")
+	defer op.end(&err)
+	return s.storage.GetMetadata(ctx)
+}
+
+func (s *instrumentedUserScopedStorage) Upload(ctx context.Context, objPath string, contentType string, file io.Reader) (err error) {
+	ctx, op := startOperation(ctx, s.backend, "Upload")
+	defer op.end(&err)
+	size, seekable := seekerSize(file)
+	counter := &countingReader{reader: file}
+	if !seekable {
+		// seekable files are passed as is, so uploader can read parts concurrently without buffering
+		file = counter
+	}
+	if err := s.storage.Upload(ctx, objPath, contentType, file); err != nil {
+		return err
+	}
+	if !seekable {
+		size = counter.size
+	}
+	uploadsTotal.Inc()
+	uploadedBytesTotal.Add(float64(size))
+	return nil
+}
+
+// seekerSize returns size of unread part of file if it is seekable
+func seekerSize(file io.Reader) (int64, bool) {
+	seeker, ok := file.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	if _, err := seeker.Seek(current, io.SeekStart); err != nil {
+		return 0, false
+	}
+	return end - current, true
+}
+
+func (s *instrumentedUserScopedStorage) Download(ctx context.Context, objPath string, offset int64) (_ io.ReadCloser, err error) {
+	ctx, op := startOperation(ctx, s.backend, "Download")
+	defer op.end(&err)
+	return s.storage.Download(ctx, objPath, offset)
+}
+
+func (s *instrumentedUserScopedStorage) Stat(ctx context.Context, objPath string) (_ *FileInList, err error) {
+	ctx, op := startOperation(ctx, s.backend, "Stat")
+	defer op.end(&err)
+	return s.storage.Stat(ctx, objPath)
+}
+
+func (s *instrumentedUserScopedStorage) Move(ctx context.Context, objPathOld string, objPathNew string) (err error) {
+	ctx, op := startOperation(ctx, s.backend, "Move")
+	defer op.end(&err)
+	return s.storage.Move(ctx, objPathOld, objPathNew)
+}
+
+func (s *instrumentedUserScopedStorage) Delete(ctx context.Context, objPath string) (err error) {
+	ctx, op := startOperation(ctx, s.backend, "Delete")
+	defer op.end(&err)
+	return s.storage.Delete(ctx, objPath)
+}
+
+func (s *instrumentedUserScopedStorage) GenerateDownloadLink(ctx context.Context, objPath string, expiration time.Duration) (_ string, err error) {
+	ctx, op := startOperation(ctx, s.backend, "GenerateDownloadLink")
+	defer op.end(&err)
+	return s.storage.GenerateDownloadLink(ctx, objPath, expiration)
+}
+
+func (s *instrumentedUserScopedStorage) ListFiles(ctx context.Context) (_ []FileInList, err error) {
+	ctx, op := startOperation(ctx, s.backend, "ListFiles")
+	defer op.end(&err)
+	return s.storage.ListFiles(ctx)
+}
+
+func (s *instrumentedUserScopedStorage) RotateSecrets(ctx context.Context) (_ *Metadata, err error) {
+	ctx, op := startOperation(ctx, s.backend, "RotateSecrets")
+	defer op.end(&err)
+	return s.storage.RotateSecrets(ctx)
+}
+
+func (s *instrumentedUserScopedStorage) SetDisabled(ctx context.Context, disabled bool) (err error) {
+	ctx, op := startOperation(ctx, s.backend, "SetDisabled")
+	defer op.end(&err)
+	return s.storage.SetDisabled(ctx, disabled)
+}
+
+func (s *instrumentedUserScopedStorage) SetWebhooks(ctx context.Context, webhooks []Webhook) (err error) {
+	ctx, op := startOperation(ctx, s.backend, "SetWebhooks")
+	defer op.end(&err)
+	return s.storage.SetWebhooks(ctx, webhooks)
+}
+
+func (s *instrumentedUserScopedStorage) SetGroups(ctx context.Context, groups []string) (err error) {
+	ctx, op := startOperation(ctx, s.backend, "SetGroups")
+	defer op.end(&err)
+	return s.storage.SetGroups(ctx, groups)
+}
+
+type countingReader struct {
+	reader io.Reader
+	size   int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const fakeS3Bucket = "bucket"
+
+// fakeS3 is in-memory s3 bucket, it implements only requests used by storage
+type fakeS3 struct {
+	lock sync.Mutex
+	objs map[string][]byte
+	// pageSize limits list responses, so pagination is exercised with few objects
+	pageSize int
+	requests map[string]int
+	// uploads keeps parts of multipart uploads by upload id
+	uploads map[string]map[int][]byte
+	// afterGet is called under lock after object is read or not found, so test can change objects between read and write
+	afterGet func(key string)
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *s3.S3) {
+	fake := &fakeS3{objs: map[string][]byte{}, pageSize: 1000, requests: map[string]int{}, uploads: map[string]map[int][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	cfg := aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("access", "secret", "")).
+		WithEndpoint(server.URL).
+		WithRegion("us-east-1").
+		WithS3ForcePathStyle(true)
+	return fake, s3.New(session.Must(session.NewSession(cfg)))
+}
+
+func (f *fakeS3) put(key string, data string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.objs[key] = []byte(data)
+}
+
+func (f *fakeS3) get(key string) (string, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	data, ok := f.objs[key]
+	return string(data), ok
+}
+
+func (f *fakeS3) keys() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.sortedKeys()
+}
+
+func (f *fakeS3) count(request string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.requests[request]
+}
+
+func (f *fakeS3) sortedKeys() []string {
+	keys := make([]string, 0, len(f.objs))
+	for key := range f.objs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+fakeS3Bucket), "/")
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		f.requests["list"]++
+		f.list(w, query)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.requests["create_multipart"]++
+		uploadId := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[uploadId] = map[int][]byte{}
+		_, _ = fmt.Fprintf(w, `<InitiateMultipartUploadResult><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, key, uploadId)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		f.requests["copy_part"]++
+		f.copyPart(w, r)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.requests["complete_multipart"]++
+		f.completeMultipart(w, r, key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		f.requests["abort_multipart"]++
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodHead && key == "":
+		f.requests["head_bucket"]++
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		f.requests["get"]++
+		if f.afterGet != nil {
+			defer f.afterGet(key)
+		}
+		data, ok := f.objs[key]
+		if !ok {
+			fakeS3Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", fakeS3ETag(data))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.requests["copy"]++
+		data, ok := f.objs[fakeS3CopySource(r)]
+		if !ok {
+			fakeS3Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		f.objs[key] = data
+		_, _ = fmt.Fprint(w, `<CopyObjectResult><ETag>"etag"</ETag></CopyObjectResult>`)
+	case r.Method == http.MethodPut:
+		f.requests["put"]++
+		data, _ := io.ReadAll(r.Body)
+		current, exists := f.objs[key]
+		etag := r.Header.Get("If-Match")
+		if etag != "" && (!exists || fakeS3ETag(current) != etag) || r.Header.Get("If-None-Match") == "*" && exists {
+			f.requests["put_precondition_failed"]++
+			fakeS3Error(w, "PreconditionFailed", http.StatusPreconditionFailed)
+			return
+		}
+		f.objs[key] = data
+	case r.Method == http.MethodDelete:
+		f.requests["delete"]++
+		delete(f.objs, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeS3Error(w, "NotImplemented", http.StatusNotImplemented)
+	}
+}
+
+// copyPart supports only UploadPartCopy, parts are copied by range of source
+func (f *fakeS3) copyPart(w http.ResponseWriter, r *http.Request) {
+	parts, ok := f.uploads[r.URL.Query().Get("uploadId")]
+	if !ok {
+		fakeS3Error(w, "NoSuchUpload", http.StatusNotFound)
+		return
+	}
+	data, ok := f.objs[fakeS3CopySource(r)]
+	if !ok {
+		fakeS3Error(w, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+	var first, last int
+	if _, err := fmt.Sscanf(r.Header.Get("X-Amz-Copy-Source-Range"), "bytes=%d-%d", &first, &last); err != nil || last >= len(data) || first > last {
+		fakeS3Error(w, "InvalidRange", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	partNumber, _ := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	parts[partNumber] = data[first : last+1]
+	_, _ = fmt.Fprintf(w, `<CopyPartResult><ETag>"part-%d"</ETag></CopyPartResult>`, partNumber)
+}
+
+func (f *fakeS3) completeMultipart(w http.ResponseWriter, r *http.Request, key string) {
+	uploadId := r.URL.Query().Get("uploadId")
+	parts, ok := f.uploads[uploadId]
+	if !ok {
+		fakeS3Error(w, "NoSuchUpload", http.StatusNotFound)
+		return
+	}
+	request := struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}{}
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		fakeS3Error(w, "MalformedXML", http.StatusBadRequest)
+		return
+	}
+	var data []byte
+	for _, part := range request.Parts {
+		partData, ok := parts[part.PartNumber]
+		if !ok || part.ETag != fmt.Sprintf(`"part-%d"`, part.PartNumber) {
+			fakeS3Error(w, "InvalidPart", http.StatusBadRequest)
+			return
+		}
+		data = append(data, partData...)
+	}
+	f.objs[key] = data
+	delete(f.uploads, uploadId)
+	_, _ = fmt.Fprintf(w, `<CompleteMultipartUploadResult><Key>%s</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`, key)
+}
+
+func fakeS3CopySource(r *http.Request) string {
+	source, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	return strings.TrimPrefix(strings.TrimPrefix(source, "/"), fakeS3Bucket+"/")
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+		ETag         string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	response := struct {
+		XMLName               xml.Name       `xml:"ListBucketResult"`
+		Contents              []content      `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{}
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	after := query.Get("continuation-token")
+	seen := map[string]bool{}
+	for _, key := range f.sortedKeys() {
+		if !strings.HasPrefix(key, prefix) || key <= after {
+			continue
+		}
+		if len(response.Contents)+len(response.CommonPrefixes) == f.pageSize {
+			response.IsTruncated = true
+			break
+		}
+		response.NextContinuationToken = key
+		rest := key[len(prefix):]
+		if delimiter != "" && strings.Contains(rest, delimiter) {
+			common := prefix + rest[:strings.Index(rest, delimiter)+len(delimiter)]
+			if !seen[common] {
+				seen[common] = true
+				response.CommonPrefixes = append(response.CommonPrefixes, commonPrefix{Prefix: common})
+			}
+			continue
+		}
+		response.Contents = append(response.Contents, content{
+			Key:          key,
+			Size:         len(f.objs[key]),
+			LastModified: "2024-01-01T00:00:00.000Z",
+			ETag:         `"etag"`,
+		})
+	}
+	if !response.IsTruncated {
+		response.NextContinuationToken = ""
+	}
+	_ = xml.NewEncoder(w).Encode(response)
+}
+
+func fakeS3ETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func fakeS3Error(w http.ResponseWriter, code string, status int) {
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, `<Error><Code>%s</Code></Error>`, code)
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/paragor/sharefile/internal/log"
+)
+
+// Before metadata v4 user data was stored under raw email: <email>/metadata.json and <email>/files/.
+// Legacy users are moved under hashed key on first open or by MigrateS3Storage.
+
+func getS3LegacyMetadataPath(email string) string {
+	return email + "/" + metadataFile
+}
+
+func getS3LegacyFilesPrefix(email string) string {
+	return email + "/files/"
+}
+
+// openLegacyMetadata returns ErrNotFound if user has no data in legacy layout
+func (sf *s3StorageFactory) openLegacyMetadata(ctx context.Context, email string) (*Metadata, error) {
+	// legacy prefix of such email may contain data of another user, it should be moved by hand
+	if strings.Contains(email, "/") {
+		return nil, ErrNotFound
+	}
+	meta, err := sf.readMetadata(ctx, getS3LegacyMetadataPath(email))
+	if err != nil {
+		return nil, err
+	}
+	if meta.Email != email {
+		return nil, fmt.Errorf("invalid legacy metadata: expect %s email, got %s", email, meta.Email)
+	}
+	return meta, nil
+}
+
+// CopyObject is limited to 5 GiB, larger objects are copied by parts
+var (
+	s3MaxCopyObjectSize int64 = 5 * 1024 * 1024 * 1024
+	s3CopyPartSize      int64 = 512 * 1024 * 1024
+)
+
+// migrateLegacyUser copies files, then writes metadata under new key and only after that deletes legacy objects,
+// so interrupted migration is continued on next run
+func (sf *s3StorageFactory) migrateLegacyUser(ctx context.Context, meta *Metadata) error {
+	lock, _ := sf.migrationLocks.LoadOrStore(meta.Email, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+	logger := log.FromContext(ctx).With(slog.String("email", meta.Email))
+	logger.Info("migrate user to hashed storage key")
+
+	legacyFilesPrefix := getS3LegacyFilesPrefix(meta.Email)
+	legacySizes, err := sf.listSizes(ctx, legacyFilesPrefix)
+	if err != nil {
+		return fmt.Errorf("cant list legacy files: %w", err)
+	}
+	legacyKeys := make([]string, 0, len(legacySizes))
+	for key := range legacySizes {
+		legacyKeys = append(legacyKeys, key)
+	}
+	sort.Strings(legacyKeys)
+
+	copied := 0
+	current, err := sf.readMetadata(ctx, getS3MetadataPath(meta.Email))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		filesPrefix := getS3FilesPrefix(meta.Email)
+		// files copied by interrupted run are skipped
+		copiedSizes, err := sf.listSizes(ctx, filesPrefix)
+		if err != nil {
+			return fmt.Errorf("cant list migrated files: %w", err)
+		}
+		for _, key := range legacyKeys {
+			newKey := filesPrefix + strings.TrimPrefix(key, legacyFilesPrefix)
+			if size, ok := copiedSizes[newKey]; ok && size == legacySizes[key] {
+				continue
+			}
+			if err := sf.copyObject(ctx, key, newKey, legacySizes[key]); err != nil {
+				return fmt.Errorf("cant copy legacy file '%s': %w", key, err)
+			}
+			copied++
+		}
+		meta.Migrate()
+		// another replica may migrate the same user, then its metadata is kept
+		saved, err := sf.saveNewMetadata(ctx, meta, s3IfNotExists)
+		if err != nil {
+			return fmt.Errorf("cant upload migrated metadata: %w", err)
+		}
+		*meta = *saved
+	case err != nil:
+		return fmt.Errorf("cant read metadata: %w", err)
+	default:
+		// migration was interrupted after metadata was written, so files are copied and may be already changed by user
+		*meta = *current
+	}
+
+	for _, key := range append(legacyKeys, getS3LegacyMetadataPath(meta.Email)) {
+		if _, err := sf.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(sf.bucket),
+			Key:    aws.String(key),
+		}); err != nil {
+			return fmt.Errorf("cant delete legacy object '%s': %w", key, err)
+		}
+	}
+	logger.With(slog.Int("files", len(legacyKeys)), slog.Int("copied", copied)).Info("user is migrated to hashed storage key")
+	return nil
+}
+
+// listSizes returns sizes of objects under prefix by key
+func (sf *s3StorageFactory) listSizes(ctx context.Context, prefix string) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	err := sf.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(sf.bucket),
+		Prefix: aws.String(prefix),
+	}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range output.Contents {
+			sizes[aws.StringValue(obj.Key)] = aws.Int64Value(obj.Size)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
+
+func (sf *s3StorageFactory) copyObject(ctx context.Context, source string, key string, size int64) error {
+	copySource := aws.String(url.PathEscape(sf.bucket + "/" + source))
+	if size <= s3MaxCopyObjectSize {
+		_, err := sf.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(sf.bucket),
+			CopySource: copySource,
+			Key:        aws.String(key),
+		})
+		return err
+	}
+
+	// multipart upload does not copy content type, unlike CopyObject
+	head, err := sf.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(sf.bucket),
+		Key:    aws.String(source),
+	})
+	if err != nil {
+		return fmt.Errorf("cant head object: %w", err)
+	}
+	upload, err := sf.client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(sf.bucket),
+		Key:         aws.String(key),
+		ContentType: head.ContentType,
+	})
+	if err != nil {
+		return fmt.Errorf("cant create multipart upload: %w", err)
+	}
+	parts, err := sf.copyParts(ctx, copySource, key, upload.UploadId, size)
+	if err == nil {
+		_, err = sf.client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(sf.bucket),
+			Key:             aws.String(key),
+			UploadId:        upload.UploadId,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+		if err != nil {
+			err = fmt.Errorf("cant complete multipart upload: %w", err)
+		}
+	}
+	if err != nil {
+		// uploaded parts are billed until upload is aborted
+		if _, abortErr := sf.client.AbortMultipartUploadWithContext(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(sf.bucket),
+			Key:      aws.String(key),
+			UploadId: upload.UploadId,
+		}); abortErr != nil {
+			log.FromContext(ctx).With(log.Error(abortErr), slog.String("key", key)).Warn("cant abort multipart upload")
+		}
+		return err
+	}
+	return nil
+}
+
+func (sf *s3StorageFactory) copyParts(ctx context.Context, copySource *string, key string, uploadId *string, size int64) ([]*s3.CompletedPart, error) {
+	var parts []*s3.CompletedPart
+	for offset := int64(0); offset < size; offset += s3CopyPartSize {
+		last := min(offset+s3CopyPartSize, size) - 1
+		partNumber := aws.Int64(int64(len(parts) + 1))
+		output, err := sf.client.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(sf.bucket),
+			Key:             aws.String(key),
+			UploadId:        uploadId,
+			PartNumber:      partNumber,
+			CopySource:      copySource,
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, last)),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cant copy part %d: %w", *partNumber, err)
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: output.CopyPartResult.ETag, PartNumber: partNumber})
+	}
+	return parts, nil
+}
+
+// MigrateS3Storage moves all users of bucket from legacy layout, it is safe to run while server is working
+func MigrateS3Storage(ctx context.Context, client *s3.S3, bucket string) error {
+	sf := &s3StorageFactory{client: client, bucket: bucket}
+	logger := log.FromContext(ctx)
+
+	var candidates []string
+	err := client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+	}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range output.Contents {
+			key := aws.StringValue(obj.Key)
+			if strings.HasSuffix(key, "/"+metadataFile) && !strings.HasPrefix(key, s3UsersPrefix) {
+				candidates = append(candidates, key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("cant list bucket: %w", err)
+	}
+
+	migrated := 0
+	for _, key := range candidates {
+		meta, err := sf.readMetadata(ctx, key)
+		if err != nil {
+			// user may upload file named metadata.json
+			logger.With(log.Error(err), slog.String("key", key)).Warn("skip object, it is not metadata")
+			continue
+		}
+		if key != getS3LegacyMetadataPath(meta.Email) {
+			logger.With(slog.String("key", key), slog.String("email", meta.Email)).Warn("skip metadata, its key does not match email")
+			continue
+		}
+		if strings.Contains(meta.Email, "/") {
+			logger.With(slog.String("email", meta.Email)).Warn("skip user with '/' in email, its legacy prefix may contain data of another user, move it by hand")
+			continue
+		}
+		if err := sf.migrateLegacyUser(ctx, meta); err != nil {
+			return fmt.Errorf("cant migrate '%s': %w", meta.Email, err)
+		}
+		migrated++
+	}
+	logger.With(slog.Int("users", migrated)).Info("storage migration is completed")
+	return nil
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const migrationTestEmail = "user@example.com"
+
+func TestMigrateLegacyUser(t *testing.T) {
+	legacyMeta := `{"version":3,"email":"` + migrationTestEmail + `","secret":"secret","api_token":"token"}`
+	migratedMeta := `{"version":5,"email":"` + migrationTestEmail + `","secret":"migrated","api_token":"migrated","share_id":"` + strings.Repeat("a", 32) + `"}`
+	legacyFiles := getS3LegacyFilesPrefix(migrationTestEmail)
+	files := getS3FilesPrefix(migrationTestEmail)
+
+	tests := []struct {
+		name string
+		// objs are put before migration in addition to legacy metadata and files
+		objs        map[string]string
+		maxCopySize int64
+		wantCopies  int
+		wantParts   int
+		wantFiles   map[string]string
+		wantSecret  string
+	}{
+		{
+			name:        "first run",
+			maxCopySize: 1024,
+			wantCopies:  2,
+			wantFiles:   map[string]string{"a.txt": "first file", "dir/b.txt": "second"},
+			wantSecret:  "secret",
+		},
+		{
+			name:        "interrupted while copying",
+			objs:        map[string]string{files + "a.txt": "first file", files + "dir/b.txt": "sec"},
+			maxCopySize: 1024,
+			// partially copied file has another size
+			wantCopies: 1,
+			wantFiles:  map[string]string{"a.txt": "first file", "dir/b.txt": "second"},
+			wantSecret: "secret",
+		},
+		{
+			name:        "interrupted after metadata",
+			objs:        map[string]string{getS3MetadataPath(migrationTestEmail): migratedMeta, files + "a.txt": "changed by user"},
+			maxCopySize: 1024,
+			wantFiles:   map[string]string{"a.txt": "changed by user"},
+			wantSecret:  "migrated",
+		},
+		{
+			name:        "large files are copied by parts",
+			maxCopySize: 5,
+			// parts of 4 bytes: "first file" by 3 parts, "second" by 2 parts
+			wantParts:  5,
+			wantFiles:  map[string]string{"a.txt": "first file", "dir/b.txt": "second"},
+			wantSecret: "secret",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxCopySize, partSize := s3MaxCopyObjectSize, s3CopyPartSize
+			s3MaxCopyObjectSize, s3CopyPartSize = tt.maxCopySize, 4
+			t.Cleanup(func() {
+				s3MaxCopyObjectSize, s3CopyPartSize = maxCopySize, partSize
+			})
+			fake, client := newFakeS3(t)
+			fake.put(getS3LegacyMetadataPath(migrationTestEmail), legacyMeta)
+			fake.put(legacyFiles+"a.txt", "first file")
+			fake.put(legacyFiles+"dir/b.txt", "second")
+			for key, data := range tt.objs {
+				fake.put(key, data)
+			}
+
+			if err := MigrateS3Storage(context.Background(), client, fakeS3Bucket); err != nil {
+				t.Fatal(err)
+			}
+
+			if copies := fake.count("copy"); copies != tt.wantCopies {
+				t.Fatalf("made %d copy requests, want %d", copies, tt.wantCopies)
+			}
+			if parts := fake.count("copy_part"); parts != tt.wantParts {
+				t.Fatalf("copied %d parts, want %d", parts, tt.wantParts)
+			}
+			var wantKeys []string
+			for name, data := range tt.wantFiles {
+				wantKeys = append(wantKeys, files+name)
+				if got, _ := fake.get(files + name); got != data {
+					t.Fatalf("file %s is %q, want %q", name, got, data)
+				}
+			}
+			slices.Sort(wantKeys)
+			var keys []string
+			for _, key := range fake.keys() {
+				if strings.HasPrefix(key, migrationTestEmail+"/") {
+					t.Fatalf("legacy object %s is not deleted", key)
+				}
+				if strings.HasPrefix(key, files) {
+					keys = append(keys, key)
+				}
+			}
+			if !slices.Equal(keys, wantKeys) {
+				t.Fatalf("user has files %v, want %v", keys, wantKeys)
+			}
+			userStorage, err := NewS3Storage(client, fakeS3Bucket).OpenStorage(context.Background(), migrationTestEmail, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err := userStorage.GetMetadata(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Version != currentVersion || meta.Secret != tt.wantSecret {
+				t.Fatalf("metadata has version %d secret %q, want %d %q", meta.Version, meta.Secret, currentVersion, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func TestMigrateLegacyUserIsRepeatable(t *testing.T) {
+	fake, client := newFakeS3(t)
+	fake.put(getS3LegacyMetadataPath(migrationTestEmail), `{"version":3,"email":"`+migrationTestEmail+`","secret":"secret","api_token":"token"}`)
+	fake.put(getS3LegacyFilesPrefix(migrationTestEmail)+"a.txt", "data")
+
+	for range 2 {
+		if err := MigrateS3Storage(context.Background(), client, fakeS3Bucket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if copies := fake.count("copy"); copies != 1 {
+		t.Fatalf("made %d copy requests, want 1", copies)
+	}
+	if got, _ := fake.get(getS3FilesPrefix(migrationTestEmail) + "a.txt"); got != "data" {
+		t.Fatalf("migrated file is %q", got)
+	}
+}
+
+func TestOpenStorageKeepsConcurrentlySavedMetadata(t *testing.T) {
+	winnerShareId := strings.Repeat("b", 32)
+	winnerMeta := `{"version":5,"email":"` + migrationTestEmail + `","secret":"winner","api_token":"winner","share_id":"` + winnerShareId + `"}`
+	tests := []struct {
+		name string
+		objs map[string]string
+		// raceAfterGets is number of metadata reads, after which concurrent request saves its metadata
+		raceAfterGets int
+	}{
+		{
+			name: "legacy user",
+			objs: map[string]string{
+				getS3LegacyMetadataPath(migrationTestEmail):             `{"version":3,"email":"` + migrationTestEmail + `","secret":"secret","api_token":"token"}`,
+				getS3LegacyFilesPrefix(migrationTestEmail) + "file.txt": "data",
+			},
+			// first read finds no metadata, second one is made by migration right before it writes metadata
+			raceAfterGets: 2,
+		},
+		{
+			name:          "previous metadata version",
+			objs:          map[string]string{getS3MetadataPath(migrationTestEmail): `{"version":4,"email":"` + migrationTestEmail + `","secret":"secret","api_token":"token"}`},
+			raceAfterGets: 1,
+		},
+		{
+			name:          "new user",
+			raceAfterGets: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fake, client := newFakeS3(t)
+			for key, data := range tt.objs {
+				fake.put(key, data)
+			}
+			metaPath := getS3MetadataPath(migrationTestEmail)
+			gets := 0
+			fake.afterGet = func(key string) {
+				if key != metaPath {
+					return
+				}
+				gets++
+				if gets == tt.raceAfterGets {
+					fake.objs[metaPath] = []byte(winnerMeta)
+					fake.objs[getS3ShareIndexPath(winnerShareId)] = []byte(`{"email":"` + migrationTestEmail + `"}`)
+				}
+			}
+
+			userStorage, err := NewS3Storage(client, fakeS3Bucket).OpenStorage(ctx, migrationTestEmail, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fake.afterGet = nil
+			meta, err := userStorage.GetMetadata(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.ShareId != winnerShareId || meta.Secret != "winner" {
+				t.Fatalf("metadata has share id %s secret %s, want metadata of concurrent request", meta.ShareId, meta.Secret)
+			}
+			var shareIndexes []string
+			for _, key := range fake.keys() {
+				if strings.HasPrefix(key, migrationTestEmail+"/") {
+					t.Fatalf("legacy object %s is not deleted", key)
+				}
+				if strings.HasPrefix(key, s3SharesPrefix) {
+					shareIndexes = append(shareIndexes, key)
+				}
+			}
+			if !slices.Equal(shareIndexes, []string{getS3ShareIndexPath(winnerShareId)}) {
+				t.Fatalf("share indexes %v, want only one of saved metadata", shareIndexes)
+			}
+		})
+	}
+}
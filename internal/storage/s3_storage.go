@@ -3,7 +3,14 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -12,13 +19,60 @@ import (
 	"github.com/paragor/sharefile/internal/log"
 )
 
+// s3UsersPrefix holds data of users since metadata v4, before it was stored under raw email at bucket root
+const s3UsersPrefix = "users/"
+
+// getS3UserPrefix derives key from hash of email, so email with '/' or other special characters can not address data of another user
+func getS3UserPrefix(email string) string {
+	sum := sha256.Sum256([]byte(email))
+	return s3UsersPrefix + hex.EncodeToString(sum[:]) + "/"
+}
+
 func getS3MetadataPath(email string) string {
-	return email + "/" + metadataFile
+	return getS3UserPrefix(email) + metadataFile
+}
+
+func getS3FilesPrefix(email string) string {
+	return getS3UserPrefix(email) + "files/"
+}
+
+// s3SharesPrefix holds index of share ids, every object points to owner of share
+const s3SharesPrefix = "shares/"
+
+func getS3ShareIndexPath(shareId string) string {
+	return s3SharesPrefix + shareId + ".json"
+}
+
+type shareIndex struct {
+	Email string `json:"email"`
+}
+
+// s3MetadataUpdateAttempts limits retries of metadata update, which lost race with another writer
+const s3MetadataUpdateAttempts = 5
+
+// isS3Conflict reports that conditional write lost race with another writer
+func isS3Conflict(err error) bool {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	return awsErr.Code() == "PreconditionFailed" || awsErr.Code() == "ConditionalRequestConflict"
+}
+
+func isS3NotFound(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	// HeadObject has no response body, so it reports bare http status instead of error code
+	return awsErr.Code() == s3.ErrCodeNoSuchKey || awsErr.Code() == "NotFound"
 }
 
 type s3StorageFactory struct {
 	client *s3.S3
 	bucket string
+	// migrationLocks serializes migration of the same legacy user in process, replicas are resolved by conditional writes
+	migrationLocks sync.Map
 }
 
 func NewS3Storage(client *s3.S3, bucket string) Storage {
@@ -26,78 +80,290 @@ func NewS3Storage(client *s3.S3, bucket string) Storage {
 }
 
 func (sf *s3StorageFactory) OpenStorage(ctx context.Context, email string, autoCreate bool) (UserScopedStorage, error) {
+	return sf.openStorage(ctx, email, autoCreate, false)
+}
+
+func (sf *s3StorageFactory) OpenStorageAsAdmin(ctx context.Context, email string) (UserScopedStorage, error) {
+	return sf.openStorage(ctx, email, false, true)
+}
+
+func (sf *s3StorageFactory) openStorage(ctx context.Context, email string, autoCreate bool, allowDisabled bool) (UserScopedStorage, error) {
 	if email == "" {
 		return nil, fmt.Errorf("email cannot be empty")
 	}
-	meta, err := sf.openMetadata(ctx, email, autoCreate)
+	meta, etag, err := sf.openMetadata(ctx, email, autoCreate)
 	if err != nil {
 		return nil, fmt.Errorf("cant open metadata: %w", err)
 	}
-	if err := sf.migrateMetadata(ctx, meta); err != nil {
+	if err := sf.migrateMetadata(ctx, meta, etag); err != nil {
 		return nil, fmt.Errorf("cant migrate metadata: %w", err)
 	}
+	if meta.Disabled && !allowDisabled {
+		return nil, ErrUserDisabled
+	}
 
 	return &s3SUserSCopedStorage{
-		client:   sf.client,
-		uploader: s3manager.NewUploaderWithClient(sf.client),
-		bucket:   sf.bucket,
-		email:    meta.Email,
+		client:      sf.client,
+		uploader:    s3manager.NewUploaderWithClient(sf.client),
+		bucket:      sf.bucket,
+		email:       meta.Email,
+		filesPrefix: getS3FilesPrefix(meta.Email),
 	}, nil
 }
 
-func (sf *s3StorageFactory) saveMetadata(ctx context.Context, meta *Metadata) error {
+func (sf *s3StorageFactory) OpenStorageByShareId(ctx context.Context, shareId string) (UserScopedStorage, error) {
+	if !isShareId(shareId) {
+		return nil, ErrNotFound
+	}
+	obj, err := sf.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Key:    aws.String(getS3ShareIndexPath(shareId)),
+		Bucket: aws.String(sf.bucket),
+	})
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("cant read share index from s3: %w", err)
+	}
+	defer obj.Body.Close()
+	index := &shareIndex{}
+	if err := json.NewDecoder(obj.Body).Decode(index); err != nil {
+		return nil, fmt.Errorf("cant unmarshal share index from json: %w", err)
+	}
+
+	userStorage, err := sf.openStorage(ctx, index.Email, false, false)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := userStorage.GetMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// index of rotated share id may be left if rotation was interrupted
+	if meta.ShareId != shareId {
+		return nil, ErrNotFound
+	}
+	return userStorage, nil
+}
+
+func (sf *s3StorageFactory) Ping(ctx context.Context) error {
+	_, err := sf.client.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(sf.bucket)})
+	if err != nil {
+		return fmt.Errorf("cant head bucket: %w", err)
+	}
+	return nil
+}
+
+func isShareId(shareId string) bool {
+	if len(shareId) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(shareId)
+	return err == nil
+}
+
+func (sf *s3StorageFactory) ListUsers(ctx context.Context) ([]*Metadata, error) {
+	var prefixes []string
+	err := sf.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(sf.bucket),
+		Prefix:    aws.String(s3UsersPrefix),
+		Delimiter: aws.String("/"),
+	}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+		for _, prefix := range output.CommonPrefixes {
+			prefixes = append(prefixes, aws.StringValue(prefix.Prefix))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cant list s3 users: %w", err)
+	}
+
+	// users of legacy layout appear after login or offline migration
+	users := make([]*Metadata, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		meta, err := sf.readMetadata(ctx, prefix+metadataFile)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		// single broken user should not hide others from admin
+		if errors.Is(err, errInvalidMetadata) {
+			log.FromContext(ctx).With(log.Error(err), slog.String("key", prefix)).Warn("skip user with invalid metadata")
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cant open metadata of '%s': %w", prefix, err)
+		}
+		if getS3UserPrefix(meta.Email) != prefix {
+			log.FromContext(ctx).With(slog.String("key", prefix), slog.String("email", meta.Email)).Warn("skip user, email of metadata does not match its key")
+			continue
+		}
+		users = append(users, meta)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Email < users[j].Email
+	})
+	return users, nil
+}
+
+// saveMetadata writes share index before metadata, so share id of saved metadata always resolves
+func (sf *s3StorageFactory) saveMetadata(ctx context.Context, meta *Metadata, precondition s3Precondition) error {
+	if meta.ShareId != "" {
+		if err := putS3ShareIndex(ctx, sf.client, sf.bucket, meta); err != nil {
+			return err
+		}
+	}
+	return putS3Metadata(ctx, sf.client, sf.bucket, meta, precondition)
+}
+
+// saveNewMetadata saves created or migrated metadata, if concurrent request saved it first, its metadata is returned,
+// so all requests agree on share id and secrets, and share index of lost metadata is deleted
+func (sf *s3StorageFactory) saveNewMetadata(ctx context.Context, meta *Metadata, precondition s3Precondition) (*Metadata, error) {
+	err := sf.saveMetadata(ctx, meta, precondition)
+	if err == nil {
+		return meta, nil
+	}
+	if !isS3Conflict(err) {
+		return nil, err
+	}
+	current, err := sf.readMetadata(ctx, getS3MetadataPath(meta.Email))
+	if err != nil {
+		return nil, fmt.Errorf("cant read metadata saved by concurrent request: %w", err)
+	}
+	log.FromContext(ctx).Info("metadata is saved by concurrent request")
+	if meta.ShareId != "" && meta.ShareId != current.ShareId {
+		if _, err := sf.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(sf.bucket),
+			Key:    aws.String(getS3ShareIndexPath(meta.ShareId)),
+		}); err != nil {
+			// index does not resolve, because share id differs from metadata
+			log.FromContext(ctx).With(log.Error(err)).Warn("cant delete share index of lost metadata")
+		}
+	}
+	return current, nil
+}
+
+func putS3ShareIndex(ctx context.Context, client *s3.S3, bucket string, meta *Metadata) error {
+	data, err := json.Marshal(&shareIndex{Email: meta.Email})
+	if err != nil {
+		return fmt.Errorf("cant marshal share index into json: %w", err)
+	}
+	if _, err := client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Key:         aws.String(getS3ShareIndexPath(meta.ShareId)),
+		Body:        bytes.NewReader(data),
+		Bucket:      aws.String(bucket),
+		ContentType: aws.String("application/json"),
+	}); err != nil {
+		return fmt.Errorf("cant upload share index: %w", err)
+	}
+	return nil
+}
+
+// s3Precondition makes write conditional, zero value writes unconditionally
+type s3Precondition struct {
+	header string
+	value  string
+}
+
+// s3IfMatch writes object only if it was not changed since it was read with etag
+func s3IfMatch(etag string) s3Precondition {
+	return s3Precondition{header: "If-Match", value: etag}
+}
+
+// s3IfNotExists writes object only if it does not exist
+var s3IfNotExists = s3Precondition{header: "If-None-Match", value: "*"}
+
+func putS3Metadata(ctx context.Context, client *s3.S3, bucket string, meta *Metadata, precondition s3Precondition) error {
 	data, err := meta.marshal()
 	if err != nil {
 		return fmt.Errorf("cant marshal metadata: %w", err)
 	}
-	if _, err := sf.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+	request, _ := client.PutObjectRequest(&s3.PutObjectInput{
 		Key:         aws.String(getS3MetadataPath(meta.Email)),
 		Body:        bytes.NewReader(data),
-		Bucket:      aws.String(sf.bucket),
+		Bucket:      aws.String(bucket),
 		ContentType: aws.String("application/json"),
-	}); err != nil {
+	})
+	request.SetContext(ctx)
+	if precondition.header != "" && precondition.value != "" {
+		// sdk v1 has no fields for conditional write, header is signed as any other
+		request.HTTPRequest.Header.Set(precondition.header, precondition.value)
+	}
+	if err := request.Send(); err != nil {
 		return fmt.Errorf("cant upload metadata: %w", err)
 	}
 	return nil
 }
 
-func (sf *s3StorageFactory) migrateMetadata(ctx context.Context, meta *Metadata) error {
+// migrateMetadata saves migrated metadata only if it was not changed since it was read with etag
+func (sf *s3StorageFactory) migrateMetadata(ctx context.Context, meta *Metadata, etag string) error {
 	if !meta.MigrationRequired() {
 		return nil
 	}
 	log.FromContext(ctx).Info("migrate metadata")
 	meta.Migrate()
-	if err := sf.saveMetadata(ctx, meta); err != nil {
+	saved, err := sf.saveNewMetadata(ctx, meta, s3IfMatch(etag))
+	if err != nil {
 		return fmt.Errorf("cant upload migrated metadata: %w", err)
 	}
+	*meta = *saved
 	return nil
 }
 
-func (sf *s3StorageFactory) openMetadata(ctx context.Context, email string, autoCreate bool) (*Metadata, error) {
+// openMetadata returns metadata with etag, etag is empty if metadata is created or moved from legacy layout
+func (sf *s3StorageFactory) openMetadata(ctx context.Context, email string, autoCreate bool) (*Metadata, string, error) {
+	meta, etag, err := sf.readMetadataObject(ctx, getS3MetadataPath(email))
+	if errors.Is(err, ErrNotFound) {
+		meta, err = sf.openLegacyMetadata(ctx, email)
+		if err == nil {
+			if err := sf.migrateLegacyUser(ctx, meta); err != nil {
+				return nil, "", fmt.Errorf("cant migrate legacy user: %w", err)
+			}
+			return meta, "", nil
+		}
+	}
+	if errors.Is(err, ErrNotFound) {
+		if !autoCreate {
+			return nil, "", ErrNotFound
+		}
+		log.FromContext(ctx).Info("create new metadata")
+		meta, err := sf.saveNewMetadata(ctx, newMetadata(email), s3IfNotExists)
+		if err != nil {
+			return nil, "", fmt.Errorf("cant upload new metadata: %w", err)
+		}
+		return meta, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if meta.Email != email {
+		return nil, "", fmt.Errorf("invalid metadata: expect %s email, got %s", email, meta.Email)
+	}
+	return meta, etag, nil
+}
+
+// readMetadata returns unwrapped ErrNotFound if object does not exist and errInvalidMetadata if it can not be decoded
+func (sf *s3StorageFactory) readMetadata(ctx context.Context, key string) (*Metadata, error) {
+	meta, _, err := sf.readMetadataObject(ctx, key)
+	return meta, err
+}
+
+// readMetadataObject is readMetadata, which also returns etag of object
+func (sf *s3StorageFactory) readMetadataObject(ctx context.Context, key string) (*Metadata, string, error) {
 	obj, err := sf.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
-		Key:    aws.String(getS3MetadataPath(email)),
+		Key:    aws.String(key),
 		Bucket: aws.String(sf.bucket),
 	})
-
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok && autoCreate && awsErr.Code() == s3.ErrCodeNoSuchKey {
-			log.FromContext(ctx).Info("create new metadata")
-			meta := newMetadata(email)
-			if err := sf.saveMetadata(ctx, meta); err != nil {
-				return nil, fmt.Errorf("cant upload new metadata: %w", err)
-			}
-			return meta, nil
+		if isS3NotFound(err) {
+			return nil, "", ErrNotFound
 		}
-		return nil, fmt.Errorf("cant read meatadata from s3: %w", err)
+		return nil, "", fmt.Errorf("cant read meatadata from s3: %w", err)
 	}
 	defer obj.Body.Close()
 
 	meta, err := readMetadata(obj.Body)
 	if err != nil {
-		return nil, fmt.Errorf("cant read metadata: %w", err)
-	}
-	if meta.Email != email {
-		return nil, fmt.Errorf("invalid metadata: expect %s email, got %s", email, meta.Email)
+		return nil, "", fmt.Errorf("%w: %w", errInvalidMetadata, err)
 	}
-	return meta, nil
+	return meta, aws.StringValue(obj.ETag), nil
 }
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestS3ListUsersSkipsInvalidMetadata(t *testing.T) {
+	fake, client := newFakeS3(t)
+	sf := NewS3Storage(client, fakeS3Bucket)
+	for _, email := range []string{"a@example.com", "c@example.com"} {
+		if _, err := sf.OpenStorage(context.Background(), email, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.put(getS3MetadataPath("broken@example.com"), "{not json")
+	fake.put(getS3MetadataPath("moved@example.com"), `{"version":5,"email":"other@example.com"}`)
+	fake.put(getS3FilesPrefix("no-metadata@example.com")+"file.txt", "data")
+
+	users, err := sf.ListUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var emails []string
+	for _, user := range users {
+		emails = append(emails, user.Email)
+	}
+	if len(emails) != 2 || emails[0] != "a@example.com" || emails[1] != "c@example.com" {
+		t.Fatalf("listed users %v, want valid ones", emails)
+	}
+}
+
+func TestS3OpenStorageByShareId(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFakeS3(t)
+	sf := NewS3Storage(client, fakeS3Bucket)
+	openUser := func(email string) *Metadata {
+		t.Helper()
+		userStorage, err := sf.OpenStorage(ctx, email, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta, err := userStorage.GetMetadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return meta
+	}
+	user := openUser("user@example.com")
+
+	disabled := openUser("disabled@example.com")
+	disabledStorage, err := sf.OpenStorageAsAdmin(ctx, "disabled@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := disabledStorage.SetDisabled(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := openUser("rotated@example.com")
+	rotatedStorage, err := sf.OpenStorage(ctx, "rotated@example.com", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotatedStorage.RotateSecrets(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// rotation was interrupted before old index is deleted
+	fake.put(getS3ShareIndexPath(rotated.ShareId), `{"email":"rotated@example.com"}`)
+
+	tests := []struct {
+		name      string
+		shareId   string
+		wantEmail string
+		wantErr   error
+	}{
+		{name: "share id", shareId: user.ShareId, wantEmail: "user@example.com"},
+		{name: "unknown id", shareId: strings.Repeat("0", 32), wantErr: ErrNotFound},
+		{name: "invalid id", shareId: "../" + user.ShareId[3:], wantErr: ErrNotFound},
+		{name: "email instead of id", shareId: "user@example.com", wantErr: ErrNotFound},
+		{name: "disabled owner", shareId: disabled.ShareId, wantErr: ErrUserDisabled},
+		{name: "rotated id", shareId: rotated.ShareId, wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userStorage, err := sf.OpenStorageByShareId(ctx, tt.shareId)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err := userStorage.GetMetadata(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Email != tt.wantEmail {
+				t.Fatalf("opened storage of %s, want %s", meta.Email, tt.wantEmail)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/url"
 	"sort"
 	"strings"
 	"time"
@@ -11,6 +12,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/google/uuid"
 )
 
 type s3SUserSCopedStorage struct {
@@ -18,27 +20,56 @@ type s3SUserSCopedStorage struct {
 	uploader *s3manager.Uploader
 	bucket   string
 	email    string
+	// filesPrefix is derived from email by getS3FilesPrefix
+	filesPrefix string
 }
 
 func (s *s3SUserSCopedStorage) getFilePath(objPath string) string {
-	return s.email + "/files/" + strings.TrimLeft(objPath, "/")
+	return s.filesPrefix + strings.TrimLeft(objPath, "/")
 }
 
 func (s *s3SUserSCopedStorage) GetMetadata(ctx context.Context) (*Metadata, error) {
+	meta, _, err := s.getMetadata(ctx)
+	return meta, err
+}
+
+// getMetadata returns metadata with etag of its object
+func (s *s3SUserSCopedStorage) getMetadata(ctx context.Context) (*Metadata, string, error) {
 	obj, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Key:    aws.String(getS3MetadataPath(s.email)),
 		Bucket: aws.String(s.bucket),
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("cant read metadata from s3: %w", err)
+		return nil, "", fmt.Errorf("cant read metadata from s3: %w", err)
 	}
 	defer obj.Body.Close()
 	meta, err := readMetadata(obj.Body)
 	if err != nil {
-		return nil, fmt.Errorf("cant read metadata: %w", err)
+		return nil, "", fmt.Errorf("cant read metadata: %w", err)
+	}
+	return meta, aws.StringValue(obj.ETag), nil
+}
+
+// updateMetadata applies update to fresh metadata and saves it only if metadata was not changed in between,
+// so concurrent updates of different fields do not overwrite each other
+func (s *s3SUserSCopedStorage) updateMetadata(ctx context.Context, update func(meta *Metadata) error) (*Metadata, error) {
+	for attempt := 1; ; attempt++ {
+		meta, etag, err := s.getMetadata(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := update(meta); err != nil {
+			return nil, err
+		}
+		err = putS3Metadata(ctx, s.client, s.bucket, meta, s3IfMatch(etag))
+		if err == nil {
+			return meta, nil
+		}
+		if !isS3Conflict(err) || attempt >= s3MetadataUpdateAttempts {
+			return nil, err
+		}
 	}
-	return meta, nil
 }
 
 func (s *s3SUserSCopedStorage) Upload(ctx context.Context, objPath string, contentType string, file io.Reader) error {
@@ -54,6 +85,44 @@ func (s *s3SUserSCopedStorage) Upload(ctx context.Context, objPath string, conte
 	return nil
 }
 
+func (s *s3SUserSCopedStorage) Download(ctx context.Context, objPath string, offset int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.getFilePath(objPath)),
+	}
+	if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+	obj, err := s.client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("cant download file: %w", err)
+	}
+	return obj.Body, nil
+}
+
+func (s *s3SUserSCopedStorage) Stat(ctx context.Context, objPath string) (*FileInList, error) {
+	obj, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.getFilePath(objPath)),
+	})
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("cant stat file: %w", err)
+	}
+	return &FileInList{
+		Path:           strings.TrimLeft(objPath, "/"),
+		LastModifiedAt: aws.TimeValue(obj.LastModified),
+		Size:           int(aws.Int64Value(obj.ContentLength)),
+		ETag:           aws.StringValue(obj.ETag),
+		ContentType:    aws.StringValue(obj.ContentType),
+	}, nil
+}
+
 func (s *s3SUserSCopedStorage) Delete(ctx context.Context, objPath string) error {
 	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucket),
@@ -79,22 +148,24 @@ func (s *s3SUserSCopedStorage) GenerateDownloadLink(ctx context.Context, objPath
 }
 
 func (s *s3SUserSCopedStorage) ListFiles(ctx context.Context) ([]FileInList, error) {
-	output, err := s.client.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+	listing := []FileInList{}
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucket),
-		Prefix: aws.String(s.email + "/files/"),
+		Prefix: aws.String(s.filesPrefix),
+	}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range output.Contents {
+			listing = append(listing, FileInList{
+				Path:           strings.TrimPrefix(*obj.Key, s.filesPrefix),
+				LastModifiedAt: *obj.LastModified,
+				Size:           int(*obj.Size),
+				ETag:           aws.StringValue(obj.ETag),
+			})
+		}
+		return true
 	})
 	if err != nil {
 		return nil, fmt.Errorf("cant list s3 files: %w", err)
 	}
-
-	listing := make([]FileInList, 0, len(output.Contents))
-	for _, obj := range output.Contents {
-		listing = append(listing, FileInList{
-			Path:           strings.TrimPrefix(*obj.Key, s.email+"/files/"),
-			LastModifiedAt: *obj.LastModified,
-			Size:           int(*obj.Size),
-		})
-	}
 	sort.SliceStable(listing, func(i, j int) bool {
 		return listing[j].LastModifiedAt.Before(listing[i].LastModifiedAt)
 	})
@@ -104,7 +175,7 @@ func (s *s3SUserSCopedStorage) ListFiles(ctx context.Context) ([]FileInList, err
 func (s *s3SUserSCopedStorage) Move(ctx context.Context, objPathOld string, objPathNew string) error {
 	_, err := s.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
 		Bucket:     aws.String(s.bucket),
-		CopySource: aws.String(s.bucket + "/" + s.getFilePath(objPathOld)),
+		CopySource: aws.String(url.PathEscape(s.bucket + "/" + s.getFilePath(objPathOld))),
 		Key:        aws.String(s.getFilePath(objPathNew)),
 	})
 	if err != nil {
@@ -121,3 +192,58 @@ func (s *s3SUserSCopedStorage) Move(ctx context.Context, objPathOld string, objP
 	return nil
 
 }
+
+func (s *s3SUserSCopedStorage) RotateSecrets(ctx context.Context) (*Metadata, error) {
+	oldShareId := ""
+	meta, err := s.updateMetadata(ctx, func(meta *Metadata) error {
+		oldShareId = meta.ShareId
+		meta.Secret = uuid.New().String()
+		meta.ApiToken = newApiToken()
+		meta.ShareId = newShareId()
+		// index of attempt, which lost race, is left, but it does not resolve, because share id differs from metadata
+		return putS3ShareIndex(ctx, s.client, s.bucket, meta)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cant save rotated secrets: %w", err)
+	}
+	if _, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(getS3ShareIndexPath(oldShareId)),
+	}); err != nil {
+		return nil, fmt.Errorf("cant delete old share index: %w", err)
+	}
+	return meta, nil
+}
+
+func (s *s3SUserSCopedStorage) SetDisabled(ctx context.Context, disabled bool) error {
+	_, err := s.updateMetadata(ctx, func(meta *Metadata) error {
+		meta.Disabled = disabled
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cant save disabled flag: %w", err)
+	}
+	return nil
+}
+
+func (s *s3SUserSCopedStorage) SetWebhooks(ctx context.Context, webhooks []Webhook) error {
+	_, err := s.updateMetadata(ctx, func(meta *Metadata) error {
+		meta.Webhooks = webhooks
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cant save webhooks: %w", err)
+	}
+	return nil
+}
+
+func (s *s3SUserSCopedStorage) SetGroups(ctx context.Context, groups []string) error {
+	_, err := s.updateMetadata(ctx, func(meta *Metadata) error {
+		meta.Groups = groups
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cant save groups: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestS3ListFilesPagination(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    int
+		pageSize int
+		requests int
+	}{
+		{name: "empty", files: 0, pageSize: 2, requests: 1},
+		{name: "single page", files: 2, pageSize: 2, requests: 1},
+		{name: "several pages", files: 5, pageSize: 2, requests: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeS3(t)
+			userStorage, err := NewS3Storage(client, fakeS3Bucket).OpenStorage(context.Background(), "user@example.com", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range tt.files {
+				fake.put(getS3FilesPrefix("user@example.com")+fmt.Sprintf("file-%d", i), "data")
+			}
+			// files of another user must not be listed
+			fake.put(getS3FilesPrefix("other@example.com")+"file", "data")
+			fake.pageSize = tt.pageSize
+			listsBefore := fake.count("list")
+
+			listing, err := userStorage.ListFiles(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(listing) != tt.files {
+				t.Fatalf("listed %d files, want %d", len(listing), tt.files)
+			}
+			if requests := fake.count("list") - listsBefore; requests != tt.requests {
+				t.Fatalf("made %d list requests, want %d", requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestS3MetadataUpdateKeepsConcurrentChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(ctx context.Context, userStorage UserScopedStorage) error
+		// concurrent changes metadata between read and write of update
+		concurrent func(meta *Metadata)
+		// check verifies that both update and concurrent change are kept
+		check func(meta *Metadata) bool
+	}{
+		{
+			name: "set groups",
+			update: func(ctx context.Context, userStorage UserScopedStorage) error {
+				return userStorage.SetGroups(ctx, []string{"staff"})
+			},
+			concurrent: func(meta *Metadata) { meta.Disabled = true },
+			check:      func(meta *Metadata) bool { return meta.Disabled && len(meta.Groups) == 1 },
+		},
+		{
+			name: "set webhooks",
+			update: func(ctx context.Context, userStorage UserScopedStorage) error {
+				return userStorage.SetWebhooks(ctx, []Webhook{{Url: "https://example.com/hook"}})
+			},
+			concurrent: func(meta *Metadata) { meta.Groups = []string{"staff"} },
+			check:      func(meta *Metadata) bool { return len(meta.Groups) == 1 && len(meta.Webhooks) == 1 },
+		},
+		{
+			name: "set disabled",
+			update: func(ctx context.Context, userStorage UserScopedStorage) error {
+				return userStorage.SetDisabled(ctx, true)
+			},
+			concurrent: func(meta *Metadata) { meta.Webhooks = []Webhook{{Url: "https://example.com/hook"}} },
+			check:      func(meta *Metadata) bool { return meta.Disabled && len(meta.Webhooks) == 1 },
+		},
+		{
+			name: "rotate secrets",
+			update: func(ctx context.Context, userStorage UserScopedStorage) error {
+				_, err := userStorage.RotateSecrets(ctx)
+				return err
+			},
+			concurrent: func(meta *Metadata) { meta.Groups = []string{"staff"} },
+			check:      func(meta *Metadata) bool { return len(meta.Groups) == 1 },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fake, client := newFakeS3(t)
+			userStorage, err := NewS3Storage(client, fakeS3Bucket).OpenStorage(ctx, "user@example.com", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			metaPath := getS3MetadataPath("user@example.com")
+			changes := 0
+			// metadata is changed right after update read it, twice, so update has to retry more than once
+			fake.afterGet = func(key string) {
+				if key != metaPath || changes >= 2 {
+					return
+				}
+				changes++
+				meta, err := readMetadata(bytes.NewReader(fake.objs[key]))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				tt.concurrent(meta)
+				// every change differs, so etag changes even if concurrent change is repeated
+				meta.Secret = fmt.Sprintf("changed-%d", changes)
+				fake.objs[key], _ = meta.marshal()
+			}
+
+			if err := tt.update(ctx, userStorage); err != nil {
+				t.Fatal(err)
+			}
+			fake.afterGet = nil
+			if conflicts := fake.count("put_precondition_failed"); conflicts != 2 {
+				t.Fatalf("%d conflicts, want 2", conflicts)
+			}
+			meta, err := userStorage.GetMetadata(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(meta) {
+				t.Fatalf("update or concurrent change is lost: %+v", meta)
+			}
+		})
+	}
+}
+
+func TestS3MoveSpecialCharacters(t *testing.T) {
+	names := []string{"with space.txt", "a+b.txt", "100%.txt", "100%25.txt", "dir/résumé ü.txt", "what?#.txt"}
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			fake, client := newFakeS3(t)
+			userStorage, err := NewS3Storage(client, fakeS3Bucket).OpenStorage(context.Background(), "user@example.com", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prefix := getS3FilesPrefix("user@example.com")
+			fake.put(prefix+name, "data")
+
+			if err := userStorage.Move(context.Background(), name, "moved/"+name); err != nil {
+				t.Fatal(err)
+			}
+			if data, ok := fake.get(prefix + "moved/" + name); !ok || data != "data" {
+				t.Fatalf("moved file has %q, exists %t, want data", data, ok)
+			}
+			if _, ok := fake.get(prefix + name); ok {
+				t.Fatal("source file is not deleted")
+			}
+		})
+	}
+}
@@ -2,20 +2,44 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
 	"time"
 )
 
+var ErrNotFound = errors.New("object not found")
+var ErrUserDisabled = errors.New("user is disabled")
+
 type UserScopedStorage interface {
 	GetMetadata(ctx context.Context) (*Metadata, error)
 	Upload(ctx context.Context, objPath string, contentType string, file io.Reader) error
+	// Download returns object content starting from offset, ErrNotFound if object does not exist
+	Download(ctx context.Context, objPath string, offset int64) (io.ReadCloser, error)
+	// Stat returns object info, ErrNotFound if object does not exist
+	Stat(ctx context.Context, objPath string) (*FileInList, error)
 	Move(ctx context.Context, objPathOld string, objPathNew string) error
 	Delete(ctx context.Context, objPath string) error
 	GenerateDownloadLink(ctx context.Context, objPath string, expiration time.Duration) (string, error)
 	// ListFiles return list of objects, sorted by last modified desc
 	ListFiles(ctx context.Context) ([]FileInList, error)
+	// RotateSecrets replaces share id, share secret and api token, so all issued links and credentials stop working
+	RotateSecrets(ctx context.Context) (*Metadata, error)
+	SetDisabled(ctx context.Context, disabled bool) error
+	// SetWebhooks replaces all webhooks of user
+	SetWebhooks(ctx context.Context, webhooks []Webhook) error
+	// SetGroups remembers groups of user from identity provider
+	SetGroups(ctx context.Context, groups []string) error
 }
 
 type Storage interface {
+	// OpenStorage returns ErrUserDisabled if user is disabled by admin
 	OpenStorage(ctx context.Context, email string, autoCreate bool) (UserScopedStorage, error)
+	// OpenStorageByShareId returns ErrNotFound if share id is unknown or rotated
+	OpenStorageByShareId(ctx context.Context, shareId string) (UserScopedStorage, error)
+	// OpenStorageAsAdmin opens storage of existing user, even if user is disabled
+	OpenStorageAsAdmin(ctx context.Context, email string) (UserScopedStorage, error)
+	// ListUsers returns metadata of all users, sorted by email
+	ListUsers(ctx context.Context) ([]*Metadata, error)
+	// Ping checks that backend is reachable and accessible by credentials
+	Ping(ctx context.Context) error
 }
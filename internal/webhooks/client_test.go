@@ -0,0 +1,45 @@
+package webhooks
+
+import "testing"
+
+func TestPublicAddressOnly(t *testing.T) {
+	tests := []struct {
+		address  string
+		wantDeny bool
+	}{
+		{address: "93.184.215.14:443"},
+		{address: "[2606:4700::6810:84e5]:443"},
+		{address: "0.0.0.0:80", wantDeny: true},
+		{address: "0.1.2.3:80", wantDeny: true},
+		{address: "10.1.2.3:80", wantDeny: true},
+		{address: "100.64.0.1:80", wantDeny: true},
+		{address: "100.127.255.254:80", wantDeny: true},
+		{address: "127.0.0.1:80", wantDeny: true},
+		{address: "169.254.169.254:80", wantDeny: true},
+		{address: "172.16.0.1:80", wantDeny: true},
+		{address: "192.0.0.8:80", wantDeny: true},
+		{address: "192.168.1.1:80", wantDeny: true},
+		{address: "198.18.0.1:80", wantDeny: true},
+		{address: "224.0.0.1:80", wantDeny: true},
+		{address: "255.255.255.255:80", wantDeny: true},
+		{address: "[::]:80", wantDeny: true},
+		{address: "[::1]:80", wantDeny: true},
+		{address: "[::ffff:127.0.0.1]:80", wantDeny: true},
+		{address: "[::ffff:10.0.0.1]:80", wantDeny: true},
+		{address: "[64:ff9b::a00:1]:80", wantDeny: true},
+		{address: "[64:ff9b:1::a00:1]:80", wantDeny: true},
+		{address: "[2002:a00:1::1]:80", wantDeny: true},
+		{address: "[2001:db8::1]:80", wantDeny: true},
+		{address: "[fd00::1]:80", wantDeny: true},
+		{address: "[fe80::1%eth0]:80", wantDeny: true},
+		{address: "[ff02::1]:80", wantDeny: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := publicAddressOnly("tcp", tt.address, nil)
+			if denied := err != nil; denied != tt.wantDeny {
+				t.Fatalf("denied %v, want %v: %v", denied, tt.wantDeny, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package webhooks
+
+import (
+	"sync"
+	"time"
+)
+
+// deliveriesPerWebhook bounds delivery log, only recent attempts are interesting for debugging
+const deliveriesPerWebhook = 20
+
+// Delivery is one attempt to post event to webhook
+type Delivery struct {
+	PayloadId  string
+	Event      string
+	Path       string
+	Attempt    int
+	Time       time.Time
+	StatusCode int
+	Error      string
+	// NextAttemptAt is zero if delivery succeeded or attempts are exhausted
+	NextAttemptAt time.Time
+}
+
+func (d Delivery) Succeeded() bool {
+	return d.Error == ""
+}
+
+// deliveryLog keeps recent attempts per webhook id in memory of server instance
+type deliveryLog struct {
+	lock       sync.Mutex
+	deliveries map[string][]Delivery
+}
+
+func newDeliveryLog() *deliveryLog {
+	return &deliveryLog{deliveries: map[string][]Delivery{}}
+}
+
+func (l *deliveryLog) add(webhookId string, delivery Delivery) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	deliveries := append([]Delivery{delivery}, l.deliveries[webhookId]...)
+	if len(deliveries) > deliveriesPerWebhook {
+		deliveries = deliveries[:deliveriesPerWebhook]
+	}
+	l.deliveries[webhookId] = deliveries
+}
+
+// list returns attempts sorted by time desc
+func (l *deliveryLog) list(webhookId string) []Delivery {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]Delivery(nil), l.deliveries[webhookId]...)
+}
+
+func (l *deliveryLog) forget(webhookId string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.deliveries, webhookId)
+}
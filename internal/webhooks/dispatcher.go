@@ -0,0 +1,364 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paragor/sharefile/internal/log"
+	"github.com/paragor/sharefile/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// queueSize bounds memory if receivers are down, overflowed events are written to server logs
+const queueSize = 1024
+
+// userWebhooksTTL is how long webhooks of owner are reused without reading metadata,
+// changes on this replica invalidate cache at once, changes on other replicas are seen after ttl
+const userWebhooksTTL = 30 * time.Second
+
+const (
+	scopeUser   = "user"
+	scopeGlobal = "global"
+)
+
+var deliveriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sharefile_webhook_deliveries_total",
+	Help: "Webhook delivery attempts, result is success, failure or dropped",
+}, []string{"scope", "result"})
+
+type Config struct {
+	// Global webhooks receive events of all users
+	Global  []storage.Webhook
+	Timeout time.Duration
+	// MaxAttempts includes first attempt
+	MaxAttempts int
+	// InitialBackoff is doubled after every failed attempt up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// AllowPrivateNetworks lets user webhooks reach loopback and private addresses, global webhooks always can
+	AllowPrivateNetworks bool
+}
+
+// Dispatcher posts events to global webhooks and to webhooks of event owner from background workers.
+// Failed deliveries are retried with exponential backoff, retries are kept in memory and lost on restart
+type Dispatcher struct {
+	cfg          Config
+	storage      storage.Storage
+	userClient   *http.Client
+	globalClient *http.Client
+	log          *deliveryLog
+
+	events     chan Payload
+	deliveries chan *delivery
+	workers    sync.WaitGroup
+
+	lock    sync.Mutex
+	closed  bool
+	retries map[*delivery]*time.Timer
+
+	cacheLock     sync.Mutex
+	cache         map[string]cachedWebhooks
+	cachePurgedAt time.Time
+}
+
+type cachedWebhooks struct {
+	webhooks []storage.Webhook
+	expireAt time.Time
+}
+
+type delivery struct {
+	webhook storage.Webhook
+	scope   string
+	payload Payload
+	body    []byte
+	attempt int
+}
+
+func NewDispatcher(cfg Config, storage storage.Storage, workers int) *Dispatcher {
+	for i := range cfg.Global {
+		if cfg.Global[i].Id == "" {
+			cfg.Global[i].Id = "global-" + strconv.Itoa(i)
+		}
+	}
+	d := &Dispatcher{
+		cfg:          cfg,
+		storage:      storage,
+		userClient:   newClient(cfg.Timeout, !cfg.AllowPrivateNetworks),
+		globalClient: newClient(cfg.Timeout, false),
+		log:          newDeliveryLog(),
+		events:       make(chan Payload, queueSize),
+		deliveries:   make(chan *delivery, queueSize),
+		retries:      map[*delivery]*time.Timer{},
+		cache:        map[string]cachedWebhooks{},
+	}
+	go d.resolve()
+	for range max(workers, 1) {
+		d.workers.Add(1)
+		go d.worker()
+	}
+	return d
+}
+
+// Notify queues event without blocking request, id and time are filled if empty
+func (d *Dispatcher) Notify(ctx context.Context, payload Payload) {
+	if payload.Id == "" {
+		payload.Id = uuid.NewString()
+	}
+	if payload.Time.IsZero() {
+		payload.Time = time.Now()
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return
+	}
+	select {
+	case d.events <- payload:
+	default:
+		deliveriesTotal.WithLabelValues(scopeUser, "dropped").Inc()
+		log.FromContext(ctx).With(slog.String("event", payload.Event), slog.String("owner", payload.Owner), slog.String("path", payload.Path)).
+			Error("webhook queue is full, event is dropped")
+	}
+}
+
+// Global returns webhooks from config
+func (d *Dispatcher) Global() []storage.Webhook {
+	return d.cfg.Global
+}
+
+// Deliveries returns recent attempts of webhook on this server instance, sorted by time desc
+func (d *Dispatcher) Deliveries(webhookId string) []Delivery {
+	return d.log.list(webhookId)
+}
+
+// Forget drops delivery log of deleted webhook
+func (d *Dispatcher) Forget(webhookId string) {
+	d.log.forget(webhookId)
+}
+
+// Invalidate drops cached webhooks of owner, it should be called after webhooks of owner are changed
+func (d *Dispatcher) Invalidate(owner string) {
+	d.cacheLock.Lock()
+	defer d.cacheLock.Unlock()
+	delete(d.cache, owner)
+}
+
+// resolve finds webhooks subscribed to event, user webhooks are read from metadata of owner
+func (d *Dispatcher) resolve() {
+	defer close(d.deliveries)
+	for payload := range d.events {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.FromContext(context.Background()).With(log.Error(err)).Error("cant marshal webhook payload")
+			continue
+		}
+		for _, webhook := range d.cfg.Global {
+			if subscribed(webhook.Events, payload.Event) {
+				d.deliveries <- &delivery{webhook: webhook, scope: scopeGlobal, payload: payload, body: body}
+			}
+		}
+		webhooks, err := d.userWebhooks(payload.Owner)
+		if err != nil {
+			log.FromContext(context.Background()).With(log.Error(err), slog.String("owner", payload.Owner)).Error("cant read user webhooks")
+			continue
+		}
+		for _, webhook := range webhooks {
+			if subscribed(webhook.Events, payload.Event) {
+				d.deliveries <- &delivery{webhook: webhook, scope: scopeUser, payload: payload, body: body}
+			}
+		}
+	}
+}
+
+// userWebhooks returns cached webhooks of owner, so every event does not cost metadata read
+func (d *Dispatcher) userWebhooks(owner string) ([]storage.Webhook, error) {
+	if owner == "" {
+		return nil, nil
+	}
+	now := time.Now()
+	d.cacheLock.Lock()
+	cached, ok := d.cache[owner]
+	d.cacheLock.Unlock()
+	if ok && now.Before(cached.expireAt) {
+		return cached.webhooks, nil
+	}
+	webhooks, err := d.readUserWebhooks(owner)
+	if err != nil {
+		return nil, err
+	}
+	d.cacheLock.Lock()
+	defer d.cacheLock.Unlock()
+	if now.Sub(d.cachePurgedAt) > userWebhooksTTL {
+		d.cachePurgedAt = now
+		for key, cached := range d.cache {
+			if now.After(cached.expireAt) {
+				delete(d.cache, key)
+			}
+		}
+	}
+	d.cache[owner] = cachedWebhooks{webhooks: webhooks, expireAt: now.Add(userWebhooksTTL)}
+	return webhooks, nil
+}
+
+// readUserWebhooks returns nothing for unknown and disabled users
+func (d *Dispatcher) readUserWebhooks(owner string) ([]storage.Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.cfg.Timeout)
+	defer cancel()
+	userStorage, err := d.storage.OpenStorageAsAdmin(ctx, owner)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	meta, err := userStorage.GetMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Disabled {
+		return nil, nil
+	}
+	return meta.Webhooks, nil
+}
+
+func (d *Dispatcher) worker() {
+	defer d.workers.Done()
+	for delivery := range d.deliveries {
+		d.deliver(delivery)
+	}
+}
+
+func (d *Dispatcher) deliver(delivery *delivery) {
+	delivery.attempt++
+	record := Delivery{
+		PayloadId: delivery.payload.Id,
+		Event:     delivery.payload.Event,
+		Path:      delivery.payload.Path,
+		Attempt:   delivery.attempt,
+		Time:      time.Now(),
+	}
+	statusCode, err := d.post(delivery)
+	record.StatusCode = statusCode
+	if err == nil {
+		deliveriesTotal.WithLabelValues(delivery.scope, "success").Inc()
+		d.log.add(delivery.webhook.Id, record)
+		return
+	}
+	deliveriesTotal.WithLabelValues(delivery.scope, "failure").Inc()
+	record.Error = err.Error()
+	logger := log.FromContext(context.Background()).With(
+		log.Error(err),
+		slog.String("webhook_id", delivery.webhook.Id),
+		slog.String("payload_id", delivery.payload.Id),
+		slog.Int("attempt", delivery.attempt),
+	)
+	if delivery.attempt >= d.cfg.MaxAttempts {
+		d.log.add(delivery.webhook.Id, record)
+		logger.Error("webhook delivery failed, attempts are exhausted")
+		return
+	}
+	backoff := d.backoff(delivery.attempt)
+	record.NextAttemptAt = record.Time.Add(backoff)
+	d.log.add(delivery.webhook.Id, record)
+	logger.With(slog.Float64("backoff_seconds", backoff.Seconds())).Warn("webhook delivery failed, it will be retried")
+	d.retry(delivery, backoff)
+}
+
+func (d *Dispatcher) post(delivery *delivery) (int, error) {
+	client := d.userClient
+	if delivery.scope == scopeGlobal {
+		client = d.globalClient
+	}
+	request, err := http.NewRequest(http.MethodPost, delivery.webhook.Url, bytes.NewReader(delivery.body))
+	if err != nil {
+		return 0, fmt.Errorf("cant create webhook request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "sharefile-webhooks")
+	request.Header.Set(EventHeader, delivery.payload.Event)
+	request.Header.Set(DeliveryHeader, delivery.payload.Id)
+	request.Header.Set(SignatureHeader, Sign(delivery.webhook.Secret, delivery.body))
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("cant post webhook: %w", err)
+	}
+	defer response.Body.Close()
+	// body is drained, so connection is reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+	if response.StatusCode/100 != 2 {
+		return response.StatusCode, fmt.Errorf("webhook responded with %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	backoff := d.cfg.InitialBackoff
+	for i := 1; i < attempt && backoff < d.cfg.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, d.cfg.MaxBackoff)
+}
+
+// retry requeues delivery after backoff, it never blocks, so workers cant deadlock on full queue
+func (d *Dispatcher) retry(delivery *delivery, backoff time.Duration) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return
+	}
+	d.retries[delivery] = time.AfterFunc(backoff, func() {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		delete(d.retries, delivery)
+		if d.closed {
+			return
+		}
+		select {
+		case d.deliveries <- delivery:
+		default:
+			deliveriesTotal.WithLabelValues(delivery.scope, "dropped").Inc()
+			log.FromContext(context.Background()).With(slog.String("webhook_id", delivery.webhook.Id), slog.String("payload_id", delivery.payload.Id)).
+				Error("webhook queue is full, retry is dropped")
+		}
+	})
+}
+
+// Close sends queued events and waits for in-flight deliveries, scheduled retries are dropped
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.lock.Lock()
+	d.closed = true
+	for delivery, timer := range d.retries {
+		timer.Stop()
+		deliveriesTotal.WithLabelValues(delivery.scope, "dropped").Inc()
+	}
+	dropped := len(d.retries)
+	d.retries = map[*delivery]*time.Timer{}
+	close(d.events)
+	d.lock.Unlock()
+	if dropped > 0 {
+		log.FromContext(ctx).With(slog.Int("retries", dropped)).Warn("webhook retries are dropped on shutdown")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("webhook events are not sent: %w", ctx.Err())
+	}
+}
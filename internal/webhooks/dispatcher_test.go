@@ -0,0 +1,221 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paragor/sharefile/internal/storage"
+)
+
+// fakeStorage serves metadata of owners and counts metadata reads
+type fakeStorage struct {
+	storage.Storage
+
+	lock  sync.Mutex
+	metas map[string]*storage.Metadata
+	reads int
+}
+
+func (f *fakeStorage) OpenStorageAsAdmin(_ context.Context, email string) (storage.UserScopedStorage, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.reads++
+	meta, ok := f.metas[email]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	copied := *meta
+	return &fakeUserStorage{meta: &copied}, nil
+}
+
+func (f *fakeStorage) setWebhooks(email string, webhooks []storage.Webhook) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.metas[email] = &storage.Metadata{Email: email, Webhooks: webhooks}
+}
+
+func (f *fakeStorage) readsCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.reads
+}
+
+type fakeUserStorage struct {
+	storage.UserScopedStorage
+	meta *storage.Metadata
+}
+
+func (s *fakeUserStorage) GetMetadata(context.Context) (*storage.Metadata, error) {
+	return s.meta, nil
+}
+
+type receivedRequest struct {
+	delivery  string
+	event     string
+	signature string
+	body      []byte
+}
+
+// receiver fails first failures requests
+type receiver struct {
+	lock     sync.Mutex
+	failures int
+	requests []receivedRequest
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	body, _ := io.ReadAll(request.Body)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.requests = append(r.requests, receivedRequest{
+		delivery:  request.Header.Get(DeliveryHeader),
+		event:     request.Header.Get(EventHeader),
+		signature: request.Header.Get(SignatureHeader),
+		body:      body,
+	})
+	if len(r.requests) <= r.failures {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *receiver) received() []receivedRequest {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]receivedRequest(nil), r.requests...)
+}
+
+func newTestDispatcher(t *testing.T, fake *fakeStorage, maxAttempts int) *Dispatcher {
+	d := NewDispatcher(Config{
+		Timeout:              time.Second,
+		MaxAttempts:          maxAttempts,
+		InitialBackoff:       10 * time.Millisecond,
+		MaxBackoff:           20 * time.Millisecond,
+		AllowPrivateNetworks: true,
+	}, fake, 2)
+	t.Cleanup(func() {
+		_ = d.Close(context.Background())
+	})
+	return d
+}
+
+// waitDeliveries waits until delivery log of webhook has count attempts
+func waitDeliveries(t *testing.T, d *Dispatcher, webhookId string, count int) []Delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if deliveries := d.Deliveries(webhookId); len(deliveries) >= count {
+			return deliveries
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("webhook has %d deliveries, want %d", len(d.Deliveries(webhookId)), count)
+	return nil
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		maxAttempts  int
+		wantAttempts int
+		wantSuccess  bool
+	}{
+		{name: "first attempt", failures: 0, maxAttempts: 3, wantAttempts: 1, wantSuccess: true},
+		{name: "retried", failures: 2, maxAttempts: 3, wantAttempts: 3, wantSuccess: true},
+		{name: "attempts are exhausted", failures: 5, maxAttempts: 3, wantAttempts: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := &receiver{failures: tt.failures}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+			webhook := storage.Webhook{Id: "webhook", Url: server.URL, Secret: "secret", Events: []string{EventUpload}}
+			fake := &fakeStorage{metas: map[string]*storage.Metadata{}}
+			fake.setWebhooks("user@example.com", []storage.Webhook{webhook})
+			d := newTestDispatcher(t, fake, tt.maxAttempts)
+
+			// not subscribed event is not delivered
+			d.Notify(context.Background(), Payload{Event: EventDelete, Owner: "user@example.com", Path: "old.txt"})
+			d.Notify(context.Background(), Payload{Id: "payload", Event: EventUpload, Owner: "user@example.com", Path: "file.txt"})
+			deliveries := waitDeliveries(t, d, webhook.Id, tt.wantAttempts)
+			// late attempts would be logged after expected ones
+			time.Sleep(50 * time.Millisecond)
+
+			requests := receiver.received()
+			if len(requests) != tt.wantAttempts {
+				t.Fatalf("received %d requests, want %d", len(requests), tt.wantAttempts)
+			}
+			for i, request := range requests {
+				if request.delivery != "payload" || request.event != EventUpload {
+					t.Fatalf("request %d: delivery %q event %q, retries should keep payload id", i, request.delivery, request.event)
+				}
+				if request.signature != Sign(webhook.Secret, request.body) {
+					t.Fatalf("request %d: invalid signature %q", i, request.signature)
+				}
+				payload := Payload{}
+				if err := json.Unmarshal(request.body, &payload); err != nil || payload.Path != "file.txt" {
+					t.Fatalf("request %d: unexpected body %s: %v", i, request.body, err)
+				}
+			}
+			last := deliveries[0]
+			if last.Attempt != tt.wantAttempts || last.Succeeded() != tt.wantSuccess {
+				t.Fatalf("last delivery %+v, want attempt %d succeeded %v", last, tt.wantAttempts, tt.wantSuccess)
+			}
+			if !last.NextAttemptAt.IsZero() {
+				t.Fatalf("last delivery is scheduled at %s", last.NextAttemptAt)
+			}
+		})
+	}
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	d := &Dispatcher{cfg: Config{InitialBackoff: 10 * time.Second, MaxBackoff: time.Minute}}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 10 * time.Second},
+		{attempt: 2, want: 20 * time.Second},
+		{attempt: 3, want: 40 * time.Second},
+		{attempt: 4, want: time.Minute},
+		{attempt: 100, want: time.Minute},
+	}
+	for _, tt := range tests {
+		if got := d.backoff(tt.attempt); got != tt.want {
+			t.Fatalf("backoff of attempt %d is %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestDispatcherCachesUserWebhooks(t *testing.T) {
+	receiver := &receiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	fake := &fakeStorage{metas: map[string]*storage.Metadata{}}
+	fake.setWebhooks("user@example.com", []storage.Webhook{{Id: "first", Url: server.URL}})
+	d := newTestDispatcher(t, fake, 1)
+
+	for range 3 {
+		d.Notify(context.Background(), Payload{Event: EventUpload, Owner: "user@example.com"})
+	}
+	waitDeliveries(t, d, "first", 3)
+	if reads := fake.readsCount(); reads != 1 {
+		t.Fatalf("metadata is read %d times, want 1", reads)
+	}
+
+	fake.setWebhooks("user@example.com", []storage.Webhook{{Id: "second", Url: server.URL}})
+	d.Invalidate("user@example.com")
+	d.Notify(context.Background(), Payload{Event: EventUpload, Owner: "user@example.com"})
+	waitDeliveries(t, d, "second", 1)
+	if reads := fake.readsCount(); reads != 2 {
+		t.Fatalf("metadata is read %d times after invalidation, want 2", reads)
+	}
+}
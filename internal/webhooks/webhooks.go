@@ -0,0 +1,77 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+)
+
+const (
+	EventUpload      = "upload"
+	EventDelete      = "delete"
+	EventMove        = "move"
+	EventShareAccess = "share_access"
+)
+
+// Events are all event types which can be subscribed
+var Events = []string{EventUpload, EventDelete, EventMove, EventShareAccess}
+
+const (
+	SignatureHeader = "X-Sharefile-Signature"
+	EventHeader     = "X-Sharefile-Event"
+	DeliveryHeader  = "X-Sharefile-Delivery"
+)
+
+// Payload is posted as json body, receivers deduplicate retries by id
+type Payload struct {
+	Id    string    `json:"id"`
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	// Owner is user whose files are changed or shared
+	Owner string `json:"owner"`
+	// Actor is authenticated user, empty for anonymous share visitors
+	Actor string `json:"actor,omitempty"`
+	Path  string `json:"path,omitempty"`
+	// NewPath is destination of move
+	NewPath string `json:"new_path,omitempty"`
+	// Interface is web, rss, raw, webdav, s3 or admin
+	Interface string `json:"interface,omitempty"`
+}
+
+// Sign returns value of SignatureHeader: hex of hmac-sha256 of body with webhook secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func ValidateUrl(rawUrl string) error {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return fmt.Errorf("cant parse webhook url: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("webhook url should be http or https")
+	}
+	if parsed.Hostname() == "" {
+		return fmt.Errorf("webhook url has no host")
+	}
+	return nil
+}
+
+func ValidateEvents(events []string) error {
+	for _, event := range events {
+		if !slices.Contains(Events, event) {
+			return fmt.Errorf("unknown webhook event '%s'", event)
+		}
+	}
+	return nil
+}
+
+func subscribed(events []string, event string) bool {
+	return len(events) == 0 || slices.Contains(events, event)
+}
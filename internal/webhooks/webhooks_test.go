@@ -0,0 +1,64 @@
+package webhooks
+
+import "testing"
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		body   string
+		want   string
+	}{
+		{name: "empty", secret: "", body: "", want: "sha256=b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad"},
+		{name: "known vector", secret: "key", body: "The quick brown fox jumps over the lazy dog", want: "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, []byte(tt.body)); got != tt.want {
+				t.Fatalf("signature %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateUrl(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{url: "https://example.com/hook"},
+		{url: "http://example.com:8080/hook?token=1"},
+		{url: "ftp://example.com/hook", wantErr: true},
+		{url: "file:///etc/passwd", wantErr: true},
+		{url: "https:///hook", wantErr: true},
+		{url: "example.com/hook", wantErr: true},
+		{url: "://", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if err := ValidateUrl(tt.url); (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSubscribed(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		event  string
+		want   bool
+	}{
+		{name: "all events", event: EventUpload, want: true},
+		{name: "subscribed", events: []string{EventDelete, EventUpload}, event: EventUpload, want: true},
+		{name: "not subscribed", events: []string{EventDelete}, event: EventUpload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := subscribed(tt.events, tt.event); got != tt.want {
+				t.Fatalf("subscribed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -16,9 +20,14 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/paragor/sharefile/internal/audit"
 	"github.com/paragor/sharefile/internal/httpserver"
 	"github.com/paragor/sharefile/internal/log"
+	"github.com/paragor/sharefile/internal/sessions"
 	"github.com/paragor/sharefile/internal/storage"
+	"github.com/paragor/sharefile/internal/tracing"
+	"github.com/paragor/sharefile/internal/webhooks"
+	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/yaml.v2"
 )
 
@@ -27,16 +36,144 @@ type Config struct {
 	ServerPublicUrl            string `yaml:"server_public_url"`
 	DiagnosticEndpointsEnabled bool   `yaml:"diagnostic_endpoints_enabled"`
 	RssExpirationLinkHours     int    `yaml:"rss_expiration_link_hours"`
+	// LegacyShareUrls keeps working share and rss links with email, which were issued before opaque share ids
+	LegacyShareUrls bool `yaml:"legacy_share_urls"`
+	// TrustedProxies is list of cidrs, which are allowed to pass auth headers and client ip in X-Forwarded-For.
+	// It is required behind reverse proxy, otherwise all clients share one rate limit.
+	// Failed guesses are throttled per client ip only if it is set
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// Logging level is one of debug, info, warn or error, format is json or text, empty file means stderr
+	Logging struct {
+		Level      string `yaml:"level"`
+		Format     string `yaml:"format"`
+		File       string `yaml:"file"`
+		MaxSizeMb  int    `yaml:"max_size_mb"`
+		MaxBackups int    `yaml:"max_backups"`
+	} `yaml:"logging"`
+
+	// SecurityHeaders empty values disable corresponding header, {nonce} in csp is replaced by nonce of response
+	SecurityHeaders struct {
+		Enabled               bool   `yaml:"enabled"`
+		ContentSecurityPolicy string `yaml:"content_security_policy"`
+		HstsMaxAgeSeconds     int    `yaml:"hsts_max_age_seconds"`
+		FrameOptions          string `yaml:"frame_options"`
+		ReferrerPolicy        string `yaml:"referrer_policy"`
+		PermissionsPolicy     string `yaml:"permissions_policy"`
+	} `yaml:"security_headers"`
+
+	// RateLimit is token bucket per user email or client ip, zero requests_per_second disables group.
+	// Enable it only with trusted_proxies if server is behind reverse proxy
+	RateLimit struct {
+		Enabled bool            `yaml:"enabled"`
+		Public  RateLimitConfig `yaml:"public"`
+		Htmx    RateLimitConfig `yaml:"htmx"`
+		Api     RateLimitConfig `yaml:"api"`
+	} `yaml:"rate_limit"`
+
+	// Readiness caches results of storage and oidc checks of /readyz
+	Readiness struct {
+		CacheSeconds   int `yaml:"cache_seconds"`
+		TimeoutSeconds int `yaml:"timeout_seconds"`
+	} `yaml:"readiness"`
+
+	// Tracing exports spans of requests and storage operations by otlp http
+	Tracing struct {
+		Enabled     bool    `yaml:"enabled"`
+		Endpoint    string  `yaml:"endpoint"`
+		ServiceName string  `yaml:"service_name"`
+		SampleRatio float64 `yaml:"sample_ratio"`
+	} `yaml:"tracing"`
+
+	ProxyAuth struct {
+		Enabled       bool     `yaml:"enabled"`
+		EmailHeader   string   `yaml:"email_header"`
+		GroupsHeader  string   `yaml:"groups_header"`
+		AllowedGroups []string `yaml:"allowed_groups"`
+	} `yaml:"proxy_auth"`
+
+	LocalAuth struct {
+		Enabled bool              `yaml:"enabled"`
+		Users   []LocalUserConfig `yaml:"users"`
+	} `yaml:"local_auth"`
+
+	// Sessions of oidc and local users, type is one of memory, storage or redis.
+	// Memory sessions are lost on restart and not shared between replicas.
+	// Oidc tokens of storage and redis sessions are encrypted by cookie key.
+	Sessions struct {
+		Type     string `yaml:"type"`
+		TTLHours int    `yaml:"ttl_hours"`
+		Redis    struct {
+			Address   string `yaml:"address"`
+			Password  string `yaml:"password"`
+			DB        int    `yaml:"db"`
+			KeyPrefix string `yaml:"key_prefix"`
+			PoolSize  int    `yaml:"pool_size"`
+		} `yaml:"redis"`
+	} `yaml:"sessions"`
+
+	// Audit writes user actions to sink separate from access log, sink is one of file, storage or webhook
+	Audit struct {
+		Enabled bool   `yaml:"enabled"`
+		Sink    string `yaml:"sink"`
+		File    struct {
+			Path string `yaml:"path"`
+		} `yaml:"file"`
+		// Storage uploads events as daily objects under _audit/ of storage bucket
+		Storage struct {
+			FlushIntervalSeconds int `yaml:"flush_interval_seconds"`
+		} `yaml:"storage"`
+		Webhook struct {
+			Url            string `yaml:"url"`
+			TimeoutSeconds int    `yaml:"timeout_seconds"`
+		} `yaml:"webhook"`
+	} `yaml:"audit"`
+
+	// Webhooks post signed file events to urls registered by users and to global urls of admin
+	Webhooks struct {
+		Enabled               bool `yaml:"enabled"`
+		TimeoutSeconds        int  `yaml:"timeout_seconds"`
+		MaxAttempts           int  `yaml:"max_attempts"`
+		InitialBackoffSeconds int  `yaml:"initial_backoff_seconds"`
+		MaxBackoffSeconds     int  `yaml:"max_backoff_seconds"`
+		Workers               int  `yaml:"workers"`
+		// AllowPrivateNetworks lets user webhooks reach loopback and private addresses
+		AllowPrivateNetworks bool                  `yaml:"allow_private_networks"`
+		Global               []GlobalWebhookConfig `yaml:"global"`
+	} `yaml:"webhooks"`
+
+	// Admin grants access to admin console by group or email
+	Admin struct {
+		Groups []string `yaml:"groups"`
+		Emails []string `yaml:"emails"`
+	} `yaml:"admin"`
 
 	Oidc struct {
-		ClientId     string   `yaml:"client_id"`
-		ClientSecret string   `yaml:"client_secret"`
-		IssuerUrl    string   `yaml:"issuer_url"`
-		CookieKey    string   `yaml:"cookie_key"`
-		Scopes       []string `yaml:"scopes"`
-		AllowedGroup string   `yaml:"allowed_group"`
+		ClientId      string   `yaml:"client_id"`
+		ClientSecret  string   `yaml:"client_secret"`
+		IssuerUrl     string   `yaml:"issuer_url"`
+		CookieKey     string   `yaml:"cookie_key"`
+		Scopes        []string `yaml:"scopes"`
+		AllowedGroup  string   `yaml:"allowed_group"`
+		AllowedGroups []string `yaml:"allowed_groups"`
+
+		// IdentityClaim preferred_username and sub are prefixed by provider name, e.g. "oidc:alice" for legacy provider,
+		// admin emails should use prefixed form
+		IdentityClaim        string `yaml:"identity_claim"`
+		AllowUnverifiedEmail bool   `yaml:"allow_unverified_email"`
+		// AllowedEmailDomains is required for every provider with email identity, if several providers are configured
+		AllowedEmailDomains []string `yaml:"allowed_email_domains"`
+		GroupsClaim         string   `yaml:"groups_claim"`
+		GroupsFromUserinfo  bool     `yaml:"groups_from_userinfo"`
+
+		Providers []OidcProviderConfig `yaml:"providers"`
 	} `yaml:"oidc"`
 
+	Policies struct {
+		Default PolicyConfig            `yaml:"default"`
+		Groups  map[string]PolicyConfig `yaml:"groups"`
+	} `yaml:"policies"`
+
 	Storage struct {
 		Type string `yaml:"type"`
 		S3   struct {
@@ -51,19 +188,145 @@ type Config struct {
 	} `yaml:"storage"`
 }
 
+type OidcProviderConfig struct {
+	Name          string   `yaml:"name"`
+	DisplayName   string   `yaml:"display_name"`
+	ClientId      string   `yaml:"client_id"`
+	ClientSecret  string   `yaml:"client_secret"`
+	IssuerUrl     string   `yaml:"issuer_url"`
+	Scopes        []string `yaml:"scopes"`
+	AllowedGroup  string   `yaml:"allowed_group"`
+	AllowedGroups []string `yaml:"allowed_groups"`
+
+	IdentityClaim        string   `yaml:"identity_claim"`
+	AllowUnverifiedEmail bool     `yaml:"allow_unverified_email"`
+	AllowedEmailDomains  []string `yaml:"allowed_email_domains"`
+	GroupsClaim          string   `yaml:"groups_claim"`
+	GroupsFromUserinfo   bool     `yaml:"groups_from_userinfo"`
+}
+
+// LocalUserConfig password hash is bcrypt hash, it could be generated by --hash-password
+type LocalUserConfig struct {
+	Email        string   `yaml:"email"`
+	PasswordHash string   `yaml:"password_hash"`
+	Groups       []string `yaml:"groups"`
+}
+
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+func (c RateLimitConfig) toRateLimit() *httpserver.RateLimit {
+	if c.RequestsPerSecond <= 0 {
+		return nil
+	}
+	return &httpserver.RateLimit{RequestsPerSecond: c.RequestsPerSecond, Burst: max(c.Burst, 1)}
+}
+
+// GlobalWebhookConfig receives events of all users, empty events means all
+type GlobalWebhookConfig struct {
+	Url    string   `yaml:"url"`
+	Secret string   `yaml:"secret"`
+	Events []string `yaml:"events"`
+}
+
+// PolicyConfig limits are in megabytes, 0 means unlimited
+type PolicyConfig struct {
+	MaxUploadSizeMb int64 `yaml:"max_upload_size_mb"`
+	QuotaMb         int64 `yaml:"quota_mb"`
+	PublicShares    bool  `yaml:"public_shares"`
+}
+
+func (p PolicyConfig) toAuthPolicy() *httpserver.AuthPolicy {
+	return &httpserver.AuthPolicy{
+		MaxUploadSizeBytes: p.MaxUploadSizeMb * 1024 * 1024,
+		QuotaBytes:         p.QuotaMb * 1024 * 1024,
+		PublicShares:       p.PublicShares,
+	}
+}
+
+func allowedGroups(allowedGroup string, allowedGroups []string) []string {
+	if allowedGroup == "" {
+		return allowedGroups
+	}
+	return append([]string{allowedGroup}, allowedGroups...)
+}
+
 func main() {
 	logger := log.FromContext(context.Background())
 
 	configPath := flag.String("config", "config.yaml", "path to config")
 	dumpDefaultConfig := flag.Bool("dump-default-config", false, "dump default config")
+	hashPassword := flag.Bool("hash-password", false, "read password from stdin and print its bcrypt hash for local_auth")
+	migrateStorage := flag.Bool("migrate-storage", false, "move users of s3 bucket from legacy email keys to hashed keys and exit")
 	flag.Parse()
 
+	if *hashPassword {
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			logger.With(log.Error(err)).Error("fail to read password")
+			os.Exit(1)
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(strings.TrimRight(password, "\r\n")), bcrypt.DefaultCost)
+		if err != nil {
+			logger.With(log.Error(err)).Error("fail to hash password")
+			os.Exit(1)
+		}
+		fmt.Println(string(hash))
+		os.Exit(0)
+	}
+
 	cfg := &Config{}
 	cfg.Listen = "127.0.0.1:8080"
 	cfg.ServerPublicUrl = "http://127.0.0.1:8080"
 	cfg.Oidc.Scopes = []string{"openid", "email", "profile", "offline_access"}
+	cfg.Oidc.IdentityClaim = httpserver.IdentityClaimEmail
+	cfg.Oidc.GroupsClaim = "groups"
+	cfg.Oidc.AllowedEmailDomains = []string{}
 	cfg.Storage.Type = "s3"
 	cfg.RssExpirationLinkHours = 1
+	cfg.Policies.Default.PublicShares = true
+	cfg.TrustedProxies = []string{}
+	cfg.Logging.Level = "info"
+	cfg.Logging.Format = log.FormatJson
+	cfg.Logging.MaxSizeMb = 100
+	cfg.Logging.MaxBackups = 5
+	cfg.SecurityHeaders.Enabled = true
+	cfg.SecurityHeaders.ContentSecurityPolicy = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self'; img-src 'self' data:; object-src 'none'; base-uri 'none'; frame-ancestors 'none'; form-action 'self'"
+	cfg.SecurityHeaders.HstsMaxAgeSeconds = 365 * 24 * 60 * 60
+	cfg.SecurityHeaders.FrameOptions = "DENY"
+	cfg.SecurityHeaders.ReferrerPolicy = "no-referrer"
+	cfg.SecurityHeaders.PermissionsPolicy = "camera=(), microphone=(), geolocation=(), payment=()"
+	cfg.RateLimit.Enabled = false
+	cfg.RateLimit.Public = RateLimitConfig{RequestsPerSecond: 5, Burst: 30}
+	cfg.RateLimit.Htmx = RateLimitConfig{RequestsPerSecond: 10, Burst: 50}
+	cfg.RateLimit.Api = RateLimitConfig{RequestsPerSecond: 10, Burst: 50}
+	cfg.Readiness.CacheSeconds = 10
+	cfg.Readiness.TimeoutSeconds = 3
+	cfg.Tracing.Endpoint = "http://127.0.0.1:4318/v1/traces"
+	cfg.Tracing.ServiceName = "sharefile"
+	cfg.Tracing.SampleRatio = 1
+	cfg.ProxyAuth.EmailHeader = "X-Forwarded-Email"
+	cfg.ProxyAuth.GroupsHeader = "X-Forwarded-Groups"
+	cfg.Sessions.Type = "storage"
+	cfg.Sessions.TTLHours = 24 * 7
+	cfg.Sessions.Redis.Address = "127.0.0.1:6379"
+	cfg.Sessions.Redis.KeyPrefix = "sharefile:"
+	cfg.Sessions.Redis.PoolSize = 10
+	cfg.LocalAuth.Users = []LocalUserConfig{}
+	cfg.Audit.Sink = "file"
+	cfg.Audit.File.Path = "audit.log"
+	cfg.Audit.Storage.FlushIntervalSeconds = 60
+	cfg.Audit.Webhook.TimeoutSeconds = 5
+	cfg.Webhooks.TimeoutSeconds = 10
+	cfg.Webhooks.MaxAttempts = 6
+	cfg.Webhooks.InitialBackoffSeconds = 10
+	cfg.Webhooks.MaxBackoffSeconds = 600
+	cfg.Webhooks.Workers = 4
+	cfg.Webhooks.Global = []GlobalWebhookConfig{}
+	cfg.Admin.Groups = []string{}
+	cfg.Admin.Emails = []string{}
 
 	if *dumpDefaultConfig {
 		cfg.Oidc.CookieKey = "kiel4teof4Eoziheigiesh7ooquiepho"
@@ -83,6 +346,53 @@ func main() {
 		logger.With(log.Error(err), slog.String("path", *configPath)).Error("fail to unmarshal config")
 		os.Exit(1)
 	}
+	if err := log.Setup(log.Config{
+		Level:      cfg.Logging.Level,
+		Format:     cfg.Logging.Format,
+		File:       cfg.Logging.File,
+		MaxSizeMb:  cfg.Logging.MaxSizeMb,
+		MaxBackups: cfg.Logging.MaxBackups,
+	}); err != nil {
+		logger.With(log.Error(err)).Error("invalid logging config")
+		os.Exit(1)
+	}
+	logger = log.FromContext(context.Background())
+
+	if *migrateStorage {
+		if cfg.Storage.Type != "s3" {
+			logger.With(slog.String("type", cfg.Storage.Type)).Error("migration supports only s3 storage")
+			os.Exit(1)
+		}
+		s3Client, err := newS3Client(cfg)
+		if err != nil {
+			logger.With(log.Error(err)).Error("fail to init s3 client")
+			os.Exit(1)
+		}
+		if err := storage.MigrateS3Storage(context.Background(), s3Client, cfg.Storage.S3.Bucket); err != nil {
+			logger.With(log.Error(err)).Error("fail to migrate storage")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if cfg.Tracing.Enabled {
+		shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+			Endpoint:    cfg.Tracing.Endpoint,
+			ServiceName: cfg.Tracing.ServiceName,
+			SampleRatio: cfg.Tracing.SampleRatio,
+		})
+		if err != nil {
+			logger.With(log.Error(err)).Error("fail to init tracing")
+			os.Exit(1)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdownTracing(ctx); err != nil {
+				logger.With(log.Error(err)).Error("fail to flush traces")
+			}
+		}()
+	}
 
 	var storageInstance storage.Storage
 	switch cfg.Storage.Type {
@@ -96,27 +406,230 @@ func main() {
 		logger.With(slog.String("type", cfg.Storage.Type)).Error("unsupported storage type")
 		os.Exit(1)
 	}
-	auth := &httpserver.AuthOidcConfig{
-		ClientId:     cfg.Oidc.ClientId,
-		ClientSecret: cfg.Oidc.ClientSecret,
-		IssuerUrl:    cfg.Oidc.IssuerUrl,
-		CookieKey:    cfg.Oidc.CookieKey,
-		Scopes:       cfg.Oidc.Scopes,
-		AllowedGroup: cfg.Oidc.AllowedGroup,
+	storageInstance = storage.NewInstrumentedStorage(cfg.Storage.Type, storageInstance)
+	auth := &httpserver.AuthConfig{
+		CookieKey:     cfg.Oidc.CookieKey,
+		SessionTTL:    time.Hour * time.Duration(cfg.Sessions.TTLHours),
+		DefaultPolicy: cfg.Policies.Default.toAuthPolicy(),
+		GroupPolicies: map[string]*httpserver.AuthPolicy{},
+	}
+	for group, policy := range cfg.Policies.Groups {
+		auth.GroupPolicies[group] = policy.toAuthPolicy()
+	}
+	if cfg.ProxyAuth.Enabled {
+		auth.Proxy = &httpserver.AuthProxyConfig{
+			EmailHeader:   cfg.ProxyAuth.EmailHeader,
+			GroupsHeader:  cfg.ProxyAuth.GroupsHeader,
+			AllowedGroups: cfg.ProxyAuth.AllowedGroups,
+		}
+	}
+	if len(cfg.Admin.Groups) > 0 || len(cfg.Admin.Emails) > 0 {
+		auth.Admin = &httpserver.AuthAdminConfig{
+			Groups: cfg.Admin.Groups,
+			Emails: cfg.Admin.Emails,
+		}
+	}
+	if cfg.LocalAuth.Enabled {
+		auth.Local = &httpserver.AuthLocalConfig{}
+		for _, user := range cfg.LocalAuth.Users {
+			auth.Local.Users = append(auth.Local.Users, httpserver.AuthLocalUser{
+				Email:        user.Email,
+				PasswordHash: user.PasswordHash,
+				Groups:       user.Groups,
+			})
+		}
+	}
+	if cfg.Oidc.IssuerUrl != "" {
+		auth.Oidc = append(auth.Oidc, &httpserver.AuthOidcConfig{
+			ClientId:      cfg.Oidc.ClientId,
+			ClientSecret:  cfg.Oidc.ClientSecret,
+			IssuerUrl:     cfg.Oidc.IssuerUrl,
+			Scopes:        cfg.Oidc.Scopes,
+			AllowedGroups: allowedGroups(cfg.Oidc.AllowedGroup, cfg.Oidc.AllowedGroups),
+
+			IdentityClaim:        cfg.Oidc.IdentityClaim,
+			AllowUnverifiedEmail: cfg.Oidc.AllowUnverifiedEmail,
+			AllowedEmailDomains:  cfg.Oidc.AllowedEmailDomains,
+			GroupsClaim:          cfg.Oidc.GroupsClaim,
+			GroupsFromUserinfo:   cfg.Oidc.GroupsFromUserinfo,
+		})
+	}
+	for _, provider := range cfg.Oidc.Providers {
+		scopes := provider.Scopes
+		if len(scopes) == 0 {
+			scopes = cfg.Oidc.Scopes
+		}
+		auth.Oidc = append(auth.Oidc, &httpserver.AuthOidcConfig{
+			Name:          provider.Name,
+			DisplayName:   provider.DisplayName,
+			ClientId:      provider.ClientId,
+			ClientSecret:  provider.ClientSecret,
+			IssuerUrl:     provider.IssuerUrl,
+			Scopes:        scopes,
+			AllowedGroups: allowedGroups(provider.AllowedGroup, provider.AllowedGroups),
+
+			IdentityClaim:        provider.IdentityClaim,
+			AllowUnverifiedEmail: provider.AllowUnverifiedEmail,
+			AllowedEmailDomains:  provider.AllowedEmailDomains,
+			GroupsClaim:          provider.GroupsClaim,
+			GroupsFromUserinfo:   provider.GroupsFromUserinfo,
+		})
 	}
 	if err := auth.Validate(); err != nil {
 		logger.With(log.Error(err)).Error("invalid oauth config")
 		os.Exit(1)
 	}
+	trustedProxies := make([]netip.Prefix, 0, len(cfg.TrustedProxies))
+	for _, cidr := range cfg.TrustedProxies {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			logger.With(log.Error(err), slog.String("cidr", cidr)).Error("invalid trusted proxy cidr")
+			os.Exit(1)
+		}
+		trustedProxies = append(trustedProxies, prefix)
+	}
+	if cfg.ProxyAuth.Enabled && len(trustedProxies) == 0 {
+		logger.Error("proxy auth requires trusted proxies")
+		os.Exit(1)
+	}
+	if cfg.RateLimit.Enabled && len(trustedProxies) == 0 {
+		logger.Warn("rate limit is enabled without trusted proxies, behind reverse proxy all clients share one limit")
+	}
+	if len(trustedProxies) == 0 {
+		logger.Info("no trusted proxies, failed guesses of passwords, tokens and share links are throttled per target only, not per client ip")
+	}
+
+	var securityHeaders *httpserver.SecurityHeadersConfig
+	if cfg.SecurityHeaders.Enabled {
+		securityHeaders = &httpserver.SecurityHeadersConfig{
+			ContentSecurityPolicy: cfg.SecurityHeaders.ContentSecurityPolicy,
+			HstsMaxAge:            time.Second * time.Duration(cfg.SecurityHeaders.HstsMaxAgeSeconds),
+			FrameOptions:          cfg.SecurityHeaders.FrameOptions,
+			ReferrerPolicy:        cfg.SecurityHeaders.ReferrerPolicy,
+			PermissionsPolicy:     cfg.SecurityHeaders.PermissionsPolicy,
+		}
+	}
+
+	var rateLimit *httpserver.RateLimitConfig
+	if cfg.RateLimit.Enabled {
+		rateLimit = &httpserver.RateLimitConfig{
+			Public: cfg.RateLimit.Public.toRateLimit(),
+			Htmx:   cfg.RateLimit.Htmx.toRateLimit(),
+			Api:    cfg.RateLimit.Api.toRateLimit(),
+		}
+	}
+
+	var sessionStore sessions.Store
+	switch cfg.Sessions.Type {
+	case "memory":
+		sessionStore = sessions.NewMemoryStore()
+	case "storage":
+		if cfg.Storage.Type != "s3" {
+			logger.Error("storage sessions require s3 storage")
+			os.Exit(1)
+		}
+		s3Client, err := newS3Client(cfg)
+		if err != nil {
+			logger.With(log.Error(err)).Error("fail to init s3 client for sessions")
+			os.Exit(1)
+		}
+		sessionStore = sessions.NewS3Store(s3Client, cfg.Storage.S3.Bucket, cfg.Oidc.CookieKey, time.Hour)
+	case "redis":
+		sessionStore = sessions.NewRedisStore(sessions.RedisConfig{
+			Address:   cfg.Sessions.Redis.Address,
+			Password:  cfg.Sessions.Redis.Password,
+			DB:        cfg.Sessions.Redis.DB,
+			KeyPrefix: cfg.Sessions.Redis.KeyPrefix,
+			PoolSize:  cfg.Sessions.Redis.PoolSize,
+		}, cfg.Oidc.CookieKey)
+	default:
+		logger.With(slog.String("type", cfg.Sessions.Type)).Error("unsupported sessions type")
+		os.Exit(1)
+	}
+
+	var auditSink audit.Sink
+	if cfg.Audit.Enabled {
+		switch cfg.Audit.Sink {
+		case "file":
+			auditSink, err = audit.NewFileSink(cfg.Audit.File.Path)
+			if err != nil {
+				logger.With(log.Error(err)).Error("fail to init audit file")
+				os.Exit(1)
+			}
+		case "storage":
+			if cfg.Storage.Type != "s3" {
+				logger.Error("storage audit requires s3 storage")
+				os.Exit(1)
+			}
+			s3Client, err := newS3Client(cfg)
+			if err != nil {
+				logger.With(log.Error(err)).Error("fail to init s3 client for audit")
+				os.Exit(1)
+			}
+			if cfg.Audit.Storage.FlushIntervalSeconds <= 0 {
+				logger.Error("audit flush interval should be > 0")
+				os.Exit(1)
+			}
+			auditSink = audit.NewS3Sink(s3Client, cfg.Storage.S3.Bucket, time.Second*time.Duration(cfg.Audit.Storage.FlushIntervalSeconds))
+		case "webhook":
+			if cfg.Audit.Webhook.Url == "" {
+				logger.Error("audit webhook url is empty")
+				os.Exit(1)
+			}
+			auditSink = audit.NewWebhookSink(cfg.Audit.Webhook.Url, time.Second*time.Duration(cfg.Audit.Webhook.TimeoutSeconds))
+		default:
+			logger.With(slog.String("sink", cfg.Audit.Sink)).Error("unsupported audit sink")
+			os.Exit(1)
+		}
+	}
+
+	var webhookDispatcher *webhooks.Dispatcher
+	if cfg.Webhooks.Enabled {
+		if cfg.Webhooks.MaxAttempts <= 0 || cfg.Webhooks.TimeoutSeconds <= 0 {
+			logger.Error("webhooks max attempts and timeout should be > 0")
+			os.Exit(1)
+		}
+		global := make([]storage.Webhook, 0, len(cfg.Webhooks.Global))
+		for _, webhook := range cfg.Webhooks.Global {
+			if err := webhooks.ValidateUrl(webhook.Url); err != nil {
+				logger.With(log.Error(err), slog.String("url", webhook.Url)).Error("invalid global webhook url")
+				os.Exit(1)
+			}
+			if err := webhooks.ValidateEvents(webhook.Events); err != nil {
+				logger.With(log.Error(err), slog.String("url", webhook.Url)).Error("invalid global webhook events")
+				os.Exit(1)
+			}
+			global = append(global, storage.Webhook{Url: webhook.Url, Secret: webhook.Secret, Events: webhook.Events})
+		}
+		webhookDispatcher = webhooks.NewDispatcher(webhooks.Config{
+			Global:               global,
+			Timeout:              time.Second * time.Duration(cfg.Webhooks.TimeoutSeconds),
+			MaxAttempts:          cfg.Webhooks.MaxAttempts,
+			InitialBackoff:       time.Second * time.Duration(cfg.Webhooks.InitialBackoffSeconds),
+			MaxBackoff:           time.Second * time.Duration(cfg.Webhooks.MaxBackoffSeconds),
+			AllowPrivateNetworks: cfg.Webhooks.AllowPrivateNetworks,
+		}, storageInstance, cfg.Webhooks.Workers)
+	}
 
-	server, err := httpserver.NewHttpServer(
-		cfg.Listen,
-		storageInstance,
-		auth,
-		cfg.ServerPublicUrl,
-		cfg.DiagnosticEndpointsEnabled,
-		time.Hour*time.Duration(cfg.RssExpirationLinkHours),
-	)
+	server, err := httpserver.NewHttpServer(httpserver.Config{
+		Listen:                     cfg.Listen,
+		Storage:                    storageInstance,
+		Auth:                       auth,
+		PublicUrl:                  cfg.ServerPublicUrl,
+		DiagnosticEndpointsEnabled: cfg.DiagnosticEndpointsEnabled,
+		RssExpirationLink:          time.Hour * time.Duration(cfg.RssExpirationLinkHours),
+		TrustedProxies:             trustedProxies,
+		Sessions:                   sessionStore,
+		SecurityHeaders:            securityHeaders,
+		LegacyShareUrls:            cfg.LegacyShareUrls,
+		RateLimit:                  rateLimit,
+		AuditSink:                  auditSink,
+		Webhooks:                   webhookDispatcher,
+		Readiness: &httpserver.ReadinessConfig{
+			CacheTTL: time.Second * time.Duration(cfg.Readiness.CacheSeconds),
+			Timeout:  time.Second * time.Duration(cfg.Readiness.TimeoutSeconds),
+		},
+	})
 	if err != nil {
 		logger.With(log.Error(err)).Error("fail to start server")
 		os.Exit(1)
@@ -144,6 +657,16 @@ func main() {
 			logger.With(log.Error(err)).Error("fail to shutdown server")
 			os.Exit(1)
 		}
+		if auditSink != nil {
+			if err := auditSink.Close(shutdownCtx); err != nil {
+				logger.With(log.Error(err)).Error("fail to flush audit events")
+			}
+		}
+		if webhookDispatcher != nil {
+			if err := webhookDispatcher.Close(shutdownCtx); err != nil {
+				logger.With(log.Error(err)).Error("fail to send webhook events")
+			}
+		}
 	case err := <-serverErrors:
 		logger.With(log.Error(err)).Error("fail on start server")
 		os.Exit(1)
@@ -153,6 +676,17 @@ func main() {
 }
 
 func initS3Storage(cfg *Config) (storage.Storage, error) {
+	s3Client, err := newS3Client(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewS3Storage(
+		s3Client,
+		cfg.Storage.S3.Bucket,
+	), nil
+}
+
+func newS3Client(cfg *Config) (*s3.S3, error) {
 	if cfg.Storage.S3.Bucket == "" {
 		return nil, fmt.Errorf("bucket name in config should not be empty")
 	}
@@ -174,9 +708,5 @@ func initS3Storage(cfg *Config) (storage.Storage, error) {
 		return nil, fmt.Errorf("fail to init aws session: %w", err)
 	}
 
-	s3Client := s3.New(sess, awsConfig)
-	return storage.NewS3Storage(
-		s3Client,
-		cfg.Storage.S3.Bucket,
-	), nil
+	return s3.New(sess, awsConfig), nil
 }